@@ -0,0 +1,216 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveCmd represents the archive command
+var archiveCmd = &cobra.Command{
+	Use:   "archive [DIR]",
+	Short: "move old messages into per-year archive folders",
+	Long: `
+Move the messages in the cur subdirectory of the specified maildir which are
+older than the cutoff into a folder for the year they were received, such as
+Archive/2021 (.Archive.2021 in the Maildir++ layout).  The default DIR is
+~/Maildir.  Archive folders are created and subscribed as needed, flags and
+keywords are kept, and messages are given new uids in the archive folder.
+Folders below the archive prefix are never archived.
+
+The cutoff is --before DATE, or --days N days ago.  Ages are taken from the
+delivery time in the filename unless --basis selects the Date header (date)
+or the file mod time (mtime); a message without a Date header falls back to
+its delivery time.  A message whose age cannot be read, such as one whose
+file cannot be decompressed, is logged and skipped.
+
+Flags:
+    --days N		    archive messages older than N days (default 365)
+    --before DATE	    archive messages older than DATE (YYYY-MM-DD)
+    --prefix FOLDER	    parent folder of the year folders (default Archive)
+    --basis BASIS	    age basis: delivered, date or mtime
+    --compress CODEC	    compress uncompressed messages with zstd or gzip
+    --recurse		    archive each maildir rooted at DIR
+    --dry-run		    report the messages each year folder would receive
+    --reset-index MODE	    remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ArchiveMessages(args))
+	},
+}
+
+// archiveCutoff returns the time before which messages are archived
+func archiveCutoff(now time.Time) (time.Time, error) {
+	before := viper.GetString("archive.before")
+	if before != "" {
+		cutoff, _, err := parseDate(before)
+		return cutoff, err
+	}
+	days := viper.GetInt("archive.days")
+	if days <= 0 {
+		return time.Time{}, fmt.Errorf("days must be positive")
+	}
+	return now.AddDate(0, 0, -days), nil
+}
+
+func ArchiveMessages(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	verbose := viper.GetBool("verbose")
+	prefix := strings.Trim(viper.GetString("archive.prefix"), "/")
+	basis := viper.GetString("archive.basis")
+	codec := viper.GetString("archive.compress")
+	if prefix == "" || strings.EqualFold(prefix, "INBOX") {
+		return fmt.Errorf("invalid archive prefix: '%s'", prefix)
+	}
+	switch basis {
+	case RetentionDelivered, RetentionDate, RetentionMtime:
+	default:
+		return fmt.Errorf("unknown age basis: %s", basis)
+	}
+	err := ValidateCodec(codec)
+	if err != nil {
+		return err
+	}
+	cutoff, err := archiveCutoff(time.Now())
+	if err != nil {
+		return err
+	}
+	root := MaildirRoot(args)
+	layout, err := openRootMaildir(root)
+	if err != nil {
+		return err
+	}
+	viper.Set("all", true)
+	dirs, err := SelectMaildirs(root)
+	if err != nil {
+		return err
+	}
+
+	// messages are collected by destination folder before any is moved
+	years := map[string][]*Message{}
+	for _, dir := range dirs {
+		folder := FolderName(root, dir)
+		if MatchFolder(prefix, folder) || strings.HasPrefix(folder, prefix+"/") {
+			continue
+		}
+		uidlist, err := ReadUidlist(dir)
+		if err != nil {
+			return err
+		}
+		files, err := ListMaildirFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, pathName := range *files {
+			msg, err := NewMessage(dir, folder, uidlist, pathName)
+			if err != nil {
+				return err
+			}
+			age, err := MessageAge(msg, basis)
+			if err != nil {
+				log.Printf("skipped %s: %v\n", pathName, err)
+				continue
+			}
+			if age.Before(cutoff) {
+				name := fmt.Sprintf("%s/%d", prefix, age.Year())
+				years[name] = append(years[name], msg)
+			}
+		}
+	}
+	names := []string{}
+	for name := range years {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if dryRun {
+		for _, name := range names {
+			fmt.Printf("would archive %d messages to %s\n", len(years[name]), name)
+		}
+		return nil
+	}
+
+	subscriptions, err := ReadSubscriptions(root, layout)
+	if err != nil {
+		return err
+	}
+	subscribed := false
+	mover := NewMessageMover(root)
+	for _, name := range names {
+		dest := FolderPath(root, name)
+		err = MakeMaildir(root, dest)
+		if err != nil {
+			return err
+		}
+		if subscriptions.Add(EncodeFolderName(name)) {
+			subscribed = true
+		}
+		for _, msg := range years[name] {
+			target, err := mover.MoveCompressed(msg, dest, codec)
+			if err != nil {
+				mover.Close()
+				return err
+			}
+			if verbose {
+				log.Printf("moved %s -> %s\n", msg.File.Path, target)
+			}
+		}
+		fmt.Printf("archived %d messages to %s\n", len(years[name]), name)
+	}
+	err = mover.Close()
+	if err != nil {
+		return err
+	}
+	if subscribed {
+		return subscriptions.Write(root, layout)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(archiveCmd)
+	archiveCmd.Flags().Int("days", 365, "archive messages older than this many days")
+	viper.BindPFlag("archive.days", archiveCmd.Flags().Lookup("days"))
+	archiveCmd.Flags().String("before", "", "archive messages older than this date")
+	viper.BindPFlag("archive.before", archiveCmd.Flags().Lookup("before"))
+	archiveCmd.Flags().String("prefix", "Archive", "parent folder of the year folders")
+	viper.BindPFlag("archive.prefix", archiveCmd.Flags().Lookup("prefix"))
+	archiveCmd.Flags().String("basis", RetentionDelivered, "age basis: delivered, date or mtime")
+	viper.BindPFlag("archive.basis", archiveCmd.Flags().Lookup("basis"))
+	archiveCmd.Flags().String("compress", "", "compress archived messages with zstd or gzip")
+	viper.BindPFlag("archive.compress", archiveCmd.Flags().Lookup("compress"))
+}
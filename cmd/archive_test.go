@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveMessages(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("archive.before", "2024-05-01")
+	viper.Set("archive.prefix", "Archive")
+	viper.Set("archive.basis", RetentionDelivered)
+	viper.Set("archive.compress", "gzip")
+	viper.Set("recurse", true)
+
+	viper.Set("dry-run", true)
+	require.Nil(t, ArchiveMessages([]string{root}))
+	findTestMessage(t, root, "1711929600.M2P1.host")
+
+	viper.Set("dry-run", false)
+	require.Nil(t, ArchiveMessages([]string{root}))
+	files, err := filepath.Glob(filepath.Join(root, "cur", "*"))
+	require.Nil(t, err)
+	require.Empty(t, files)
+	dest := filepath.Join(root, ".Archive.2024")
+	require.FileExists(t, filepath.Join(dest, "maildirfolder"))
+	plain := findTestMessage(t, dest, "1711929600.M2P1.host")
+	_, cmpType, err := ReadMessage(plain)
+	require.Nil(t, err)
+	require.Equal(t, "gzip", cmpType)
+	require.Contains(t, findTestMessage(t, dest, "1709251200.M1P1.host"), ":2,S")
+
+	// messages already below the prefix stay where they are
+	findTestMessage(t, filepath.Join(root, ".Archive"), "1704067200.M3P1.host")
+	uidlist, err := ReadUidlist(root)
+	require.Nil(t, err)
+	require.Empty(t, uidlist.Entries)
+	// dovecot assigns the uids of a new folder when it first indexes it
+	require.NoFileExists(t, filepath.Join(dest, UidlistFile))
+	subscriptions, err := ReadSubscriptions(root, LayoutMaildirPlusPlus)
+	require.Nil(t, err)
+	require.True(t, subscriptions.Contains("Archive/2024"))
+}
+
+func TestArchiveCompressAddsSizes(t *testing.T) {
+	root := makeTestMaildir(t)
+	data := testMessage("carol@example.com", "old news", "Fri, 01 Jan 2021 00:00:00 +0000", "no sizes")
+	require.Nil(t, os.WriteFile(filepath.Join(root, "cur", "1609459200.M7P1.host:2,S"), data, 0600))
+	viper.Set("archive.before", "2022-01-01")
+	viper.Set("archive.prefix", "Archive")
+	viper.Set("archive.basis", RetentionDelivered)
+	viper.Set("archive.compress", "zstd")
+	require.Nil(t, ArchiveMessages([]string{root}))
+
+	size, sizeW := MessageSizes(data)
+	target := findTestMessage(t, filepath.Join(root, ".Archive.2021"), "1609459200.M7P1.host")
+	require.Equal(t, fmt.Sprintf("1609459200.M7P1.host,S=%d,W=%d:2,S", size, sizeW), filepath.Base(target))
+	_, cmpType, err := ReadMessage(target)
+	require.Nil(t, err)
+	require.Equal(t, "zstd", cmpType)
+}
+
+func TestArchiveSkipsUnreadable(t *testing.T) {
+	root := makeTestMaildir(t)
+	undated := []byte("From: carol@example.com\r\nSubject: no date\r\n\r\nundated\r\n")
+	writeTestMessage(t, root, "1609459200.M7P1.host", "", undated, "")
+	corrupt := filepath.Join(root, "cur", "1609459200.M8P1.host,S=40,W=42:2,")
+	require.Nil(t, os.WriteFile(corrupt, []byte("\x1f\x8b\x08\x00 not really gzip"), 0600))
+	viper.Set("archive.before", "2024-05-01")
+	viper.Set("archive.prefix", "Archive")
+	viper.Set("archive.basis", RetentionDate)
+	viper.Set("archive.compress", "")
+	viper.Set("recurse", false)
+	require.Nil(t, ArchiveMessages([]string{root}))
+
+	// an undated message is archived by its delivery time and an unreadable
+	// one is left in place
+	findTestMessage(t, filepath.Join(root, ".Archive.2021"), "1609459200.M7P1.host")
+	findTestMessage(t, filepath.Join(root, ".Archive.2024"), "1711929600.M2P1.host")
+	require.FileExists(t, corrupt)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ArchiveWriter adds files to a tar or zip archive
+type ArchiveWriter interface {
+	Add(name string, data []byte, modTime time.Time) error
+	Close() error
+}
+
+func NewArchiveWriter(w io.Writer, format string) (ArchiveWriter, error) {
+	switch format {
+	case "tar":
+		return &tarArchive{tar.NewWriter(w)}, nil
+	case "zip":
+		return &zipArchive{zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format: %s", format)
+}
+
+type tarArchive struct {
+	writer *tar.Writer
+}
+
+func (a *tarArchive) Add(name string, data []byte, modTime time.Time) error {
+	header := tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	err := a.writer.WriteHeader(&header)
+	if err != nil {
+		return fmt.Errorf("failed writing tar header: %v", err)
+	}
+	_, err = a.writer.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed writing tar data: %v", err)
+	}
+	return nil
+}
+
+func (a *tarArchive) Close() error {
+	err := a.writer.Close()
+	if err != nil {
+		return fmt.Errorf("failed closing tar archive: %v", err)
+	}
+	return nil
+}
+
+type zipArchive struct {
+	writer *zip.Writer
+}
+
+func (a *zipArchive) Add(name string, data []byte, modTime time.Time) error {
+	header := zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	header.SetMode(0600)
+	writer, err := a.writer.CreateHeader(&header)
+	if err != nil {
+		return fmt.Errorf("failed writing zip header: %v", err)
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed writing zip data: %v", err)
+	}
+	return nil
+}
+
+func (a *zipArchive) Close() error {
+	err := a.writer.Close()
+	if err != nil {
+		return fmt.Errorf("failed closing zip archive: %v", err)
+	}
+	return nil
+}
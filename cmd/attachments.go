@@ -0,0 +1,311 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// attachmentsCmd represents the attachments command
+var attachmentsCmd = &cobra.Command{
+	Use:   "attachments",
+	Short: "list, extract or strip message attachments",
+	Long: `
+List, extract or strip the attachments of the messages in the cur and new
+subdirectories of the specified maildir.  Attachments are the MIME parts of a
+message which are not text bodies.  Messages are decompressed on the fly.
+`,
+}
+
+// attachmentsListCmd represents the attachments list command
+var attachmentsListCmd = &cobra.Command{
+	Use:   "list [DIR]",
+	Short: "list message attachments",
+	Long: `
+Output the pathname, filename, MIME type and decoded size of each attachment
+of the messages in the specified maildir, separated by tabs.  The default
+DIR is ~/Maildir.
+
+Flags:
+    --recurse		list the attachments of all maildirs rooted at DIR
+    --query QUERY	list only the attachments of messages matching a find QUERY
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ListAttachments(args))
+	},
+}
+
+// attachmentsExtractCmd represents the attachments extract command
+var attachmentsExtractCmd = &cobra.Command{
+	Use:   "extract [DIR]",
+	Short: "write message attachments to files",
+	Long: `
+Write the decoded attachments of the messages in the specified maildir to
+OUTPUT/FOLDER/MESSAGE/FILENAME, where MESSAGE is the uid of the message or
+its unique base name.  Attachments without a filename are named partN by
+their position among the message's attachments, with an extension for their
+MIME type, and existing files are not overwritten.  The default DIR is
+~/Maildir.
+
+Flags:
+    --output PATH	output directory (default .)
+    --recurse		extract the attachments of all maildirs rooted at DIR
+    --query QUERY	extract only the attachments of messages matching a find QUERY
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ExtractAttachments(args))
+	},
+}
+
+// attachmentsStripCmd represents the attachments strip command
+var attachmentsStripCmd = &cobra.Command{
+	Use:   "strip [DIR]",
+	Short: "remove large attachments from messages",
+	Long: `
+Replace each attachment larger than SIZE in the messages of the specified
+maildir with a short text part naming the removed attachment.  The default
+DIR is ~/Maildir.  Each changed message is delivered again with corrected
+S= and W= sizes, its flags, mod time and compression codec, and a new uid so
+IMAP clients fetch the new content, and the original is removed.  Messages
+compressed with a codec which cannot be written are skipped.  maildirsize is
+updated with the space reclaimed.
+
+Flags:
+    --min-size SIZE	    strip attachments larger than SIZE, such as 500K or 5M (default 5M)
+    --recurse		    strip the attachments of all maildirs rooted at DIR
+    --query QUERY	    strip only the attachments of messages matching a find QUERY
+    --dry-run		    report the attachments which would be removed
+    --reset-index MODE	    remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(StripMessageAttachments(args))
+	},
+}
+
+// findAttachments calls fn with the attachments of each selected message
+func findAttachments(args []string, fn func(*Message, []*Part) error) error {
+	query, err := QueryFlag("attachments.query")
+	if err != nil {
+		return err
+	}
+	if !viper.GetBool("uncompressed") {
+		viper.Set("all", true)
+	}
+	return FindMessages(MaildirRoot(args), query, func(msg *Message) error {
+		data, _, err := ReadMessage(msg.File.Path)
+		if err != nil {
+			return err
+		}
+		parts := []*Part{}
+		err = WalkMessageParts(data, func(part *Part) error {
+			if part.IsAttachment() {
+				parts = append(parts, part)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", msg.File.Path, err)
+		}
+		if len(parts) == 0 {
+			return nil
+		}
+		return fn(msg, parts)
+	})
+}
+
+func ListAttachments(args []string) error {
+	return findAttachments(args, func(msg *Message, parts []*Part) error {
+		for _, part := range parts {
+			fmt.Printf("%s\t%s\t%s\t%d\n", msg.File.Path, part.Filename(), part.MediaType, len(part.Content))
+		}
+		return nil
+	})
+}
+
+// attachmentFilename returns a safe filename for the attachment at index
+func attachmentFilename(part *Part, index int) string {
+	name := strings.TrimSpace(part.Filename())
+	name = strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		name = fmt.Sprintf("part%d", index+1)
+		extensions, err := mime.ExtensionsByType(part.MediaType)
+		if err == nil && len(extensions) > 0 {
+			name += extensions[0]
+		}
+	}
+	return name
+}
+
+// createUnique creates a new file, adding a counter to the name if it exists
+func createUnique(dir, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		pathName := filepath.Join(dir, name)
+		if i > 0 {
+			pathName = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, i, ext))
+		}
+		file, err := os.OpenFile(pathName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil || !os.IsExist(err) {
+			return file, err
+		}
+	}
+}
+
+func ExtractAttachments(args []string) error {
+	verbose := viper.GetBool("verbose")
+	output := viper.GetString("attachments.output")
+	return findAttachments(args, func(msg *Message, parts []*Part) error {
+		name := msg.File.Base
+		if msg.Uid > 0 {
+			name = fmt.Sprintf("%d", msg.Uid)
+		}
+		dir := filepath.Join(output, filepath.FromSlash(msg.Folder), name)
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return fmt.Errorf("failed creating output directory: %v", err)
+		}
+		for i, part := range parts {
+			file, err := createUnique(dir, attachmentFilename(part, i))
+			if err != nil {
+				return fmt.Errorf("failed creating attachment file: %v", err)
+			}
+			_, err = file.Write(part.Content)
+			if err == nil {
+				err = file.Close()
+			} else {
+				file.Close()
+			}
+			if err != nil {
+				return fmt.Errorf("failed writing attachment file: %v", err)
+			}
+			fmt.Printf("%s\n", file.Name())
+			if verbose {
+				log.Printf("extracted %s %s from %s\n", part.MediaType, part.Filename(), msg.File.Path)
+			}
+		}
+		return nil
+	})
+}
+
+func printStrippedParts(parts []StrippedPart) {
+	for _, part := range parts {
+		fmt.Printf("  %s %s %d\n", part.Name, part.MediaType, part.Size)
+	}
+}
+
+func StripMessageAttachments(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	minSize, err := ParseSize(viper.GetString("attachments.min-size"))
+	if err != nil {
+		return err
+	}
+	query, err := QueryFlag("attachments.query")
+	if err != nil {
+		return err
+	}
+	root := MaildirRoot(args)
+	viper.Set("all", true)
+	now := time.Now()
+	mover := NewMessageMover(root)
+	var reclaimed int64
+	var count int
+	err = FindMessages(root, query, func(msg *Message) error {
+		data, cmpType, err := ReadMessage(msg.File.Path)
+		if err != nil {
+			return err
+		}
+		stripped, parts, err := StripAttachments(data, minSize, now)
+		if err != nil {
+			return fmt.Errorf("%s: %v", msg.File.Path, err)
+		}
+		if len(parts) == 0 {
+			return nil
+		}
+		if cmpType != "" && ValidateCodec(cmpType) != nil {
+			fmt.Printf("skipped %s: %s compression cannot be written\n", msg.File.Path, cmpType)
+			return nil
+		}
+		saved := int64(len(data) - len(stripped))
+		count += 1
+		reclaimed += saved
+		if dryRun {
+			fmt.Printf("would strip %d attachments, %d bytes from %s\n", len(parts), saved, msg.File.Path)
+			printStrippedParts(parts)
+			return nil
+		}
+		target, err := mover.Replace(msg, stripped, cmpType)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("stripped %d attachments, %d bytes from %s -> %s\n", len(parts), saved, msg.File.Path, target)
+		printStrippedParts(parts)
+		return nil
+	})
+	if err != nil {
+		mover.Close()
+		return err
+	}
+	if dryRun {
+		fmt.Printf("%d messages, %d bytes would be reclaimed\n", count, reclaimed)
+		return nil
+	}
+	err = mover.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d messages, %d bytes reclaimed\n", count, reclaimed)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(attachmentsCmd)
+	attachmentsCmd.AddCommand(attachmentsListCmd)
+	attachmentsCmd.AddCommand(attachmentsExtractCmd)
+	attachmentsCmd.AddCommand(attachmentsStripCmd)
+	attachmentsCmd.PersistentFlags().String("query", "", "select messages matching query")
+	viper.BindPFlag("attachments.query", attachmentsCmd.PersistentFlags().Lookup("query"))
+	attachmentsExtractCmd.Flags().StringP("output", "o", ".", "output directory")
+	viper.BindPFlag("attachments.output", attachmentsExtractCmd.Flags().Lookup("output"))
+	attachmentsStripCmd.Flags().String("min-size", "5M", "strip attachments larger than this size")
+	viper.BindPFlag("attachments.min-size", attachmentsStripCmd.Flags().Lookup("min-size"))
+}
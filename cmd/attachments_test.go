@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAttachmentMessage returns a multipart message with a text body, a
+// large pdf attachment and a small image
+func testAttachmentMessage() ([]byte, []byte) {
+	pdf := bytes.Repeat([]byte("%PDF-1.4 report data "), 200)
+	encoded := base64.StdEncoding.EncodeToString(pdf)
+	lines := []string{}
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+	message := strings.Join([]string{
+		"From: alice@example.com",
+		"To: user@example.com",
+		"Subject: quarterly report",
+		"Date: Fri, 05 Apr 2024 10:00:00 +0000",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=\"outer\"",
+		"",
+		"preamble",
+		"--outer",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"see attached",
+		"--outer",
+		"Content-Type: application/pdf; name=\"report.pdf\"",
+		"Content-Disposition: attachment; filename=\"report.pdf\"",
+		"Content-Transfer-Encoding: base64",
+		"",
+		strings.Join(lines, "\r\n"),
+		"--outer",
+		"Content-Type: image/png",
+		"Content-Transfer-Encoding: base64",
+		"",
+		base64.StdEncoding.EncodeToString([]byte("tiny png")),
+		"--outer--",
+		"",
+	}, "\r\n")
+	return []byte(message), pdf
+}
+
+func TestStripAttachments(t *testing.T) {
+	message, pdf := testAttachmentMessage()
+	stripped, parts, err := StripAttachments(message, 1024, time.Unix(1717200000, 0))
+	require.Nil(t, err)
+	require.Equal(t, []StrippedPart{{Name: "report.pdf", MediaType: "application/pdf", Size: int64(len(pdf))}}, parts)
+	require.Less(t, len(stripped), len(message)/2)
+	require.Contains(t, string(stripped), "[attachment \"report.pdf\" (application/pdf, 4200 bytes) removed")
+	require.Contains(t, string(stripped), "preamble\r\n--outer\r\n")
+	require.True(t, strings.HasSuffix(string(stripped), "--outer--\r\n"))
+
+	attachments := []string{}
+	require.Nil(t, WalkMessageParts(stripped, func(part *Part) error {
+		if part.IsAttachment() {
+			attachments = append(attachments, part.MediaType)
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"image/png"}, attachments)
+
+	unchanged, parts, err := StripAttachments(message, int64(len(pdf)), time.Now())
+	require.Nil(t, err)
+	require.Empty(t, parts)
+	require.Equal(t, message, unchanged)
+}
+
+func TestAttachmentsCommands(t *testing.T) {
+	root := makeTestMaildir(t)
+	message, pdf := testAttachmentMessage()
+	original := writeTestMessage(t, root, "1712311200.M5P1.host", "S", message, "zstd")
+	uidlist := "3 V1700000000 N4\n1 :1709251200.M1P1.host\n2 :1711929600.M2P1.host\n3 :" + strings.Split(filepath.Base(original), ":")[0] + "\n"
+	require.Nil(t, os.WriteFile(filepath.Join(root, UidlistFile), []byte(uidlist), 0600))
+	received := time.Unix(1712311200, 0)
+	require.Nil(t, os.Chtimes(original, received, received))
+	require.Nil(t, ListAttachments([]string{root}))
+
+	output := t.TempDir()
+	viper.Set("attachments.output", output)
+	require.Nil(t, ExtractAttachments([]string{root}))
+	extracted, err := os.ReadFile(filepath.Join(output, "INBOX", "3", "report.pdf"))
+	require.Nil(t, err)
+	require.Equal(t, pdf, extracted)
+	require.FileExists(t, filepath.Join(output, "INBOX", "3", "part2.png"))
+
+	viper.Set("attachments.min-size", "1K")
+	viper.Set("dry-run", true)
+	require.Nil(t, StripMessageAttachments([]string{root}))
+	require.FileExists(t, original)
+
+	viper.Set("dry-run", false)
+	require.Nil(t, StripMessageAttachments([]string{root}))
+	_, err = os.Stat(original)
+	require.True(t, os.IsNotExist(err))
+	// the stripped message is delivered again with a new uid
+	targets, err := filepath.Glob(filepath.Join(root, "cur", "1712311200.M*"))
+	require.Nil(t, err)
+	require.Len(t, targets, 1)
+	target := targets[0]
+	stat, err := os.Stat(target)
+	require.Nil(t, err)
+	require.True(t, received.Equal(stat.ModTime()))
+	data, cmpType, err := ReadMessage(target)
+	require.Nil(t, err)
+	require.Equal(t, "zstd", cmpType)
+	file, err := ParseMessageFile(target)
+	require.Nil(t, err)
+	require.Equal(t, int64(len(data)), file.Size)
+	require.Equal(t, "S", file.Flags)
+	list, err := ReadUidlist(root)
+	require.Nil(t, err)
+	uid, ok := list.Lookup(target)
+	require.True(t, ok)
+	require.Equal(t, uint32(4), uid)
+	require.Len(t, list.Entries, 3)
+	require.Equal(t, fmt.Sprintf("S=%d,W=%d", file.Size, file.SizeW), strings.SplitN(list.Entries[2].Name, ",", 2)[1])
+}
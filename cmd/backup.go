@@ -0,0 +1,277 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup DIR DEST",
+	Short: "incremental content-addressed backup of maildirs",
+	Long: `
+Back up all maildirs rooted at DIR into the backup directory DEST.  Each file
+is stored once in DEST/objects under the SHA-256 hash of its content, and a
+manifest of every maildir's files, flags, ownership, dovecot-uidlist and
+dovecot-keywords is written to DEST/snapshots.
+
+Later runs compare against the newest snapshot: unchanged files are not read
+again and messages whose flags changed are recorded as renames without
+copying.  Dovecot index files are not saved; dovecot rebuilds them.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(BackupMaildirs(args))
+	},
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore SNAPSHOT DIR",
+	Short: "restore maildirs from a backup snapshot",
+	Long: `
+Rebuild the maildir tree recorded in the snapshot manifest file SNAPSHOT at
+DIR, which must not exist or be empty.  File modes, modification times and
+ownership are restored; ownership changes require running as root.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(RestoreSnapshot(args))
+	},
+}
+
+func BackupMaildirs(args []string) error {
+	verbose := viper.GetBool("verbose")
+	root, dest := args[0], args[1]
+	viper.Set("recurse", true)
+	viper.Set("all", true)
+	previousPath, err := LatestSnapshot(dest)
+	if err != nil {
+		return err
+	}
+	var previous *Snapshot
+	if previousPath != "" {
+		previous, err = ReadSnapshot(previousPath)
+		if err != nil {
+			return err
+		}
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("failed resolving %s: %v", root, err)
+	}
+	snapshot := Snapshot{Created: time.Now(), Root: absRoot, Maildirs: []SnapshotMaildir{}, Renames: []SnapshotRename{}}
+	dirs, err := ListMaildirs(root)
+	if err != nil {
+		return err
+	}
+	fileCount, copyCount := 0, 0
+	var copySize int64
+	for _, dir := range *dirs {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return fmt.Errorf("failed resolving %s: %v", dir, err)
+		}
+		stat, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("Stat failed: %v", err)
+		}
+		sys := stat.Sys().(*syscall.Stat_t)
+		maildir := SnapshotMaildir{Path: rel, Mode: uint32(stat.Mode()), Uid: sys.Uid, Gid: sys.Gid, Files: []SnapshotFile{}}
+		names, err := maildirBackupFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			pathName := filepath.Join(dir, name)
+			stat, err := os.Stat(pathName)
+			if err != nil {
+				return fmt.Errorf("Stat failed: %v", err)
+			}
+			entry := newSnapshotFile(name, stat)
+			prev, exact := previous.lookup(filepath.Join(rel, name))
+			switch {
+			case prev != nil && exact && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime):
+				entry.Hash = prev.Hash
+			case prev != nil && !exact && prev.Size == entry.Size:
+				// maildir message content is immutable, only the flags changed
+				entry.Hash = prev.Hash
+				snapshot.Renames = append(snapshot.Renames, SnapshotRename{From: filepath.Join(rel, prev.Name), To: filepath.Join(rel, name)})
+			default:
+				hash, copied, err := StoreObject(dest, pathName)
+				if err != nil {
+					return err
+				}
+				entry.Hash = hash
+				if copied {
+					copyCount += 1
+					copySize += entry.Size
+					if verbose {
+						log.Printf("stored %s\n", pathName)
+					}
+				}
+			}
+			maildir.Files = append(maildir.Files, entry)
+			fileCount += 1
+		}
+		snapshot.Maildirs = append(snapshot.Maildirs, maildir)
+	}
+	pathName, err := snapshot.Write(dest)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("snapshot %s: %d files, %d new objects (%d bytes), %d renames\n", pathName, fileCount, copyCount, copySize, len(snapshot.Renames))
+	return nil
+}
+
+// maildirBackupFiles returns the message and metadata files of a maildir
+// relative to the maildir
+func maildirBackupFiles(dir string) ([]string, error) {
+	names := []string{}
+	files, err := ListMaildirFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range *files {
+		names = append(names, filepath.Join("cur", filepath.Base(file)))
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("ReadDir failed: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, filepath.Join("new", entry.Name()))
+		}
+	}
+	for _, name := range metadataFiles {
+		stat, err := os.Stat(filepath.Join(dir, name))
+		if err == nil && stat.Mode().IsRegular() {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// snapshotFileName cleans a snapshot filename, returning false unless it
+// names a message in cur or new or one of the maildir metadata files
+func snapshotFileName(name string) (string, bool) {
+	name = filepath.Clean(name)
+	if !filepath.IsLocal(name) {
+		return "", false
+	}
+	switch filepath.Dir(name) {
+	case "cur", "new":
+		return name, true
+	case ".":
+		for _, metadata := range metadataFiles {
+			if name == metadata {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func RestoreSnapshot(args []string) error {
+	verbose := viper.GetBool("verbose")
+	snapshotPath, dir := args[0], args[1]
+	snapshot, err := ReadSnapshot(snapshotPath)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Dir(filepath.Dir(snapshotPath))
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ReadDir failed: %v", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("restore directory is not empty: %s", dir)
+	}
+	count := 0
+	for _, maildir := range snapshot.Maildirs {
+		if !filepath.IsLocal(filepath.Clean(maildir.Path)) {
+			return fmt.Errorf("invalid maildir path in snapshot: %s", maildir.Path)
+		}
+		target := filepath.Join(dir, maildir.Path)
+		info := &snapshotFileInfo{mode: fs.FileMode(maildir.Mode), uid: maildir.Uid, gid: maildir.Gid}
+		for _, path := range []string{target, filepath.Join(target, "cur"), filepath.Join(target, "new"), filepath.Join(target, "tmp")} {
+			err := os.MkdirAll(path, info.mode.Perm())
+			if err != nil {
+				return fmt.Errorf("failed creating maildir: %v", err)
+			}
+			err = os.Chmod(path, info.mode.Perm())
+			if err != nil {
+				return fmt.Errorf("mode change failed on '%s': %v", path, err)
+			}
+			err = SetOwner(path, info)
+			if err != nil {
+				return err
+			}
+		}
+		for _, file := range maildir.Files {
+			name, ok := snapshotFileName(file.Name)
+			if !ok {
+				return fmt.Errorf("invalid filename in snapshot: %s", file.Name)
+			}
+			pathName := filepath.Join(target, name)
+			tmpPath := filepath.Join(target, "tmp", filepath.Base(name))
+			err := RestoreObject(dest, file.Hash, tmpPath, pathName)
+			if err != nil {
+				return err
+			}
+			err = SetStat(pathName, file.FileInfo())
+			if err != nil {
+				return err
+			}
+			count += 1
+			if verbose {
+				log.Printf("restored %s\n", pathName)
+			}
+		}
+	}
+	fmt.Printf("restored %d files from %s\n", count, snapshotPath)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
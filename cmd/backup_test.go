@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	root := makeTestMaildir(t)
+	dest := filepath.Join(t.TempDir(), "backup")
+	require.Nil(t, BackupMaildirs([]string{root, dest}))
+	first, err := LatestSnapshot(dest)
+	require.Nil(t, err)
+	snapshot, err := ReadSnapshot(first)
+	require.Nil(t, err)
+	require.Len(t, snapshot.Maildirs, 2)
+	require.Empty(t, snapshot.Renames)
+
+	// a flag change is recorded as a rename without a new object
+	files, err := filepath.Glob(filepath.Join(root, "cur", "1711929600*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	renamed := files[0] + "FS"
+	require.Nil(t, os.Rename(files[0], renamed))
+	objects, err := filepath.Glob(filepath.Join(dest, "objects", "*", "*"))
+	require.Nil(t, err)
+	require.Nil(t, BackupMaildirs([]string{root, dest}))
+	second, err := LatestSnapshot(dest)
+	require.Nil(t, err)
+	require.NotEqual(t, first, second)
+	snapshot, err = ReadSnapshot(second)
+	require.Nil(t, err)
+	require.Len(t, snapshot.Renames, 1)
+	require.Equal(t, filepath.Join("cur", filepath.Base(renamed)), snapshot.Renames[0].To)
+	after, err := filepath.Glob(filepath.Join(dest, "objects", "*", "*"))
+	require.Nil(t, err)
+	require.Equal(t, len(objects), len(after))
+
+	restored := filepath.Join(t.TempDir(), "Maildir")
+	require.Nil(t, RestoreSnapshot([]string{second, restored}))
+	original, err := os.ReadFile(renamed)
+	require.Nil(t, err)
+	copied, err := os.ReadFile(filepath.Join(restored, "cur", filepath.Base(renamed)))
+	require.Nil(t, err)
+	require.Equal(t, original, copied)
+	_, err = os.Stat(filepath.Join(restored, ".Archive", "tmp"))
+	require.Nil(t, err)
+	_, err = os.Stat(filepath.Join(restored, UidlistFile))
+	require.Nil(t, err)
+
+	require.NotNil(t, RestoreSnapshot([]string{second, restored}))
+}
+
+func TestRestoreObjectHashMismatch(t *testing.T) {
+	root := makeTestMaildir(t)
+	dest := filepath.Join(t.TempDir(), "backup")
+	pathName := findTestMessage(t, root, "1711929600.M2P1.host")
+	hash, _, err := StoreObject(dest, pathName)
+	require.Nil(t, err)
+	require.Nil(t, os.Chmod(ObjectPath(dest, hash), 0600))
+	require.Nil(t, os.WriteFile(ObjectPath(dest, hash), []byte("corrupt"), 0600))
+
+	restored := filepath.Join(t.TempDir(), "restored")
+	tmpPath := filepath.Join(t.TempDir(), "restoring")
+	require.NotNil(t, RestoreObject(dest, hash, tmpPath, restored))
+	require.NoFileExists(t, restored)
+	require.NoFileExists(t, tmpPath)
+}
+
+func TestSnapshotFileName(t *testing.T) {
+	for _, name := range []string{"cur/1711929600.M2P1.host..x,S=9:2,S", "new/1711929600.M2P1.host", "cur/../new/x", UidlistFile, "maildirsize"} {
+		_, ok := snapshotFileName(name)
+		require.True(t, ok, name)
+	}
+	for _, name := range []string{"../cur/x", "/etc/passwd", "cur/../../x", "tmp/x", "cur/sub/x", "cur", "notes.txt", ""} {
+		_, ok := snapshotFileName(name)
+		require.False(t, ok, name)
+	}
+
+	// filenames with two consecutive dots are restored
+	root := makeTestMaildir(t)
+	dotted := writeTestMessage(t, root, "1714521600.M4P1..host", "", testMessage("carol@example.com", "may notes", "Wed, 01 May 2024 10:00:00 +0000", "garden"), "")
+	dest := filepath.Join(t.TempDir(), "backup")
+	require.Nil(t, BackupMaildirs([]string{root, dest}))
+	snapshot, err := LatestSnapshot(dest)
+	require.Nil(t, err)
+	restored := filepath.Join(t.TempDir(), "Maildir")
+	require.Nil(t, RestoreSnapshot([]string{snapshot, restored}))
+	require.FileExists(t, filepath.Join(restored, "cur", filepath.Base(dotted)))
+}
@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
 	"os"
 )
 
@@ -46,3 +50,150 @@ func DetectCompressedFile(file *os.File) (*string, error) {
 	}
 	return nil, nil
 }
+
+// DetectCompressedData returns the compression type of data identified by
+// its magic bytes, or an empty string
+func DetectCompressedData(data []byte) string {
+	for name, magic := range magicBytes {
+		if bytes.HasPrefix(data, magic) {
+			return name
+		}
+	}
+	return ""
+}
+
+// DecompressData returns the uncompressed content of data and its detected
+// compression type
+func DecompressData(data []byte) ([]byte, string, error) {
+	cmpType := DetectCompressedData(data)
+	if cmpType == "" {
+		return data, "", nil
+	}
+	decoder, err := newDecoder(bytes.NewReader(data), cmpType)
+	if err != nil {
+		return nil, "", err
+	}
+	defer decoder.Close()
+	content, err := io.ReadAll(decoder)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed decompressing %s data: %v", cmpType, err)
+	}
+	return content, cmpType, nil
+}
+
+// OpenMessage returns a reader for the uncompressed content of a message file
+// and the detected compression type, which is empty for uncompressed files
+func OpenMessage(pathName string) (io.ReadCloser, string, error) {
+	file, err := os.Open(pathName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed opening message file: %v", err)
+	}
+	cmpType, err := DetectCompressedFile(file)
+	if err != nil {
+		file.Close()
+		return nil, "", fmt.Errorf("DetectCompressedFile: %v", err)
+	}
+	if cmpType == nil {
+		return file, "", nil
+	}
+	decoder, err := newDecoder(file, *cmpType)
+	if err != nil {
+		file.Close()
+		return nil, "", err
+	}
+	return &messageReader{decoder, file}, *cmpType, nil
+}
+
+// ReadMessage returns the uncompressed content of a message file and the
+// detected compression type
+func ReadMessage(pathName string) ([]byte, string, error) {
+	reader, cmpType, err := OpenMessage(pathName)
+	if err != nil {
+		return nil, "", err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed reading message %s: %v", pathName, err)
+	}
+	return data, cmpType, nil
+}
+
+type messageReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r *messageReader) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
+}
+
+func newDecoder(file io.Reader, cmpType string) (io.ReadCloser, error) {
+	switch cmpType {
+	case "zstd":
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed creating zstandard decoder: %v", err)
+		}
+		return decoder.IOReadCloser(), nil
+	case "gzip":
+		decoder, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed creating gzip decoder: %v", err)
+		}
+		return decoder, nil
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(file)), nil
+	}
+	return nil, fmt.Errorf("unknown compression type: %s", cmpType)
+}
+
+// compression codecs supported for writing message files
+var compressionCodecs []string = []string{"zstd", "gzip"}
+
+// CompressData compresses message data with the named codec
+func CompressData(data []byte, codec string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch codec {
+	case "zstd":
+		encoder, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed creating zstandard encoder: %v", err)
+		}
+		_, err = encoder.Write(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed writing zstandard compressed data: %v", err)
+		}
+		err = encoder.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed writing zstandard compressed data: %v", err)
+		}
+	case "gzip":
+		encoder := gzip.NewWriter(&buf)
+		_, err := encoder.Write(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed writing gzip compressed data: %v", err)
+		}
+		err = encoder.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed writing gzip compressed data: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression codec: %s", codec)
+	}
+	return buf.Bytes(), nil
+}
+
+// ValidateCodec returns an error unless codec is empty or a supported compression codec
+func ValidateCodec(codec string) error {
+	if codec == "" {
+		return nil
+	}
+	for _, name := range compressionCodecs {
+		if name == codec {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported compression codec: %s", codec)
+}
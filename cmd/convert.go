@@ -0,0 +1,537 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// convertCmd represents the convert command
+var convertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "convert between maildir and dovecot dbox formats",
+	Long: `
+Convert mailboxes between maildir and dovecot's sdbox and mdbox formats.
+Dovecot should not be running while mailboxes are converted.
+`,
+}
+
+// dbox2maildirCmd represents the convert dbox2maildir command
+var dbox2maildirCmd = &cobra.Command{
+	Use:   "dbox2maildir SRC [DIR]",
+	Short: "convert an sdbox or mdbox root to maildir",
+	Long: `
+Convert each mailbox below SRC/mailboxes of a dovecot sdbox or mdbox root
+into a folder of the root maildir DIR, which is created if necessary.  The
+default DIR is ~/Maildir.  mdbox is detected by SRC/storage/dovecot.map.index.
+
+Messages keep their uids, GUIDs and received times.  Flags and keywords are
+taken from the dovecot index of each mailbox, and messages are delivered with
+S= and W= sizes and uidlist entries.  Compressed dbox messages are
+decompressed.  Destination folders must not already contain messages.  The
+SRC/subscriptions file is merged into the subscriptions of DIR.
+
+Flags:
+    --compress CODEC	compress delivered messages with zstd or gzip
+    --dry-run		output the mailboxes and message counts only
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ConvertDboxToMaildir(args))
+	},
+}
+
+// maildir2sdboxCmd represents the convert maildir2sdbox command
+var maildir2sdboxCmd = &cobra.Command{
+	Use:   "maildir2sdbox DEST [DIR]",
+	Short: "convert a maildir to an sdbox root",
+	Long: `
+Write an sdbox root at DEST equivalent to the root maildir DIR.  The default
+DIR is ~/Maildir.  Each folder becomes DEST/mailboxes/NAME/dbox-Mails with a
+u.UID file for each message in its cur and new subdirectories and a
+dovecot.index holding the flags and keywords of the messages.  The maildir is
+not changed.
+
+Messages keep the uids and GUIDs of dovecot-uidlist; messages without a
+uidlist entry are given the next free uids.  The received time is taken from
+the file mod time.  Compressed messages stay compressed.  Destination
+mailboxes must not already contain messages.  The subscriptions of DIR are
+merged into DEST/subscriptions.
+
+Use --recurse to convert all folders of DIR and --folder to convert the
+named folder, with its subfolders if --recurse is also given.
+
+Flags:
+    --compress CODEC	compress uncompressed messages with zstd or gzip
+    --dry-run		output the folders and message counts only
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ConvertMaildirToSdbox(args))
+	},
+}
+
+// sdboxEntry is a maildir message with the uid and GUID it is converted with
+type sdboxEntry struct {
+	uid  uint32
+	guid string
+	file *MessageFile
+}
+
+// readSdboxEntries returns the messages in the cur and new subdirectories of
+// a maildir in uid order
+func readSdboxEntries(dir string) ([]sdboxEntry, *Uidlist, error) {
+	files, err := ListMessageFiles(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	uidlist, err := ReadUidlist(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := []sdboxEntry{}
+	unlisted := []*MessageFile{}
+	for _, pathName := range files {
+		file, err := ParseMessageFile(pathName)
+		if err != nil {
+			return nil, nil, err
+		}
+		uidlistEntry, ok := uidlist.Entry(pathName)
+		if !ok {
+			unlisted = append(unlisted, file)
+			continue
+		}
+		guid, _ := uidlistEntry.Field('G')
+		entries = append(entries, sdboxEntry{uid: uidlistEntry.Uid, guid: guid, file: file})
+	}
+	sort.Slice(unlisted, func(i, j int) bool { return unlisted[i].Name < unlisted[j].Name })
+	for _, file := range unlisted {
+		entries = append(entries, sdboxEntry{uid: uidlist.Append(file.Path), file: file})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].uid < entries[j].uid })
+	return entries, uidlist, nil
+}
+
+// convertSdboxMailbox writes the messages of a maildir into an sdbox mailbox
+// directory
+func convertSdboxMailbox(root, dir, dest, codec string, dryRun bool) error {
+	verbose := viper.GetBool("verbose")
+	name := FolderName(root, dir)
+	mailboxDir := DboxMailboxDir(dest, name)
+	entries, uidlist, err := readSdboxEntries(dir)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Printf("would convert %d messages from %s to %s\n", len(entries), name, mailboxDir)
+		return nil
+	}
+	existing, err := filepath.Glob(filepath.Join(mailboxDir, "u.*"))
+	if err != nil {
+		return fmt.Errorf("Glob failed: %v", err)
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("destination mailbox is not empty: %s", mailboxDir)
+	}
+	err = os.MkdirAll(mailboxDir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed creating mailbox: %v", err)
+	}
+	keywords, err := ReadKeywords(dir)
+	if err != nil {
+		return err
+	}
+	records := []IndexRecord{}
+	count := 0
+	for _, entry := range entries {
+		stat, err := os.Stat(entry.file.Path)
+		if err != nil {
+			return fmt.Errorf("Stat failed: %v", err)
+		}
+		content, cmpType, err := ReadMessage(entry.file.Path)
+		if err != nil {
+			return err
+		}
+		body := content
+		if cmpType != "" {
+			body, err = os.ReadFile(entry.file.Path)
+			if err != nil {
+				return fmt.Errorf("failed reading message: %v", err)
+			}
+		} else if codec != "" {
+			body, err = CompressData(content, codec)
+			if err != nil {
+				return err
+			}
+		}
+		guid := entry.guid
+		if guid == "" {
+			guid, err = NewDboxGuid()
+			if err != nil {
+				return err
+			}
+		}
+		size, sizeW := MessageSizes(content)
+		metadata := map[byte]string{
+			DboxMetaGuid:         guid,
+			DboxMetaReceived:     fmt.Sprintf("%x", stat.ModTime().Unix()),
+			DboxMetaPhysicalSize: fmt.Sprintf("%x", size),
+			DboxMetaVirtualSize:  fmt.Sprintf("%x", sizeW),
+		}
+		pathName, err := WriteSdboxFile(mailboxDir, entry.uid, body, metadata, stat.ModTime())
+		if err != nil {
+			return err
+		}
+		names, _ := entry.file.KeywordNames(keywords)
+		records = append(records, IndexRecord{Uid: entry.uid, Flags: maildirIndexFlags(entry.file.Flags), Keywords: names})
+		count += 1
+		if verbose {
+			log.Printf("converted %s %s\n", entry.file.Path, pathName)
+		}
+	}
+	uidValidity := uidlist.UidValidity
+	if uidValidity == 0 {
+		uidValidity = uint32(time.Now().Unix())
+	}
+	nextUid := uidlist.NextUid
+	if nextUid == 0 {
+		nextUid = 1
+	}
+	err = WriteMailIndex(mailboxDir, uidValidity, nextUid, records)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("converted %d messages from %s to %s\n", count, name, mailboxDir)
+	return nil
+}
+
+func ConvertMaildirToSdbox(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	codec := viper.GetString("convert.compress")
+	err := ValidateCodec(codec)
+	if err != nil {
+		return err
+	}
+	layout, err := FolderLayout()
+	if err != nil {
+		return err
+	}
+	viper.Set("all", true)
+	dest := args[0]
+	root := MaildirRoot(args[1:])
+	dirs, err := SelectMaildirs(root)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		err = os.MkdirAll(dest, 0700)
+		if err != nil {
+			return fmt.Errorf("failed creating sdbox root: %v", err)
+		}
+	}
+	for _, dir := range dirs {
+		err := convertSdboxMailbox(root, dir, dest, codec, dryRun)
+		if err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	subscriptions, err := ReadSubscriptions(root, layout)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions.Names) == 0 {
+		return nil
+	}
+	dboxSubscriptions, err := ReadSubscriptions(dest, LayoutFS)
+	if err != nil {
+		return err
+	}
+	for _, name := range subscriptions.Names {
+		dboxSubscriptions.Add(name)
+	}
+	return dboxSubscriptions.Write(dest, LayoutFS)
+}
+
+// dboxEntry is a dbox message with its uid and index record
+type dboxEntry struct {
+	uid     uint32
+	record  *IndexRecord
+	msg     *DboxMessage
+	modTime time.Time
+}
+
+// readDboxEntries returns the messages of a dbox mailbox in uid order
+func readDboxEntries(mailbox DboxMailbox, index *MailIndex, dboxMap *DboxMap) ([]dboxEntry, error) {
+	entries := []dboxEntry{}
+	if dboxMap != nil {
+		if index == nil {
+			return nil, fmt.Errorf("mdbox mailbox %s has no index", mailbox.Name)
+		}
+		for i := range index.Records {
+			record := &index.Records[i]
+			mapUid, ok := mdboxMapUid(record)
+			if !ok {
+				return nil, fmt.Errorf("mailbox %s uid %d has no map uid", mailbox.Name, record.Uid)
+			}
+			msg, err := dboxMap.Message(mapUid)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, dboxEntry{uid: record.Uid, record: record, msg: msg})
+		}
+		return entries, nil
+	}
+	files, err := filepath.Glob(filepath.Join(mailbox.Dir, "u.*"))
+	if err != nil {
+		return nil, fmt.Errorf("Glob failed: %v", err)
+	}
+	for _, pathName := range files {
+		uid, err := strconv.ParseUint(strings.TrimPrefix(filepath.Base(pathName), "u."), 10, 32)
+		if err != nil {
+			continue
+		}
+		file, err := ReadDboxFile(pathName)
+		if err != nil {
+			return nil, err
+		}
+		if len(file.Messages) != 1 {
+			return nil, fmt.Errorf("sdbox file %s contains %d messages", pathName, len(file.Messages))
+		}
+		stat, err := os.Stat(pathName)
+		if err != nil {
+			return nil, fmt.Errorf("Stat failed: %v", err)
+		}
+		entry := dboxEntry{uid: uint32(uid), msg: file.Messages[0], modTime: stat.ModTime()}
+		if index != nil {
+			entry.record, _ = index.Lookup(uint32(uid))
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].uid < entries[j].uid })
+	return entries, nil
+}
+
+// convertDboxMailbox delivers the messages of a dbox mailbox into a maildir
+// folder and returns their count and total size
+func convertDboxMailbox(root string, mailbox DboxMailbox, dboxMap *DboxMap, codec string, dryRun bool) (int64, int64, error) {
+	verbose := viper.GetBool("verbose")
+	index, err := ReadMailIndex(mailbox.Dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return 0, 0, err
+		}
+		index = nil
+	}
+	entries, err := readDboxEntries(mailbox, index, dboxMap)
+	if err != nil {
+		return 0, 0, err
+	}
+	dest := FolderPath(root, mailbox.Name)
+	if dryRun {
+		fmt.Printf("would convert %d messages from %s to %s\n", len(entries), mailbox.Name, dest)
+		return 0, 0, nil
+	}
+	err = MakeMaildir(root, dest)
+	if err != nil {
+		return 0, 0, err
+	}
+	uidlist, err := ReadUidlist(dest)
+	if err != nil {
+		return 0, 0, err
+	}
+	// messages delivered by a failed run have no uidlist entries yet
+	files, err := ListMessageFiles(dest)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(uidlist.Entries) > 0 || len(files) > 0 {
+		return 0, 0, fmt.Errorf("destination folder is not empty: %s", dest)
+	}
+	keywords, err := ReadKeywords(dest)
+	if err != nil {
+		return 0, 0, err
+	}
+	keywordsChanged := false
+	var count, size int64
+	for _, entry := range entries {
+		content, _, err := entry.msg.Content()
+		if err != nil {
+			return count, size, fmt.Errorf("mailbox %s uid %d: %v", mailbox.Name, entry.uid, err)
+		}
+		received, ok := entry.msg.Received()
+		if !ok {
+			received = entry.modTime
+		}
+		if received.IsZero() {
+			received = time.Now()
+		}
+		flags := ""
+		if entry.record != nil {
+			flags = IndexFlags(entry.record.Flags)
+			for _, name := range entry.record.Keywords {
+				letter, added, err := keywords.Add(name)
+				if err != nil {
+					return count, size, fmt.Errorf("%s: %v", mailbox.Name, err)
+				}
+				keywordsChanged = keywordsChanged || added
+				flags += string(letter)
+			}
+		}
+		pathName, err := DeliverMessage(dest, content, flags, received, codec)
+		if err != nil {
+			return count, size, err
+		}
+		fields := []string{}
+		if entry.msg.Guid() != "" {
+			fields = append(fields, "G"+entry.msg.Guid())
+		}
+		err = uidlist.AppendUid(pathName, entry.uid, fields...)
+		if err != nil {
+			return count, size, err
+		}
+		count += 1
+		size += int64(len(content))
+		if verbose {
+			log.Printf("converted uid=%d %s\n", entry.uid, pathName)
+		}
+	}
+	if index != nil {
+		if index.Header.UidValidity != 0 {
+			uidlist.UidValidity = index.Header.UidValidity
+		}
+		if index.Header.NextUid > uidlist.NextUid {
+			uidlist.NextUid = index.Header.NextUid
+		}
+	}
+	// the keywords are written first so that no message has an unmapped letter
+	if keywordsChanged {
+		err = keywords.Write(dest)
+		if err != nil {
+			return count, size, err
+		}
+	}
+	err = uidlist.Write(dest)
+	if err != nil {
+		return count, size, err
+	}
+	fmt.Printf("converted %d messages from %s to %s\n", count, mailbox.Name, dest)
+	return count, size, nil
+}
+
+func ConvertDboxToMaildir(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	codec := viper.GetString("convert.compress")
+	err := ValidateCodec(codec)
+	if err != nil {
+		return err
+	}
+	layout, err := FolderLayout()
+	if err != nil {
+		return err
+	}
+	src := args[0]
+	root := MaildirRoot(args[1:])
+	mailboxes, err := ListDboxMailboxes(src)
+	if err != nil {
+		return err
+	}
+	if len(mailboxes) == 0 {
+		return fmt.Errorf("no dbox mailboxes found below %s", src)
+	}
+	var dboxMap *DboxMap
+	if IsMdbox(src) {
+		dboxMap, err = ReadDboxMap(src)
+		if err != nil {
+			return err
+		}
+	}
+	if !dryRun {
+		err = os.MkdirAll(root, 0700)
+		if err != nil {
+			return fmt.Errorf("failed creating maildir: %v", err)
+		}
+		err = MakeMaildir(root, root)
+		if err != nil {
+			return err
+		}
+	}
+	var totalCount, totalSize int64
+	for _, mailbox := range mailboxes {
+		count, size, err := convertDboxMailbox(root, mailbox, dboxMap, codec, dryRun)
+		totalCount += count
+		totalSize += size
+		if err != nil {
+			AppendQuotaDelta(root, totalSize, totalCount)
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	dboxSubscriptions, err := ReadSubscriptions(src, LayoutFS)
+	if err != nil {
+		return err
+	}
+	if len(dboxSubscriptions.Names) > 0 {
+		subscriptions, err := ReadSubscriptions(root, layout)
+		if err != nil {
+			return err
+		}
+		for _, name := range dboxSubscriptions.Names {
+			subscriptions.Add(name)
+		}
+		err = subscriptions.Write(root, layout)
+		if err != nil {
+			return err
+		}
+	}
+	return AppendQuotaDelta(root, totalSize, totalCount)
+}
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+	convertCmd.AddCommand(dbox2maildirCmd)
+	convertCmd.AddCommand(maildir2sdboxCmd)
+	convertCmd.PersistentFlags().String("compress", "", "compress converted messages with zstd or gzip")
+	viper.BindPFlag("convert.compress", convertCmd.PersistentFlags().Lookup("compress"))
+}
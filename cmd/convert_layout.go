@@ -0,0 +1,239 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// convertLayoutCmd represents the convert-layout command
+var convertLayoutCmd = &cobra.Command{
+	Use:   "convert-layout [DIR]",
+	Short: "convert folders between Maildir++ and fs layouts",
+	Long: `
+Move every folder of the root maildir DIR from one dovecot directory layout
+to the other.  The default DIR is ~/Maildir.  In the Maildir++ layout folders
+are dot-directories below DIR such as .Archive.2024; with LAYOUT=fs they are
+nested directories such as Archive/2024.
+
+Folder directories are renamed together with their dovecot-uidlist,
+dovecot-keywords and index files, maildirfolder marker files are created
+where missing and a version 1 subscriptions file is rewritten with the new
+hierarchy separator.  Nothing is changed if any folder would collide with
+another folder, an existing directory or a cur, new or tmp directory.
+
+Flags:
+    --from LAYOUT	current layout: maildir++ or fs (default maildir++)
+    --to LAYOUT		new layout: maildir++ or fs (default fs)
+    --dry-run		output the renames without performing them
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ConvertLayout(args))
+	},
+}
+
+// LayoutMove is a folder directory rename performed by a layout conversion
+type LayoutMove struct {
+	Folder string
+	From   string
+	To     string
+	depth  int
+}
+
+func parseLayout(layout string) (string, error) {
+	switch strings.ToLower(layout) {
+	case LayoutMaildirPlusPlus:
+		return LayoutMaildirPlusPlus, nil
+	case LayoutFS:
+		return LayoutFS, nil
+	}
+	return "", fmt.Errorf("unknown maildir layout: %s", layout)
+}
+
+// PlanLayoutConversion returns the ordered folder renames converting the root
+// maildir between layouts, or an error if the result would have collisions
+func PlanLayoutConversion(root, from, to string) ([]LayoutMove, error) {
+	folders, err := ListLayoutFolders(root, from)
+	if err != nil {
+		return nil, err
+	}
+	sources := map[string]bool{}
+	for _, folder := range folders {
+		sources[folder.Path] = true
+	}
+	targets := map[string]string{}
+	moves := []LayoutMove{}
+	for _, folder := range folders {
+		if folder.Path == root {
+			continue
+		}
+		rel, err := filepath.Rel(root, folder.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed resolving %s: %v", folder.Path, err)
+		}
+		rel = filepath.ToSlash(rel)
+		if from == LayoutMaildirPlusPlus {
+			rel = strings.TrimPrefix(rel, ".")
+		}
+		components := strings.Split(rel, LayoutSeparator(from))
+		for _, component := range components {
+			if to == LayoutMaildirPlusPlus && strings.Contains(component, ".") {
+				return nil, fmt.Errorf("folder %s contains '.' which is the Maildir++ separator", folder.Name)
+			}
+			if to == LayoutFS && (component == "cur" || component == "new" || component == "tmp") {
+				return nil, fmt.Errorf("folder %s collides with a maildir subdirectory", folder.Name)
+			}
+		}
+		var target string
+		if to == LayoutFS {
+			target = filepath.Join(root, filepath.Join(components...))
+		} else {
+			target = filepath.Join(root, "."+strings.Join(components, "."))
+		}
+		previous, ok := targets[target]
+		if ok {
+			return nil, fmt.Errorf("folders %s and %s collide at %s", previous, folder.Name, target)
+		}
+		targets[target] = folder.Name
+		if target == folder.Path {
+			continue
+		}
+		_, err = os.Lstat(target)
+		if err == nil && !sources[target] {
+			return nil, fmt.Errorf("folder %s collides with existing %s", folder.Name, target)
+		}
+		moves = append(moves, LayoutMove{Folder: folder.Name, From: folder.Path, To: target, depth: len(components)})
+	}
+	// fs folders contain their children, so parents move first into fs and last out of it
+	sort.SliceStable(moves, func(i, j int) bool {
+		if to == LayoutFS {
+			return moves[i].depth < moves[j].depth
+		}
+		return moves[i].depth > moves[j].depth
+	})
+	return moves, nil
+}
+
+func ConvertLayout(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	from, err := parseLayout(viper.GetString("convert-layout.from"))
+	if err != nil {
+		return err
+	}
+	to, err := parseLayout(viper.GetString("convert-layout.to"))
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return fmt.Errorf("source and destination layouts are both %s", from)
+	}
+	root := MaildirRoot(args)
+	maildir, err := IsMaildir(root)
+	if err != nil {
+		return err
+	}
+	if !maildir {
+		return fmt.Errorf("not a maildir: %s", root)
+	}
+	subscriptions, err := ReadSubscriptions(root, from)
+	if err != nil {
+		return err
+	}
+	moves, err := PlanLayoutConversion(root, from, to)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	for _, move := range moves {
+		if dryRun {
+			fmt.Printf("would rename %s -> %s\n", move.From, move.To)
+			continue
+		}
+		for _, parent := range parentDirs(root, filepath.Dir(move.To)) {
+			err := os.Mkdir(parent, stat.Mode().Perm())
+			if err != nil {
+				if os.IsExist(err) {
+					continue
+				}
+				return fmt.Errorf("failed creating %s: %v", parent, err)
+			}
+			err = SetOwner(parent, stat)
+			if err != nil {
+				return err
+			}
+		}
+		err := os.Rename(move.From, move.To)
+		if err != nil {
+			return fmt.Errorf("failed renaming folder %s: %v", move.Folder, err)
+		}
+		err = MakeMaildir(root, move.To)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("renamed %s -> %s\n", move.From, move.To)
+	}
+	if dryRun {
+		return nil
+	}
+	// remove directories left empty by moving their folders out
+	for _, move := range moves {
+		for dir := filepath.Dir(move.From); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	if subscriptions.Version == 1 && len(subscriptions.Names) > 0 {
+		err = subscriptions.Write(root, to)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(convertLayoutCmd)
+	convertLayoutCmd.Flags().String("from", LayoutMaildirPlusPlus, "current layout: maildir++ or fs")
+	viper.BindPFlag("convert-layout.from", convertLayoutCmd.Flags().Lookup("from"))
+	convertLayoutCmd.Flags().String("to", LayoutFS, "new layout: maildir++ or fs")
+	viper.BindPFlag("convert-layout.to", convertLayoutCmd.Flags().Lookup("to"))
+}
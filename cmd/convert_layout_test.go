@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertLayoutRoundTrip(t *testing.T) {
+	root := makeTestMaildir(t)
+	require.Nil(t, MakeMaildir(root, filepath.Join(root, ".Archive.2024")))
+	require.Nil(t, MakeMaildir(root, filepath.Join(root, ".Lists.dev")))
+	require.Nil(t, os.WriteFile(filepath.Join(root, ".Archive", UidlistFile), []byte("3 V1 N2\n1 :x\n"), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(root, SubscriptionsFile), []byte("Archive\nArchive.2024\nLists.dev\n"), 0600))
+
+	viper.Set("convert-layout.from", "maildir++")
+	viper.Set("convert-layout.to", "fs")
+	viper.Set("dry-run", true)
+	require.Nil(t, ConvertLayout([]string{root}))
+	_, err := os.Stat(filepath.Join(root, ".Archive.2024"))
+	require.Nil(t, err)
+
+	viper.Set("dry-run", false)
+	require.Nil(t, ConvertLayout([]string{root}))
+	for _, dir := range []string{"Archive", "Archive/2024", "Lists/dev"} {
+		maildir, err := IsMaildir(filepath.Join(root, dir))
+		require.Nil(t, err)
+		require.True(t, maildir, dir)
+	}
+	maildir, err := IsMaildir(filepath.Join(root, "Lists"))
+	require.Nil(t, err)
+	require.False(t, maildir)
+	_, err = os.Stat(filepath.Join(root, "Archive", UidlistFile))
+	require.Nil(t, err)
+	_, err = os.Stat(filepath.Join(root, "Archive", "2024", "maildirfolder"))
+	require.Nil(t, err)
+	subscriptions, err := os.ReadFile(filepath.Join(root, SubscriptionsFile))
+	require.Nil(t, err)
+	require.Equal(t, "Archive\nArchive/2024\nLists/dev\n", string(subscriptions))
+
+	viper.Set("convert-layout.from", "fs")
+	viper.Set("convert-layout.to", "maildir++")
+	require.Nil(t, ConvertLayout([]string{root}))
+	for _, dir := range []string{".Archive", ".Archive.2024", ".Lists.dev"} {
+		maildir, err := IsMaildir(filepath.Join(root, dir))
+		require.Nil(t, err)
+		require.True(t, maildir, dir)
+	}
+	_, err = os.Stat(filepath.Join(root, "Lists"))
+	require.True(t, os.IsNotExist(err))
+	subscriptions, err = os.ReadFile(filepath.Join(root, SubscriptionsFile))
+	require.Nil(t, err)
+	require.Equal(t, "Archive\nArchive.2024\nLists.dev\n", string(subscriptions))
+}
+
+func TestConvertLayoutCollisions(t *testing.T) {
+	root := makeTestMaildir(t)
+	require.Nil(t, MakeMaildir(root, filepath.Join(root, ".tmp")))
+	_, err := PlanLayoutConversion(root, LayoutMaildirPlusPlus, LayoutFS)
+	require.NotNil(t, err)
+
+	root = makeTestMaildir(t)
+	require.Nil(t, os.MkdirAll(filepath.Join(root, "Archive"), 0700))
+	_, err = PlanLayoutConversion(root, LayoutMaildirPlusPlus, LayoutFS)
+	require.NotNil(t, err)
+
+	root = makeTestMaildir(t)
+	require.Nil(t, os.RemoveAll(filepath.Join(root, ".Archive")))
+	require.Nil(t, MakeMaildir(root, filepath.Join(root, "a.b")))
+	require.Nil(t, MakeMaildir(root, filepath.Join(root, "a", "b")))
+	_, err = PlanLayoutConversion(root, LayoutFS, LayoutMaildirPlusPlus)
+	require.NotNil(t, err)
+}
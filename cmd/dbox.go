@@ -0,0 +1,391 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dbox file format values
+const (
+	dboxVersion           = 2
+	dboxMagicPre          = "\x01\x02"
+	dboxMagicPost         = "\n\x01\x03\n"
+	dboxMessageTypeNormal = 'N'
+	dboxMessageHeaderSize = 21
+	dboxMailsDir          = "dbox-Mails"
+	dboxMailboxesDir      = "mailboxes"
+	dboxStorageDir        = "storage"
+	dboxMapIndexFile      = "dovecot.map.index"
+)
+
+// dbox metadata keys
+const (
+	DboxMetaGuid         = 'G'
+	DboxMetaPop3Uidl     = 'P'
+	DboxMetaPop3Order    = 'O'
+	DboxMetaReceived     = 'R'
+	DboxMetaPhysicalSize = 'Z'
+	DboxMetaVirtualSize  = 'V'
+	DboxMetaOrigMailbox  = 'B'
+)
+
+// dboxMetaOrder is the order metadata records are written in
+var dboxMetaOrder = []byte{
+	DboxMetaGuid,
+	DboxMetaPop3Uidl,
+	DboxMetaPop3Order,
+	DboxMetaReceived,
+	DboxMetaPhysicalSize,
+	DboxMetaVirtualSize,
+	DboxMetaOrigMailbox,
+}
+
+// DboxMessage is a message stored in a dbox file
+type DboxMessage struct {
+	Offset   int64
+	Body     []byte
+	Metadata map[byte]string
+}
+
+// Guid returns the message GUID recorded in the metadata
+func (m *DboxMessage) Guid() string {
+	return m.Metadata[DboxMetaGuid]
+}
+
+// Received returns the received time recorded in the metadata
+func (m *DboxMessage) Received() (time.Time, bool) {
+	value, ok := m.Metadata[DboxMetaReceived]
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, err := strconv.ParseInt(value, 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(stamp, 0), true
+}
+
+// Content returns the message data with any compression removed and the
+// compression type
+func (m *DboxMessage) Content() ([]byte, string, error) {
+	return DecompressData(m.Body)
+}
+
+// DboxFile is a parsed sdbox u.UID or mdbox m.N file
+type DboxFile struct {
+	Path        string
+	HeaderSize  int
+	CreateStamp time.Time
+	Messages    []*DboxMessage
+}
+
+// ReadDboxFile reads every message of a dbox file
+func ReadDboxFile(pathName string) (*DboxFile, error) {
+	data, err := os.ReadFile(pathName)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading dbox file: %v", err)
+	}
+	file, err := ParseDboxFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pathName, err)
+	}
+	file.Path = pathName
+	return file, nil
+}
+
+// ParseDboxFile parses the contents of a dbox file
+func ParseDboxFile(data []byte) (*DboxFile, error) {
+	line, _, found := bytes.Cut(data, []byte("\n"))
+	if !found {
+		return nil, fmt.Errorf("missing dbox file header")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 || fields[0] != strconv.Itoa(dboxVersion) {
+		return nil, fmt.Errorf("unsupported dbox file header: %s", string(line))
+	}
+	file := DboxFile{Messages: []*DboxMessage{}}
+	for _, field := range fields[1:] {
+		value, err := strconv.ParseInt(field[1:], 16, 64)
+		if err != nil {
+			continue
+		}
+		switch field[0] {
+		case 'M':
+			file.HeaderSize = int(value)
+		case 'C':
+			file.CreateStamp = time.Unix(value, 0)
+		}
+	}
+	if file.HeaderSize < dboxMessageHeaderSize {
+		return nil, fmt.Errorf("invalid dbox message header size: %d", file.HeaderSize)
+	}
+	offset := len(line) + 1
+	for offset < len(data) {
+		msg, next, err := file.parseMessage(data, offset)
+		if err != nil {
+			return nil, fmt.Errorf("message at offset %d: %v", offset, err)
+		}
+		file.Messages = append(file.Messages, msg)
+		offset = next
+	}
+	return &file, nil
+}
+
+// parseMessage parses the message header, body and metadata at offset and
+// returns the offset following the metadata
+func (f *DboxFile) parseMessage(data []byte, offset int) (*DboxMessage, int, error) {
+	if offset+f.HeaderSize > len(data) {
+		return nil, 0, fmt.Errorf("message header truncated")
+	}
+	header := data[offset : offset+f.HeaderSize]
+	if string(header[:2]) != dboxMagicPre || header[2] != dboxMessageTypeNormal {
+		return nil, 0, fmt.Errorf("invalid message header")
+	}
+	// an unsigned size rejects the sign a corrupt header may carry
+	size, err := strconv.ParseUint(string(header[4:20]), 16, 63)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid message size: %v", err)
+	}
+	start := offset + f.HeaderSize
+	if size > uint64(len(data)-start) {
+		return nil, 0, fmt.Errorf("message body truncated")
+	}
+	end := start + int(size)
+	if end+len(dboxMagicPost) > len(data) {
+		return nil, 0, fmt.Errorf("message body truncated")
+	}
+	if string(data[end:end+len(dboxMagicPost)]) != dboxMagicPost {
+		return nil, 0, fmt.Errorf("missing metadata after message body")
+	}
+	msg := DboxMessage{Offset: int64(offset), Body: data[start:end], Metadata: map[byte]string{}}
+	pos := end + len(dboxMagicPost)
+	for {
+		line, _, found := bytes.Cut(data[pos:], []byte("\n"))
+		if !found {
+			return nil, 0, fmt.Errorf("metadata truncated")
+		}
+		pos += len(line) + 1
+		if len(line) == 0 {
+			break
+		}
+		// lines of spaces reserve room for later metadata
+		if line[0] != ' ' {
+			msg.Metadata[line[0]] = string(line[1:])
+		}
+	}
+	return &msg, pos, nil
+}
+
+// MessageAt returns the message whose header begins at offset
+func (f *DboxFile) MessageAt(offset int64) (*DboxMessage, bool) {
+	for _, msg := range f.Messages {
+		if msg.Offset == offset {
+			return msg, true
+		}
+	}
+	return nil, false
+}
+
+// NewDboxGuid returns a random 128 bit message GUID in hex
+func NewDboxGuid() (string, error) {
+	guid := make([]byte, 16)
+	_, err := rand.Read(guid)
+	if err != nil {
+		return "", fmt.Errorf("failed generating guid: %v", err)
+	}
+	return hex.EncodeToString(guid), nil
+}
+
+// FormatSdboxFile returns the contents of an sdbox file holding a single
+// message body, which may be compressed, and its metadata
+func FormatSdboxFile(body []byte, metadata map[byte]string, created time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d M%x C%x\n", dboxVersion, dboxMessageHeaderSize, created.Unix())
+	fmt.Fprintf(&buf, "%s%c %016x\n", dboxMagicPre, dboxMessageTypeNormal, len(body))
+	buf.Write(body)
+	buf.WriteString(dboxMagicPost)
+	for _, key := range dboxMetaOrder {
+		value, ok := metadata[key]
+		if ok {
+			fmt.Fprintf(&buf, "%c%s\n", key, value)
+		}
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// WriteSdboxFile writes the u.UID file of a message into an sdbox mailbox
+// directory, setting its mod time to the received time
+func WriteSdboxFile(dir string, uid uint32, body []byte, metadata map[byte]string, received time.Time) (string, error) {
+	pathName := filepath.Join(dir, fmt.Sprintf("u.%d", uid))
+	file, err := os.OpenFile(pathName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed creating sdbox file: %v", err)
+	}
+	_, err = file.Write(FormatSdboxFile(body, metadata, time.Now()))
+	if err != nil {
+		file.Close()
+		os.Remove(pathName)
+		return "", fmt.Errorf("failed writing sdbox file: %v", err)
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(pathName)
+		return "", fmt.Errorf("failed writing sdbox file: %v", err)
+	}
+	err = os.Chtimes(pathName, received, received)
+	if err != nil {
+		return "", fmt.Errorf("mod time change failed on '%s': %v", pathName, err)
+	}
+	return pathName, nil
+}
+
+// DboxMailboxDir returns the dbox-Mails directory of a mailbox of a dbox root
+func DboxMailboxDir(src, name string) string {
+	components := strings.Split(name, "/")
+	for i, component := range components {
+		components[i] = encodeFolderComponent(component)
+	}
+	return filepath.Join(src, dboxMailboxesDir, filepath.Join(components...), dboxMailsDir)
+}
+
+// DboxMailbox is a mailbox directory of a dbox root
+type DboxMailbox struct {
+	Name string
+	Dir  string
+}
+
+// ListDboxMailboxes returns the mailboxes below the mailboxes directory of a
+// dbox root; names use '/' between hierarchy levels and are decoded from
+// modified UTF-7 unless --utf8 is set
+func ListDboxMailboxes(src string) ([]DboxMailbox, error) {
+	top := filepath.Join(src, dboxMailboxesDir)
+	mailboxes := []DboxMailbox{}
+	err := filepath.WalkDir(top, func(pathName string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() || entry.Name() != dboxMailsDir {
+			return nil
+		}
+		rel, err := filepath.Rel(top, filepath.Dir(pathName))
+		if err != nil {
+			return err
+		}
+		components := strings.Split(filepath.ToSlash(rel), "/")
+		for i, component := range components {
+			components[i] = decodeFolderComponent(component)
+		}
+		mailboxes = append(mailboxes, DboxMailbox{Name: strings.Join(components, "/"), Dir: pathName})
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing dbox mailboxes: %v", err)
+	}
+	sort.Slice(mailboxes, func(i, j int) bool { return mailboxes[i].Name < mailboxes[j].Name })
+	return mailboxes, nil
+}
+
+// IsMdbox returns true if a dbox root has mdbox storage
+func IsMdbox(src string) bool {
+	_, err := os.Stat(filepath.Join(src, dboxStorageDir, dboxMapIndexFile))
+	return err == nil
+}
+
+// DboxMap locates the messages of an mdbox root in its storage files
+type DboxMap struct {
+	dir   string
+	index *MailIndex
+	files map[uint32]*DboxFile
+}
+
+// ReadDboxMap reads the map index of an mdbox root
+func ReadDboxMap(src string) (*DboxMap, error) {
+	dir := filepath.Join(src, dboxStorageDir)
+	data, err := os.ReadFile(filepath.Join(dir, dboxMapIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading map index: %v", err)
+	}
+	index := MailIndex{Extensions: []IndexExtension{}, Records: []IndexRecord{}}
+	err = index.parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", dboxMapIndexFile, err)
+	}
+	// the map index has its own transaction log
+	logs, err := readMapIndexLogs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, logFile := range logs {
+		err = index.apply(logFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DboxMap{dir: dir, index: &index, files: map[uint32]*DboxFile{}}, nil
+}
+
+func readMapIndexLogs(dir string) ([]*IndexLog, error) {
+	logs := []*IndexLog{}
+	for _, name := range []string{dboxMapIndexFile + ".log.2", dboxMapIndexFile + ".log"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed reading map index log: %v", err)
+		}
+		logFile, err := ParseIndexLog(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		logs = append(logs, logFile)
+	}
+	return logs, nil
+}
+
+// Message returns the stored message of a map uid
+func (m *DboxMap) Message(mapUid uint32) (*DboxMessage, error) {
+	record, ok := m.index.Lookup(mapUid)
+	if !ok {
+		return nil, fmt.Errorf("map uid %d not found in map index", mapUid)
+	}
+	location := record.Ext["map"]
+	if len(location) < 12 {
+		return nil, fmt.Errorf("map uid %d has no storage location", mapUid)
+	}
+	le := binary.LittleEndian
+	fileId, offset := le.Uint32(location), le.Uint32(location[4:])
+	file, ok := m.files[fileId]
+	if !ok {
+		var err error
+		file, err = ReadDboxFile(filepath.Join(m.dir, fmt.Sprintf("m.%d", fileId)))
+		if err != nil {
+			return nil, err
+		}
+		m.files[fileId] = file
+	}
+	msg, ok := file.MessageAt(int64(offset))
+	if !ok {
+		return nil, fmt.Errorf("map uid %d not found at offset %d of %s", mapUid, offset, file.Path)
+	}
+	return msg, nil
+}
+
+// mdboxMapUid returns the map uid of a mailbox index record
+func mdboxMapUid(record *IndexRecord) (uint32, bool) {
+	data, ok := record.Ext["mdbox"]
+	if !ok || len(data) < 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(data), true
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDboxFile returns an sdbox file holding a single message
+func testDboxFile(body []byte, guid string, received int64) []byte {
+	data := fmt.Sprintf("2 M15 C%x\n", received)
+	data += fmt.Sprintf("%sN %016x\n", dboxMagicPre, len(body))
+	data += string(body) + dboxMagicPost
+	data += fmt.Sprintf("G%s\nR%x\nZ%x\n%s\n\n", guid, received, len(body), strings.Repeat(" ", 8))
+	return []byte(data)
+}
+
+// makeTestSdbox creates an sdbox root with an INBOX of two messages and an
+// empty Archive mailbox
+func makeTestSdbox(t *testing.T) string {
+	src := filepath.Join(t.TempDir(), "sdbox")
+	inbox := filepath.Join(src, dboxMailboxesDir, "INBOX", dboxMailsDir)
+	archive := filepath.Join(src, dboxMailboxesDir, "Archive", dboxMailsDir)
+	require.Nil(t, os.MkdirAll(inbox, 0700))
+	require.Nil(t, os.MkdirAll(archive, 0700))
+	first := testMessage("alice@example.com", "march report", "Fri, 01 Mar 2024 10:00:00 +0000", "quarterly figures")
+	second, err := CompressData(testMessage("bob@example.com", "april plans", "Mon, 01 Apr 2024 10:00:00 +0000", "holiday schedule"), "gzip")
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(inbox, "u.1"), testDboxFile(first, "0123456789abcdef", 1709251200), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(inbox, "u.3"), testDboxFile(second, "fedcba9876543210", 1711929600), 0600))
+	writeTestIndex(t, inbox, []testIndexRecord{
+		{uid: 1, flags: indexFlagSeen, keywords: 1},
+		{uid: 3, flags: indexFlagFlagged},
+	})
+	require.Nil(t, os.WriteFile(filepath.Join(src, SubscriptionsFile), []byte("Archive\n"), 0600))
+	return src
+}
+
+func TestParseDboxFile(t *testing.T) {
+	body := []byte("Subject: test\r\n\r\nbody\r\n")
+	file, err := ParseDboxFile(testDboxFile(body, "0123456789abcdef", 1709251200))
+	require.Nil(t, err)
+	require.Equal(t, 21, file.HeaderSize)
+	require.Len(t, file.Messages, 1)
+	msg := file.Messages[0]
+	require.Equal(t, body, msg.Body)
+	require.Equal(t, "0123456789abcdef", msg.Guid())
+	received, ok := msg.Received()
+	require.True(t, ok)
+	require.Equal(t, int64(1709251200), received.Unix())
+
+	_, err = ParseDboxFile([]byte("1 M15\n"))
+	require.NotNil(t, err)
+	truncated := testDboxFile(body, "0123456789abcdef", 1709251200)
+	_, err = ParseDboxFile(truncated[:40])
+	require.NotNil(t, err)
+
+	// a corrupt signed or oversized body size is rejected
+	for _, size := range []string{"-000000000000001", "7fffffffffffffff"} {
+		corrupt := testDboxFile(body, "0123456789abcdef", 1709251200)
+		start := bytes.Index(corrupt, []byte("\x01\x02N ")) + 4
+		copy(corrupt[start:start+16], size)
+		_, err = ParseDboxFile(corrupt)
+		require.NotNil(t, err)
+	}
+}
+
+func TestConvertDboxToMaildir(t *testing.T) {
+	src := makeTestSdbox(t)
+	mailboxes, err := ListDboxMailboxes(src)
+	require.Nil(t, err)
+	require.Len(t, mailboxes, 2)
+	require.Equal(t, "Archive", mailboxes[0].Name)
+	require.False(t, IsMdbox(src))
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	root := filepath.Join(t.TempDir(), "Maildir")
+	viper.Set("dry-run", true)
+	require.Nil(t, ConvertDboxToMaildir([]string{src, root}))
+	_, err = os.Stat(root)
+	require.True(t, os.IsNotExist(err))
+
+	viper.Set("dry-run", false)
+	require.Nil(t, ConvertDboxToMaildir([]string{src, root}))
+	uidlist, err := ReadUidlist(root)
+	require.Nil(t, err)
+	require.Len(t, uidlist.Entries, 2)
+	require.Equal(t, uint32(1700000000), uidlist.UidValidity)
+	require.Equal(t, uint32(4), uidlist.NextUid)
+	require.Equal(t, uint32(3), uidlist.Entries[1].Uid)
+	require.Equal(t, []string{"Gfedcba9876543210"}, uidlist.Entries[1].Fields)
+
+	files, err := filepath.Glob(filepath.Join(root, "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 2)
+	flags := []string{}
+	for _, pathName := range files {
+		_, flag, _ := strings.Cut(filepath.Base(pathName), ":2,")
+		flags = append(flags, flag)
+		data, cmpType, err := ReadMessage(pathName)
+		require.Nil(t, err)
+		require.Equal(t, "", cmpType)
+		require.Contains(t, string(data), "Subject: ")
+	}
+	require.ElementsMatch(t, []string{"Sa", "F"}, flags)
+	keywords, err := ReadKeywords(root)
+	require.Nil(t, err)
+	require.Equal(t, "$Junk", keywords.Names[0])
+
+	_, err = os.Stat(filepath.Join(root, ".Archive", "cur"))
+	require.Nil(t, err)
+	subscriptions, err := ReadSubscriptions(root, LayoutMaildirPlusPlus)
+	require.Nil(t, err)
+	require.True(t, subscriptions.Contains("Archive"))
+
+	// converting again must not mix messages into populated folders, even
+	// when a failed run delivered messages without writing the uidlist
+	require.NotNil(t, ConvertDboxToMaildir([]string{src, root}))
+	require.Nil(t, os.Remove(filepath.Join(root, UidlistFile)))
+	require.NotNil(t, ConvertDboxToMaildir([]string{src, root}))
+	files, err = filepath.Glob(filepath.Join(root, "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 2)
+}
+
+// makeTestMdbox creates an mdbox root with an INBOX of uids 1 and 4 stored
+// in one storage file, with uid 4 expunged by the INBOX index log but still
+// held by the map
+func makeTestMdbox(t *testing.T) string {
+	src := filepath.Join(t.TempDir(), "mdbox")
+	storage := filepath.Join(src, dboxStorageDir)
+	inbox := DboxMailboxDir(src, "INBOX")
+	require.Nil(t, os.MkdirAll(storage, 0700))
+	require.Nil(t, os.MkdirAll(inbox, 0700))
+	first := testMessage("alice@example.com", "march report", "Fri, 01 Mar 2024 10:00:00 +0000", "quarterly figures")
+	second := testMessage("bob@example.com", "expunged note", "Mon, 01 Apr 2024 10:00:00 +0000", "already deleted")
+	data := testDboxFile(first, "0123456789abcdef", 1709251200)
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	offsets := []int{len(header) + 1, len(data)}
+	data = append(data, testDboxFile(second, "fedcba9876543210", 1711929600)[len(header)+1:]...)
+	require.Nil(t, os.WriteFile(filepath.Join(storage, "m.1"), data, 0600))
+
+	le := binary.LittleEndian
+	mapRecords := [][]byte{}
+	for i, offset := range offsets {
+		rec := make([]byte, 20)
+		le.PutUint32(rec, uint32(i+1))
+		le.PutUint32(rec[8:], 1)
+		le.PutUint32(rec[12:], uint32(offset))
+		mapRecords = append(mapRecords, rec)
+	}
+	writeTestIndexFile(t, filepath.Join(storage, dboxMapIndexFile), testIndexExtension("map", nil, 8, 12), 20, mapRecords)
+	mailboxRecords := [][]byte{}
+	for i, uid := range []uint32{1, 4} {
+		rec := make([]byte, 16)
+		le.PutUint32(rec, uid)
+		le.PutUint32(rec[8:], uint32(i+1))
+		mailboxRecords = append(mailboxRecords, rec)
+	}
+	writeTestIndexFile(t, filepath.Join(inbox, IndexFile), testIndexExtension("mdbox", nil, 8, 4), 16, mailboxRecords)
+	writeTestIndexLog(t, inbox)
+	return src
+}
+
+func TestConvertMdboxSkipsExpunged(t *testing.T) {
+	src := makeTestMdbox(t)
+	require.True(t, IsMdbox(src))
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	root := filepath.Join(t.TempDir(), "Maildir")
+	require.Nil(t, ConvertDboxToMaildir([]string{src, root}))
+	uidlist, err := ReadUidlist(root)
+	require.Nil(t, err)
+	require.Len(t, uidlist.Entries, 1)
+	require.Equal(t, uint32(1), uidlist.Entries[0].Uid)
+	files, err := filepath.Glob(filepath.Join(root, "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	data, _, err := ReadMessage(files[0])
+	require.Nil(t, err)
+	require.Contains(t, string(data), "march report")
+}
+
+func TestConvertMaildirToSdbox(t *testing.T) {
+	root := makeTestMaildir(t)
+	require.Nil(t, os.WriteFile(filepath.Join(root, KeywordsFile), []byte("0 $Junk\n"), 0600))
+	plain, err := filepath.Glob(filepath.Join(root, "cur", "1711929600.M2P1.host*"))
+	require.Nil(t, err)
+	require.Len(t, plain, 1)
+	require.Nil(t, os.Rename(plain[0], plain[0]+"a"))
+	require.Nil(t, os.WriteFile(filepath.Join(root, SubscriptionsFile), []byte("Archive\n"), 0600))
+	compressed, err := filepath.Glob(filepath.Join(root, "cur", "1709251200.M1P1.host*"))
+	require.Nil(t, err)
+	require.Len(t, compressed, 1)
+	received := time.Unix(1709251200, 0)
+	require.Nil(t, os.Chtimes(compressed[0], received, received))
+
+	unread := testMessage("carol@example.com", "unread", "Thu, 11 Jan 2024 19:06:40 +0000", "still in new")
+	require.Nil(t, os.WriteFile(filepath.Join(root, ".Archive", "new", "1705000000.M7P1.host"), unread, 0600))
+
+	dest := filepath.Join(t.TempDir(), "sdbox")
+	viper.Set("recurse", true)
+	require.Nil(t, ConvertMaildirToSdbox([]string{dest, root}))
+	mailboxes, err := ListDboxMailboxes(dest)
+	require.Nil(t, err)
+	require.Len(t, mailboxes, 2)
+	require.Equal(t, "INBOX", mailboxes[1].Name)
+
+	index, err := ReadMailIndex(mailboxes[1].Dir)
+	require.Nil(t, err)
+	require.Equal(t, uint32(1700000000), index.Header.UidValidity)
+	require.Equal(t, uint32(3), index.Header.NextUid)
+	require.Len(t, index.Records, 2)
+	require.Equal(t, "S", IndexFlags(index.Records[0].Flags))
+	require.Equal(t, []string{"$Junk"}, index.Records[1].Keywords)
+
+	file, err := ReadDboxFile(filepath.Join(mailboxes[1].Dir, "u.1"))
+	require.Nil(t, err)
+	require.Equal(t, "zstd", DetectCompressedData(file.Messages[0].Body))
+	require.Len(t, file.Messages[0].Guid(), 32)
+	stamp, ok := file.Messages[0].Received()
+	require.True(t, ok)
+	require.Equal(t, received.Unix(), stamp.Unix())
+
+	// messages without a uidlist entry are given new uids
+	index, err = ReadMailIndex(mailboxes[0].Dir)
+	require.Nil(t, err)
+	require.Len(t, index.Records, 2)
+	require.Equal(t, uint32(1), index.Records[0].Uid)
+	require.Equal(t, "FS", IndexFlags(index.Records[0].Flags))
+	require.Equal(t, uint32(2), index.Records[1].Uid)
+	require.Empty(t, IndexFlags(index.Records[1].Flags))
+	unreadFile, err := ReadDboxFile(filepath.Join(mailboxes[0].Dir, "u.2"))
+	require.Nil(t, err)
+	require.Equal(t, unread, unreadFile.Messages[0].Body)
+	subscriptions, err := ReadSubscriptions(dest, LayoutFS)
+	require.Nil(t, err)
+	require.True(t, subscriptions.Contains("Archive"))
+	require.NotNil(t, ConvertMaildirToSdbox([]string{dest, root}))
+
+	// the sdbox root converts back to the same messages
+	copied := filepath.Join(t.TempDir(), "Maildir")
+	require.Nil(t, ConvertDboxToMaildir([]string{dest, copied}))
+	uidlist, err := ReadUidlist(copied)
+	require.Nil(t, err)
+	require.Len(t, uidlist.Entries, 2)
+	guid, ok := uidlist.Entries[0].Field('G')
+	require.True(t, ok)
+	require.Equal(t, file.Messages[0].Guid(), guid)
+	files, err := filepath.Glob(filepath.Join(copied, "cur", "*:2,a"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+}
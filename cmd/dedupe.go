@@ -0,0 +1,238 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// dedupeCmd represents the dedupe command
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe [DIR]",
+	Short: "remove duplicate messages",
+	Long: `
+Find messages stored more than once in the cur and new subdirectories of the
+specified maildir.  The default DIR is ~/Maildir.  Messages are duplicates
+when their Message-ID and the SHA-256 hash of their decompressed content with
+normalized line endings are equal.  The copy with the most flags, then the
+lowest UID, is kept and the others are deleted, or moved below the quarantine
+directory.  Removed messages are dropped from dovecot-uidlist and maildirsize.
+
+Flags:
+    --recurse		    dedupe each maildir rooted at DIR
+    --dry-run		    report duplicates without removing them
+    --quarantine PATH	    move duplicates below PATH instead of deleting
+    --reset-index MODE	    remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(DedupeMaildirs(args))
+	},
+}
+
+// DuplicateKey returns the Message-ID and normalized content hash identifying a message
+func DuplicateKey(msg *Message) (string, error) {
+	data, _, err := ReadMessage(msg.File.Path)
+	if err != nil {
+		return "", err
+	}
+	messageId, err := msg.HeaderValue("Message-Id")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %x", strings.TrimSpace(messageId), sha256.Sum256(NormalizeMessage(data))), nil
+}
+
+// NormalizeMessage returns message data with LF line endings, without mbox
+// bookkeeping headers and without trailing blank lines
+func NormalizeMessage(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = StripHeaderFields(data, mboxHeaderFields...)
+	return append(bytes.TrimRight(data, "\n"), '\n')
+}
+
+// rankDuplicates orders duplicate messages with the copy to keep first
+func rankDuplicates(messages []*Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if len(a.File.Flags) != len(b.File.Flags) {
+			return len(a.File.Flags) > len(b.File.Flags)
+		}
+		if a.Uid != b.Uid {
+			if a.Uid == 0 || b.Uid == 0 {
+				return b.Uid == 0
+			}
+			return a.Uid < b.Uid
+		}
+		return a.File.Name < b.File.Name
+	})
+}
+
+func DedupeMaildirs(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	quarantine := viper.GetString("dedupe.quarantine")
+	root := MaildirRoot(args)
+	viper.Set("all", true)
+	maildirs := []string{}
+	messages := map[string][]*Message{}
+	err := FindMessages(root, nil, func(msg *Message) error {
+		_, ok := messages[msg.Maildir]
+		if !ok {
+			maildirs = append(maildirs, msg.Maildir)
+		}
+		messages[msg.Maildir] = append(messages[msg.Maildir], msg)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var removedSize, removedCount int64
+	groupCount := 0
+	for _, dir := range maildirs {
+		groups := map[string][]*Message{}
+		keys := []string{}
+		for _, msg := range messages[dir] {
+			key, err := DuplicateKey(msg)
+			if err != nil {
+				return err
+			}
+			_, ok := groups[key]
+			if !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], msg)
+		}
+		uidlist, err := ReadUidlist(dir)
+		if err != nil {
+			return err
+		}
+		var dirSize, dirCount int64
+		for _, key := range keys {
+			group := groups[key]
+			if len(group) < 2 {
+				continue
+			}
+			groupCount += 1
+			rankDuplicates(group)
+			fmt.Printf("keep %s\n", group[0].File.Path)
+			for _, msg := range group[1:] {
+				size, err := msg.Size()
+				if err != nil {
+					return err
+				}
+				if dryRun {
+					fmt.Printf("  would remove %s\n", msg.File.Path)
+				} else {
+					err = removeDuplicate(root, msg, quarantine)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("  removed %s\n", msg.File.Path)
+					uidlist.Remove(msg.File.Path)
+				}
+				dirSize += size
+				dirCount += 1
+			}
+		}
+		if dirCount > 0 && !dryRun {
+			_, err = os.Stat(filepath.Join(dir, UidlistFile))
+			if err == nil {
+				err = uidlist.Write(dir)
+				if err != nil {
+					return err
+				}
+			}
+			err = AppendQuotaDelta(root, -dirSize, -dirCount)
+			if err != nil {
+				return err
+			}
+			err = ResetIndexes([]string{dir})
+			if err != nil {
+				return err
+			}
+		}
+		removedSize += dirSize
+		removedCount += dirCount
+	}
+	fmt.Printf("%d duplicates in %d groups, %d bytes\n", removedCount, groupCount, removedSize)
+	return nil
+}
+
+func removeDuplicate(root string, msg *Message, quarantine string) error {
+	if quarantine == "" {
+		err := os.Remove(msg.File.Path)
+		if err != nil {
+			return fmt.Errorf("failed removing duplicate: %v", err)
+		}
+		return nil
+	}
+	rel, err := filepath.Rel(root, msg.File.Path)
+	if err != nil {
+		return fmt.Errorf("failed resolving %s: %v", msg.File.Path, err)
+	}
+	target := filepath.Join(quarantine, rel)
+	err = os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return fmt.Errorf("failed creating quarantine directory: %v", err)
+	}
+	err = os.Rename(msg.File.Path, target)
+	if err != nil {
+		// rename fails across filesystems, so fall back to copying
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("failed moving duplicate to quarantine: %v", err)
+		}
+		err = copyFile(msg.File.Path, fmt.Sprintf("%s.%d", target, os.Getpid()), target)
+		if err != nil {
+			return fmt.Errorf("failed moving duplicate to quarantine: %v", err)
+		}
+		err = os.Remove(msg.File.Path)
+		if err != nil {
+			return fmt.Errorf("failed removing quarantined duplicate: %v", err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(dedupeCmd)
+	dedupeCmd.Flags().String("quarantine", "", "move duplicates below this directory")
+	viper.BindPFlag("dedupe.quarantine", dedupeCmd.Flags().Lookup("quarantine"))
+}
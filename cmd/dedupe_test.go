@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	root := makeTestMaildir(t)
+	data := testMessage("bob@example.com", "april plans", "Mon, 01 Apr 2024 10:00:00 +0000", "holiday schedule")
+	flagged := writeTestMessage(t, root, "1711929700.M5P1.host", "FS", data, "zstd")
+	unseen := writeTestMessage(t, root, "1711929800.M6P1.host", "", data, "gzip")
+	uidlist := "3 V1700000000 N5 Gabcdef\n1 :1709251200.M1P1.host\n2 :1711929600.M2P1.host\n3 :1711929700.M5P1.host\n4 :1711929800.M6P1.host\n"
+	require.Nil(t, os.WriteFile(filepath.Join(root, UidlistFile), []byte(uidlist), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(root, MaildirsizeFile), []byte("0S,0C\n500 3\n"), 0600))
+
+	viper.Set("dry-run", true)
+	require.Nil(t, DedupeMaildirs([]string{root}))
+	_, err := os.Stat(unseen)
+	require.Nil(t, err)
+
+	viper.Set("dry-run", false)
+	require.Nil(t, DedupeMaildirs([]string{root}))
+	_, err = os.Stat(flagged)
+	require.Nil(t, err)
+	_, err = os.Stat(unseen)
+	require.True(t, os.IsNotExist(err))
+	files, err := filepath.Glob(filepath.Join(root, "cur", "1711929600*"))
+	require.Nil(t, err)
+	require.Empty(t, files)
+
+	list, err := ReadUidlist(root)
+	require.Nil(t, err)
+	require.Len(t, list.Entries, 2)
+	_, ok := list.Lookup(flagged)
+	require.True(t, ok)
+
+	quota, err := os.ReadFile(filepath.Join(root, MaildirsizeFile))
+	require.Nil(t, err)
+	require.Equal(t, "0S,0C\n500 3\n-330 -2\n", string(quota))
+}
+
+func TestRemoveDuplicateRenameError(t *testing.T) {
+	root := makeTestMaildir(t)
+	pathName := findTestMessage(t, root, "1711929600.M2P1.host")
+	msg, err := NewMessage(root, "INBOX", nil, pathName)
+	require.Nil(t, err)
+
+	// only a rename across filesystems falls back to copying
+	quarantine := t.TempDir()
+	blocked := filepath.Join(quarantine, "cur", filepath.Base(pathName))
+	require.Nil(t, os.MkdirAll(filepath.Join(blocked, "sub"), 0700))
+	require.NotNil(t, removeDuplicate(root, msg, quarantine))
+	require.FileExists(t, pathName)
+	require.DirExists(t, blocked)
+
+	require.Nil(t, os.RemoveAll(blocked))
+	require.Nil(t, removeDuplicate(root, msg, quarantine))
+	require.NoFileExists(t, pathName)
+	require.FileExists(t, blocked)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var deliveryCount int64
+
+// UniqueName returns a new maildir base name for a message received at the given time
+func UniqueName(received time.Time) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.ReplaceAll(host, "/", "\\057")
+	host = strings.ReplaceAll(host, ":", "\\072")
+	count := atomic.AddInt64(&deliveryCount, 1)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", received.Unix(), time.Now().Nanosecond()/1000, os.Getpid(), count, host)
+}
+
+// MessageSizes returns the S= size and the W= size with CRLF line endings of message data
+func MessageSizes(data []byte) (int64, int64) {
+	size := int64(len(data))
+	lines := int64(bytes.Count(data, []byte("\n")))
+	if size > 0 && data[size-1] != '\n' {
+		lines += 1
+	}
+	return size, size + lines
+}
+
+// DeliverMessage writes a message through the tmp subdirectory of a maildir
+// into cur with the given flags, compressing it with codec unless codec is
+// empty, and returns the new pathname
+func DeliverMessage(dir string, data []byte, flags string, received time.Time, codec string) (string, error) {
+	size, sizeW := MessageSizes(data)
+	name := fmt.Sprintf("%s,S=%d,W=%d", UniqueName(received), size, sizeW)
+	content := data
+	if codec != "" {
+		var err error
+		content, err = CompressData(data, codec)
+		if err != nil {
+			return "", err
+		}
+	}
+	stat, err := os.Stat(filepath.Join(dir, "cur"))
+	if err != nil {
+		return "", fmt.Errorf("Stat failed: %v", err)
+	}
+	tmpPath := filepath.Join(dir, "tmp", name)
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed creating message file: %v", err)
+	}
+	_, err = file.Write(content)
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed writing message file: %v", err)
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed writing message file: %v", err)
+	}
+	err = SetOwner(tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	err = os.Chtimes(tmpPath, received, received)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("mod time change failed on '%s': %v", tmpPath, err)
+	}
+	curPath := filepath.Join(dir, "cur", name+":2,"+SortFlags(flags))
+	err = os.Rename(tmpPath, curPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed moving message to cur: %v", err)
+	}
+	return curPath, nil
+}
@@ -0,0 +1,368 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"sort"
+	"strings"
+)
+
+// diff statuses of folders and messages
+const (
+	DiffOnlyA   = "only-a"
+	DiffOnlyB   = "only-b"
+	DiffFlags   = "flags"
+	DiffContent = "content"
+	DiffMoved   = "moved"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff A B",
+	Short: "compare two root maildirs",
+	Long: `
+Compare the folders and the messages in the cur subdirectories of the root
+maildirs A and B.  Messages are matched within each folder by their unique
+base name, or by their decompressed content, so compressed and uncompressed
+copies of a message are equal.  Messages found in different folders of A and
+B are reported as moved.
+
+Reported differences are folders and messages present in one tree only,
+messages whose flags or keywords differ and messages whose decompressed
+content differs.  In text output '<' marks A only, '>' marks B only, and
+messages show the paths of both copies.
+
+Flags:
+    --format FORMAT	output text or json
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(DiffMaildirs(args))
+	},
+}
+
+// DiffReport is the json output of the diff command
+type DiffReport struct {
+	Folders  []DiffFolder  `json:"folders"`
+	Messages []DiffMessage `json:"messages"`
+}
+
+// DiffFolder is a folder present in one tree only
+type DiffFolder struct {
+	Folder string `json:"folder"`
+	Status string `json:"status"`
+}
+
+// DiffMessage is a message which differs between the trees
+type DiffMessage struct {
+	Status  string   `json:"status"`
+	FolderA string   `json:"folder_a,omitempty"`
+	FolderB string   `json:"folder_b,omitempty"`
+	PathA   string   `json:"path_a,omitempty"`
+	PathB   string   `json:"path_b,omitempty"`
+	FlagsA  []string `json:"flags_a,omitempty"`
+	FlagsB  []string `json:"flags_b,omitempty"`
+}
+
+// diffMessage is a message file with its lazily computed content hash
+type diffMessage struct {
+	msg  *Message
+	hash string
+}
+
+func (d *diffMessage) contentHash() (string, error) {
+	if d.hash == "" {
+		data, _, err := ReadMessage(d.msg.File.Path)
+		if err != nil {
+			return "", err
+		}
+		d.hash = fmt.Sprintf("%x", sha256.Sum256(data))
+	}
+	return d.hash, nil
+}
+
+// diffFolderMessages returns the messages of each folder of a root maildir
+func diffFolderMessages(root string) (map[string][]*diffMessage, []string, error) {
+	dirs, err := ListMaildirs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	messages := map[string][]*diffMessage{}
+	folders := []string{}
+	for _, dir := range *dirs {
+		folder := FolderName(root, dir)
+		folders = append(folders, folder)
+		uidlist, err := ReadUidlist(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		files, err := ListMaildirFiles(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		messages[folder] = []*diffMessage{}
+		for _, pathName := range *files {
+			msg, err := NewMessage(dir, folder, uidlist, pathName)
+			if err != nil {
+				return nil, nil, err
+			}
+			messages[folder] = append(messages[folder], &diffMessage{msg: msg})
+		}
+	}
+	return messages, folders, nil
+}
+
+// differ collects the differences and the unmatched messages of two trees
+type differ struct {
+	report DiffReport
+	onlyA  []*diffMessage
+	onlyB  []*diffMessage
+}
+
+// compare reports the flag and content differences of a matched message pair
+func (d *differ) compare(a, b *diffMessage, status string) error {
+	flagsA, err := SyncFlags(a.msg)
+	if err != nil {
+		return err
+	}
+	flagsB, err := SyncFlags(b.msg)
+	if err != nil {
+		return err
+	}
+	hashA, err := a.contentHash()
+	if err != nil {
+		return err
+	}
+	hashB, err := b.contentHash()
+	if err != nil {
+		return err
+	}
+	if status == "" {
+		switch {
+		case hashA != hashB:
+			status = DiffContent
+		case !sameFlags(flagsA, flagsB):
+			status = DiffFlags
+		default:
+			return nil
+		}
+	}
+	d.report.Messages = append(d.report.Messages, DiffMessage{
+		Status:  status,
+		FolderA: a.msg.Folder,
+		FolderB: b.msg.Folder,
+		PathA:   a.msg.File.Path,
+		PathB:   b.msg.File.Path,
+		FlagsA:  flagsA,
+		FlagsB:  flagsB,
+	})
+	return nil
+}
+
+// match pairs messages by base name and then by content, calling fn for each
+// pair, and returns the unmatched messages of each side
+func match(listA, listB []*diffMessage, fn func(a, b *diffMessage) error) ([]*diffMessage, []*diffMessage, error) {
+	byBase := map[string]*diffMessage{}
+	for _, b := range listB {
+		byBase[b.msg.File.Base] = b
+	}
+	restA := []*diffMessage{}
+	for _, a := range listA {
+		b, ok := byBase[a.msg.File.Base]
+		if !ok {
+			restA = append(restA, a)
+			continue
+		}
+		delete(byBase, a.msg.File.Base)
+		err := fn(a, b)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	byHash := map[string][]*diffMessage{}
+	for _, b := range listB {
+		_, ok := byBase[b.msg.File.Base]
+		if !ok {
+			continue
+		}
+		hash, err := b.contentHash()
+		if err != nil {
+			return nil, nil, err
+		}
+		byHash[hash] = append(byHash[hash], b)
+	}
+	unmatchedA := []*diffMessage{}
+	for _, a := range restA {
+		hash, err := a.contentHash()
+		if err != nil {
+			return nil, nil, err
+		}
+		matches := byHash[hash]
+		if len(matches) == 0 {
+			unmatchedA = append(unmatchedA, a)
+			continue
+		}
+		byHash[hash] = matches[1:]
+		delete(byBase, matches[0].msg.File.Base)
+		err = fn(a, matches[0])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	unmatchedB := []*diffMessage{}
+	for _, b := range listB {
+		_, ok := byBase[b.msg.File.Base]
+		if ok {
+			unmatchedB = append(unmatchedB, b)
+		}
+	}
+	return unmatchedA, unmatchedB, nil
+}
+
+// Diff compares two root maildirs
+func Diff(a, b string) (*DiffReport, error) {
+	recurse := viper.GetBool("recurse")
+	viper.Set("recurse", true)
+	defer viper.Set("recurse", recurse)
+	viper.Set("all", true)
+	messagesA, foldersA, err := diffFolderMessages(a)
+	if err != nil {
+		return nil, err
+	}
+	messagesB, foldersB, err := diffFolderMessages(b)
+	if err != nil {
+		return nil, err
+	}
+	return diffTrees(messagesA, foldersA, messagesB, foldersB)
+}
+
+func diffTrees(messagesA map[string][]*diffMessage, foldersA []string, messagesB map[string][]*diffMessage, foldersB []string) (*DiffReport, error) {
+	d := differ{report: DiffReport{Folders: []DiffFolder{}, Messages: []DiffMessage{}}}
+	folders := append(append([]string{}, foldersA...), foldersB...)
+	sort.Strings(folders)
+	for i, folder := range folders {
+		if i > 0 && folders[i-1] == folder {
+			continue
+		}
+		listA, inA := messagesA[folder]
+		listB, inB := messagesB[folder]
+		if !inA {
+			d.report.Folders = append(d.report.Folders, DiffFolder{Folder: folder, Status: DiffOnlyB})
+		}
+		if !inB {
+			d.report.Folders = append(d.report.Folders, DiffFolder{Folder: folder, Status: DiffOnlyA})
+		}
+		unmatchedA, unmatchedB, err := match(listA, listB, func(a, b *diffMessage) error {
+			return d.compare(a, b, "")
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.onlyA = append(d.onlyA, unmatchedA...)
+		d.onlyB = append(d.onlyB, unmatchedB...)
+	}
+
+	// messages missing from their folder may be in another one
+	unmatchedA, unmatchedB, err := match(d.onlyA, d.onlyB, func(a, b *diffMessage) error {
+		return d.compare(a, b, DiffMoved)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range unmatchedA {
+		flags, err := SyncFlags(a.msg)
+		if err != nil {
+			return nil, err
+		}
+		d.report.Messages = append(d.report.Messages, DiffMessage{Status: DiffOnlyA, FolderA: a.msg.Folder, PathA: a.msg.File.Path, FlagsA: flags})
+	}
+	for _, b := range unmatchedB {
+		flags, err := SyncFlags(b.msg)
+		if err != nil {
+			return nil, err
+		}
+		d.report.Messages = append(d.report.Messages, DiffMessage{Status: DiffOnlyB, FolderB: b.msg.Folder, PathB: b.msg.File.Path, FlagsB: flags})
+	}
+	return &d.report, nil
+}
+
+// Text returns the human-readable lines of a diff report
+func (r *DiffReport) Text() []string {
+	lines := []string{}
+	for _, folder := range r.Folders {
+		marker := "<"
+		if folder.Status == DiffOnlyB {
+			marker = ">"
+		}
+		lines = append(lines, fmt.Sprintf("%s folder %s", marker, folder.Folder))
+	}
+	for _, msg := range r.Messages {
+		switch msg.Status {
+		case DiffOnlyA:
+			lines = append(lines, fmt.Sprintf("< %s", msg.PathA))
+		case DiffOnlyB:
+			lines = append(lines, fmt.Sprintf("> %s", msg.PathB))
+		case DiffFlags:
+			lines = append(lines, fmt.Sprintf("flags %s [%s] %s [%s]", msg.PathA, strings.Join(msg.FlagsA, " "), msg.PathB, strings.Join(msg.FlagsB, " ")))
+		default:
+			lines = append(lines, fmt.Sprintf("%s %s %s", msg.Status, msg.PathA, msg.PathB))
+		}
+	}
+	return lines
+}
+
+func DiffMaildirs(args []string) error {
+	format := viper.GetString("diff.format")
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+	report, err := Diff(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		return PrintJSON(report)
+	}
+	for _, line := range report.Text() {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().String("format", "text", "output format: text or json")
+	viper.BindPFlag("diff.format", diffCmd.Flags().Lookup("format"))
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffMaildirs(t *testing.T) {
+	b := makeTestMaildir(t)
+	a := makeTestMaildir(t)
+	report, err := Diff(a, b)
+	require.Nil(t, err)
+	require.Empty(t, report.Folders)
+	require.Empty(t, report.Messages)
+
+	// an uncompressed twin under another name is equal
+	require.Nil(t, os.Remove(findTestMessage(t, b, "1709251200.M1P1.host")))
+	writeTestMessage(t, b, "1709251200.M9P9.host", "S", testMessage("alice@example.com", "march report", "Fri, 01 Mar 2024 10:00:00 +0000", "quarterly figures"), "")
+	renameTestMessage(t, b, "1711929600.M2P1.host", "F")
+	require.Nil(t, os.Rename(filepath.Join(b, ".Archive"), filepath.Join(b, ".Old")))
+	writeTestMessage(t, b, "1714521600.M4P1.host", "", testMessage("carol@example.com", "may notes", "Wed, 01 May 2024 10:00:00 +0000", "garden"), "")
+	writeTestMessage(t, a, "1717200000.M5P1.host", "", testMessage("dave@example.com", "june", "Sat, 01 Jun 2024 10:00:00 +0000", "draft one"), "")
+	writeTestMessage(t, b, "1717200000.M5P1.host", "", testMessage("dave@example.com", "june", "Sat, 01 Jun 2024 10:00:00 +0000", "draft two"), "")
+
+	report, err = Diff(a, b)
+	require.Nil(t, err)
+	require.Equal(t, []DiffFolder{{Folder: "Archive", Status: DiffOnlyA}, {Folder: "Old", Status: DiffOnlyB}}, report.Folders)
+	statuses := map[string]DiffMessage{}
+	for _, msg := range report.Messages {
+		statuses[msg.Status] = msg
+	}
+	require.Len(t, report.Messages, 4)
+	require.Equal(t, []string{"\\flagged"}, statuses[DiffFlags].FlagsB)
+	require.Contains(t, statuses[DiffContent].PathA, "1717200000.M5P1.host")
+	require.Equal(t, "Old", statuses[DiffMoved].FolderB)
+	require.Contains(t, statuses[DiffOnlyB].PathB, "1714521600.M4P1.host")
+	require.Len(t, report.Text(), 6)
+	require.Nil(t, PrintJSON(report))
+}
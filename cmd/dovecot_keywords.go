@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const KeywordsFile = "dovecot-keywords"
+
+// dovecot maps at most 26 keywords per maildir to the letters a-z
+const maxKeywords = 26
+
+// Keywords holds the dovecot-keywords mapping of lowercase maildir flag
+// letters to custom IMAP keywords; index 0 is the letter 'a'
+type Keywords struct {
+	Names [maxKeywords]string
+}
+
+// ReadKeywords reads the dovecot-keywords file of a maildir; a missing file
+// yields an empty mapping
+func ReadKeywords(dir string) (*Keywords, error) {
+	keywords := Keywords{}
+	file, err := os.Open(filepath.Join(dir, KeywordsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &keywords, nil
+		}
+		return nil, fmt.Errorf("failed opening keywords: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		indexStr, name, found := strings.Cut(line, " ")
+		index, err := strconv.Atoi(indexStr)
+		if !found || err != nil || index < 0 || index >= maxKeywords || name == "" {
+			return nil, fmt.Errorf("%s line %d: invalid keyword: %s", file.Name(), lineNumber, line)
+		}
+		keywords.Names[index] = name
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading keywords: %v", err)
+	}
+	return &keywords, nil
+}
+
+// IsKeywordLetter returns true for the lowercase flag letters used for keywords
+func IsKeywordLetter(letter rune) bool {
+	return letter >= 'a' && letter < 'a'+maxKeywords
+}
+
+// Name returns the keyword mapped to a flag letter
+func (k *Keywords) Name(letter rune) (string, bool) {
+	if !IsKeywordLetter(letter) || k.Names[letter-'a'] == "" {
+		return "", false
+	}
+	return k.Names[letter-'a'], true
+}
+
+// Letter returns the flag letter of a keyword, ignoring case as IMAP does
+func (k *Keywords) Letter(name string) (rune, bool) {
+	for i, keyword := range k.Names {
+		if keyword != "" && strings.EqualFold(keyword, name) {
+			return 'a' + rune(i), true
+		}
+	}
+	return 0, false
+}
+
+// Add returns the flag letter of a keyword, mapping it to the first free
+// letter if necessary, and whether the mapping changed
+func (k *Keywords) Add(name string) (rune, bool, error) {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return 0, false, fmt.Errorf("invalid keyword: '%s'", name)
+	}
+	letter, ok := k.Letter(name)
+	if ok {
+		return letter, false, nil
+	}
+	for i, keyword := range k.Names {
+		if keyword == "" {
+			k.Names[i] = name
+			return 'a' + rune(i), true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("no free keyword letter for %s", name)
+}
+
+// Write replaces the dovecot-keywords file of a maildir
+func (k *Keywords) Write(dir string) error {
+	pathName := filepath.Join(dir, KeywordsFile)
+	stat, err := os.Stat(pathName)
+	if os.IsNotExist(err) {
+		stat, err = os.Stat(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	var content strings.Builder
+	for i, name := range k.Names {
+		if name != "" {
+			fmt.Fprintf(&content, "%d %s\n", i, name)
+		}
+	}
+	tmpPath := fmt.Sprintf("%s.%d", pathName, os.Getpid())
+	err = os.WriteFile(tmpPath, []byte(content.String()), 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing keywords: %v", err)
+	}
+	err = SetOwner(tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, pathName)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed replacing keywords: %v", err)
+	}
+	return nil
+}
+
+// KeywordNames returns the keywords set on a message and any keyword letters
+// which have no mapping
+func (m *MessageFile) KeywordNames(keywords *Keywords) ([]string, string) {
+	names := []string{}
+	unmapped := ""
+	for _, letter := range m.Flags {
+		if !IsKeywordLetter(letter) {
+			continue
+		}
+		name, ok := keywords.Name(letter)
+		if ok {
+			names = append(names, name)
+		} else {
+			unmapped += string(letter)
+		}
+	}
+	return names, unmapped
+}
+
+// TranslateKeywords maps the keyword letters of flags from one maildir's
+// keywords to another's, adding missing keywords to the destination and
+// dropping unmapped letters; it returns the new flags and whether the
+// destination mapping changed
+func TranslateKeywords(flags string, source, dest *Keywords) (string, bool, error) {
+	translated := ""
+	changed := false
+	for _, letter := range flags {
+		if !IsKeywordLetter(letter) {
+			translated += string(letter)
+			continue
+		}
+		name, ok := source.Name(letter)
+		if !ok {
+			continue
+		}
+		destLetter, added, err := dest.Add(name)
+		if err != nil {
+			return "", false, err
+		}
+		changed = changed || added
+		translated += string(destLetter)
+	}
+	return SortFlags(translated), changed, nil
+}
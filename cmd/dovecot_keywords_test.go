@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeywordsFile(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, KeywordsFile), []byte("0 $Junk\n2 $label1\n"), 0600))
+	keywords, err := ReadKeywords(dir)
+	require.Nil(t, err)
+	name, ok := keywords.Name('c')
+	require.True(t, ok)
+	require.Equal(t, "$label1", name)
+	letter, ok := keywords.Letter("$junk")
+	require.True(t, ok)
+	require.Equal(t, 'a', letter)
+	letter, added, err := keywords.Add("$Forwarded")
+	require.Nil(t, err)
+	require.True(t, added)
+	require.Equal(t, 'b', letter)
+	require.Nil(t, keywords.Write(dir))
+	data, err := os.ReadFile(filepath.Join(dir, KeywordsFile))
+	require.Nil(t, err)
+	require.Equal(t, "0 $Junk\n1 $Forwarded\n2 $label1\n", string(data))
+
+	msg, err := ParseMessageFile("/cur/1.M1P1.host,S=10:2,Sacz")
+	require.Nil(t, err)
+	names, unmapped := msg.KeywordNames(keywords)
+	require.Equal(t, []string{"$Junk", "$label1"}, names)
+	require.Equal(t, "z", unmapped)
+
+	dest := &Keywords{}
+	dest.Names[0] = "$label1"
+	flags, changed, err := TranslateKeywords("Sacz", keywords, dest)
+	require.Nil(t, err)
+	require.True(t, changed)
+	require.Equal(t, "Sab", flags)
+	require.Equal(t, "$Junk", dest.Names[1])
+}
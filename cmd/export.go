@@ -0,0 +1,288 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export maildir messages",
+	Long: `
+Export messages from the maildirs rooted at DIR in other formats.
+Messages are decompressed as they are exported.
+`,
+}
+
+// exportMboxCmd represents the export mbox command
+var exportMboxCmd = &cobra.Command{
+	Use:   "mbox [DIR]",
+	Short: "export maildirs to mboxrd files",
+	Long: `
+Write the messages in the cur and new subdirectories of the specified maildir
+to an mboxrd file named FOLDER.mbox in the output directory. The default DIR
+is ~/Maildir. Lines beginning with 'From ' are escaped with '>' and the maildir
+flags are written as Status: and X-Status: headers.
+
+Flags:
+    --recurse		export all maildirs rooted at DIR, one file per folder
+    --output PATH	output directory, or '-' to write a single mbox to stdout
+    --query QUERY	export only messages matching a find QUERY
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ExportMbox(args))
+	},
+}
+
+// exportArchiveCmd represents the export archive command
+var exportArchiveCmd = &cobra.Command{
+	Use:   "archive [DIR]",
+	Short: "export maildirs to a tar or zip archive of .eml files",
+	Long: `
+Write the messages in the cur and new subdirectories of the specified maildir
+to a tar or zip archive as FOLDER/UID.eml, followed by a manifest.json listing
+the original filename, flags, uid, SHA-256 and size of each message.  Messages
+without a uid in dovecot-uidlist are named by their unique base name.  The
+default DIR is ~/Maildir.
+
+Flags:
+    --recurse		export all maildirs rooted at DIR
+    --format FORMAT	archive format: tar or zip (default tar)
+    --output PATH	archive file, directory for maildir.FORMAT, or '-' for stdout
+    --query QUERY	export only messages matching a find QUERY
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ExportArchive(args))
+	},
+}
+
+// ManifestEntry describes a message written to an archive
+type ManifestEntry struct {
+	Path        string   `json:"path"`
+	Folder      string   `json:"folder"`
+	Filename    string   `json:"filename"`
+	Uid         uint32   `json:"uid,omitempty"`
+	Flags       []string `json:"flags"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Compression string   `json:"compression,omitempty"`
+	Size        int64    `json:"size"`
+	Sha256      string   `json:"sha256"`
+}
+
+func ExportArchive(args []string) error {
+	verbose := viper.GetBool("verbose")
+	format := viper.GetString("export.archive.format")
+	if format != "tar" && format != "zip" {
+		return fmt.Errorf("unknown archive format: %s", format)
+	}
+	output := viper.GetString("export.output")
+	query, err := QueryFlag("export.query")
+	if err != nil {
+		return err
+	}
+	if !viper.GetBool("uncompressed") {
+		viper.Set("all", true)
+	}
+	var writer io.Writer = os.Stdout
+	var file *os.File
+	if output != "-" {
+		stat, err := os.Stat(output)
+		if err == nil && stat.IsDir() {
+			output = filepath.Join(output, "maildir."+format)
+		}
+		file, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed creating archive: %v", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	archive, err := NewArchiveWriter(writer, format)
+	if err != nil {
+		return err
+	}
+	manifest := []ManifestEntry{}
+	err = FindMessages(MaildirRoot(args), query, func(msg *Message) error {
+		data, cmpType, err := ReadMessage(msg.File.Path)
+		if err != nil {
+			return err
+		}
+		received, err := msg.Received()
+		if err != nil {
+			return err
+		}
+		keywords, err := msg.KeywordNames()
+		if err != nil {
+			return err
+		}
+		name := msg.File.Base
+		if msg.Uid > 0 {
+			name = fmt.Sprintf("%d", msg.Uid)
+		}
+		entry := ManifestEntry{
+			Path:        msg.Folder + "/" + name + ".eml",
+			Folder:      msg.Folder,
+			Filename:    filepath.Base(msg.File.Path),
+			Uid:         msg.Uid,
+			Flags:       msg.File.FlagNames(),
+			Keywords:    keywords,
+			Compression: cmpType,
+			Size:        int64(len(data)),
+			Sha256:      fmt.Sprintf("%x", sha256.Sum256(data)),
+		}
+		err = archive.Add(entry.Path, data, received)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			log.Printf("archived %s as %s\n", msg.File.Path, entry.Path)
+		}
+		manifest = append(manifest, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed formatting manifest: %v", err)
+	}
+	err = archive.Add("manifest.json", append(data, '\n'), time.Now())
+	if err != nil {
+		return err
+	}
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		err = file.Close()
+		if err != nil {
+			return fmt.Errorf("failed closing archive: %v", err)
+		}
+	}
+	return nil
+}
+
+func ExportMbox(args []string) error {
+	verbose := viper.GetBool("verbose")
+	output := viper.GetString("export.output")
+	query, err := QueryFlag("export.query")
+	if err != nil {
+		return err
+	}
+	if !viper.GetBool("uncompressed") {
+		viper.Set("all", true)
+	}
+	var file *os.File
+	var writer *MboxWriter
+	closeFile := func() error {
+		if writer == nil {
+			return nil
+		}
+		err := writer.Flush()
+		if err != nil {
+			return err
+		}
+		if file != nil {
+			err = file.Close()
+			if err != nil {
+				return fmt.Errorf("failed closing mbox: %v", err)
+			}
+		}
+		return nil
+	}
+	if output == "-" {
+		writer = NewMboxWriter(os.Stdout)
+	}
+	folder := ""
+	err = FindMessages(MaildirRoot(args), query, func(msg *Message) error {
+		if output != "-" && (writer == nil || msg.Folder != folder) {
+			err := closeFile()
+			if err != nil {
+				return err
+			}
+			folder = msg.Folder
+			pathName := filepath.Join(output, filepath.FromSlash(folder)+".mbox")
+			err = os.MkdirAll(filepath.Dir(pathName), 0700)
+			if err != nil {
+				return fmt.Errorf("failed creating output directory: %v", err)
+			}
+			file, err = os.OpenFile(pathName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return fmt.Errorf("failed creating mbox: %v", err)
+			}
+			writer = NewMboxWriter(file)
+			if verbose {
+				log.Printf("exporting %s to %s\n", folder, pathName)
+			}
+		}
+		data, _, err := ReadMessage(msg.File.Path)
+		if err != nil {
+			return err
+		}
+		received, err := msg.Received()
+		if err != nil {
+			return err
+		}
+		return writer.WriteMessage(data, received, msg.File.Flags)
+	})
+	if err != nil {
+		closeFile()
+		return err
+	}
+	return closeFile()
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.PersistentFlags().StringP("output", "o", ".", "output directory or file, '-' for stdout")
+	viper.BindPFlag("export.output", exportCmd.PersistentFlags().Lookup("output"))
+	exportCmd.PersistentFlags().String("query", "", "export only messages matching query")
+	viper.BindPFlag("export.query", exportCmd.PersistentFlags().Lookup("query"))
+	exportCmd.AddCommand(exportMboxCmd)
+	exportCmd.AddCommand(exportArchiveCmd)
+	exportArchiveCmd.Flags().String("format", "tar", "archive format: tar or zip")
+	viper.BindPFlag("export.archive.format", exportArchiveCmd.Flags().Lookup("format"))
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMboxWriterEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewMboxWriter(&buf)
+	data := []byte("From: dave@example.com\r\nStatus: U\r\nSubject: escapes\r\n\r\nFrom here\r\n>From there\r\nFromage\r\n")
+	err := writer.WriteMessage(data, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), "FRS")
+	require.Nil(t, err)
+	require.Nil(t, writer.Flush())
+	expected := "From dave@example.com Fri Mar  1 10:00:00 2024\n" +
+		"From: dave@example.com\n" +
+		"Subject: escapes\n" +
+		"Status: RO\n" +
+		"X-Status: AF\n" +
+		"\n" +
+		">From here\n" +
+		">>From there\n" +
+		"Fromage\n" +
+		"\n"
+	require.Equal(t, expected, buf.String())
+}
+
+func TestExportMbox(t *testing.T) {
+	root := makeTestMaildir(t)
+	output := t.TempDir()
+	viper.Set("recurse", true)
+	viper.Set("export.output", output)
+	err := ExportMbox([]string{root})
+	require.Nil(t, err)
+
+	inbox, err := os.ReadFile(filepath.Join(output, "INBOX.mbox"))
+	require.Nil(t, err)
+	require.Equal(t, 2, strings.Count("\n"+string(inbox), "\nFrom "))
+	require.Contains(t, string(inbox), "quarterly figures")
+
+	archive, err := os.ReadFile(filepath.Join(output, "Archive.mbox"))
+	require.Nil(t, err)
+	require.Contains(t, string(archive), "X-Status: F\n")
+}
+
+func TestExportArchive(t *testing.T) {
+	for _, format := range []string{"tar", "zip"} {
+		root := makeTestMaildir(t)
+		output := filepath.Join(t.TempDir(), "mail."+format)
+		viper.Set("recurse", true)
+		viper.Set("export.output", output)
+		viper.Set("export.archive.format", format)
+		require.Nil(t, ExportArchive([]string{root}))
+
+		files := map[string][]byte{}
+		if format == "tar" {
+			file, err := os.Open(output)
+			require.Nil(t, err)
+			reader := tar.NewReader(file)
+			for {
+				header, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.Nil(t, err)
+				data, err := io.ReadAll(reader)
+				require.Nil(t, err)
+				files[header.Name] = data
+			}
+			file.Close()
+		} else {
+			reader, err := zip.OpenReader(output)
+			require.Nil(t, err)
+			for _, entry := range reader.File {
+				file, err := entry.Open()
+				require.Nil(t, err)
+				data, err := io.ReadAll(file)
+				require.Nil(t, err)
+				files[entry.Name] = data
+			}
+			reader.Close()
+		}
+		require.Len(t, files, 4)
+		require.Contains(t, string(files["INBOX/1.eml"]), "quarterly figures")
+		require.Contains(t, string(files["Archive/1704067200.M3P1.host.eml"]), "happy new year")
+
+		manifest := []ManifestEntry{}
+		require.Nil(t, json.Unmarshal(files["manifest.json"], &manifest))
+		require.Len(t, manifest, 3)
+		for _, entry := range manifest {
+			require.Equal(t, fmt.Sprintf("%x", sha256.Sum256(files[entry.Path])), entry.Sha256)
+			require.Equal(t, int64(len(files[entry.Path])), entry.Size)
+		}
+	}
+}
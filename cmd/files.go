@@ -274,6 +274,8 @@ func ListMaildirFiles(dir string) (*[]string, error) {
 	listUncompressed := viper.GetBool("uncompressed")
 	listAll := viper.GetBool("all")
 	debug := viper.GetBool("debug")
+	rescan := viper.GetBool("rescan")
+	useCache := viper.GetBool("cache") || rescan
 
 	stat, err := os.Stat(dir)
 	if err != nil {
@@ -288,6 +290,17 @@ func ListMaildirFiles(dir string) (*[]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("ReadDir failed: %v", err)
 	}
+	var cache *ScanCache
+	if useCache {
+		cache, err = ReadScanCache(dir)
+		if err != nil {
+			return nil, err
+		}
+		if rescan {
+			cache.Prune(nil)
+		}
+	}
+	present := map[string]bool{}
 	filenames := []string{}
 	count := 0
 	for _, entry := range entries {
@@ -295,9 +308,19 @@ func ListMaildirFiles(dir string) (*[]string, error) {
 			continue
 		}
 		pathName := filepath.Join(path, entry.Name())
-		isCompressed, err := IsCompressed(pathName)
-		if err != nil {
-			return nil, err
+		var isCompressed bool
+		if cache != nil {
+			present[entry.Name()] = true
+			scanned, err := scanCached(cache, pathName, entry)
+			if err != nil {
+				return nil, err
+			}
+			isCompressed = scanned.Compression != ""
+		} else {
+			isCompressed, err = IsCompressed(pathName)
+			if err != nil {
+				return nil, err
+			}
 		}
 		if !listAll {
 			if listUncompressed {
@@ -316,9 +339,64 @@ func ListMaildirFiles(dir string) (*[]string, error) {
 			fmt.Printf("%d %v %s\n", count, isCompressed, pathName)
 		}
 	}
+	if cache != nil {
+		cache.Prune(present)
+		err = cache.Write(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &filenames, nil
 }
 
+// ListMessageFiles returns the pathnames of every message file in the cur
+// and new subdirectories of a maildir
+func ListMessageFiles(dir string) ([]string, error) {
+	files := []string{}
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("ReadDir failed: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(dir, sub, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// scanCached returns the scan cache entry of a message file, scanning the
+// file only if it is new or has changed since it was cached
+func scanCached(cache *ScanCache, pathName string, entry fs.DirEntry) (*ScanCacheEntry, error) {
+	info, err := entry.Info()
+	if err != nil {
+		return nil, fmt.Errorf("Stat failed: %v", err)
+	}
+	scanned, ok := cache.Lookup(entry.Name(), info)
+	if ok {
+		return scanned, nil
+	}
+	scanned, err = cache.Scan(pathName, info)
+	if err != nil {
+		return nil, err
+	}
+	if viper.GetBool("verbose") {
+		file, err := ParseMessageFile(pathName)
+		if err != nil {
+			return nil, err
+		}
+		if !scanned.Verified(file) {
+			log.Printf("message sizes S=%d W=%d mismatch filename: %s\n", scanned.Size, scanned.SizeW, pathName)
+		}
+	}
+	return scanned, nil
+}
+
 func SetStat(path string, info fs.FileInfo) error {
 
 	// replicate access mode bits
@@ -334,12 +412,15 @@ func SetStat(path string, info fs.FileInfo) error {
 	}
 
 	// replicate ownership
+	return SetOwner(path, info)
+}
+
+func SetOwner(path string, info fs.FileInfo) error {
 	uid := info.Sys().(*syscall.Stat_t).Uid
 	gid := info.Sys().(*syscall.Stat_t).Gid
-	err = os.Chown(path, int(uid), int(gid))
+	err := os.Chown(path, int(uid), int(gid))
 	if err != nil {
 		return fmt.Errorf("ownership change failed on '%s': %v", path, err)
 	}
-
 	return nil
 }
@@ -0,0 +1,185 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"time"
+)
+
+// findCmd represents the find command
+var findCmd = &cobra.Command{
+	Use:   "find QUERY [DIR]",
+	Short: "search messages by header, date, size and flags",
+	Long: `
+Output each message in the cur and new subdirectories of the specified maildir
+matching QUERY. The default DIR is ~/Maildir. Compressed messages are read
+through transparent decompression.
+
+QUERY is a list of space-separated terms which must all match.  Prefix a term
+with '-' to negate it.  Quote values containing spaces.
+
+    from:TEXT		From header contains TEXT
+    to:TEXT		To header contains TEXT
+    cc:TEXT		Cc header contains TEXT
+    subject:TEXT	Subject header contains TEXT (also bare words)
+    message-id:TEXT	Message-ID header contains TEXT
+    header:NAME=TEXT	named header contains TEXT
+    date:DATE		Date header within YYYY, YYYY-MM, YYYY-MM-DD
+    date:START..END	Date header within range; either end may be omitted
+    after:DATE		Date header on or after DATE
+    before:DATE		Date header before DATE
+    size:N, size:>N, size:<N, size:MIN..MAX
+    larger:N, smaller:N	message size; N may have K, M or G suffix
+    flag:NAME		flag set: seen, replied, flagged, draft, trashed, passed
+    keyword:NAME	custom keyword set, e.g. $Junk, via dovecot-keywords
+    folder:GLOB		folder name matches GLOB (INBOX for the root maildir)
+    compressed:yes|no	message file is compressed
+
+Example:
+    find 'from:alice date:2024-03 -flag:seen' --recurse
+
+Flags:
+    --recurse		search all maildirs rooted at DIR
+    --uncompressed	search only uncompressed messages
+    --format FORMAT	output pathnames (path), folder:uid (uid) or json
+    --print0		terminate pathnames with NUL for xargs -0
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(FindFiles(args))
+	},
+}
+
+// FindResult is the json output of a matching message
+type FindResult struct {
+	Path      string   `json:"path"`
+	Folder    string   `json:"folder"`
+	Uid       uint32   `json:"uid,omitempty"`
+	Flags     []string `json:"flags"`
+	Keywords  []string `json:"keywords"`
+	Size      int64    `json:"size"`
+	Date      string   `json:"date"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Subject   string   `json:"subject"`
+	MessageId string   `json:"message_id"`
+}
+
+func FindFiles(args []string) error {
+	format := viper.GetString("find.format")
+	print0 := viper.GetBool("find.print0")
+	query, err := ParseQuery(args[0])
+	if err != nil {
+		return err
+	}
+	if !viper.GetBool("uncompressed") {
+		viper.Set("all", true)
+	}
+	switch format {
+	case "path", "uid", "json":
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+	results := []FindResult{}
+	err = FindMessages(MaildirRoot(args[1:]), query, func(msg *Message) error {
+		switch format {
+		case "path":
+			if print0 {
+				fmt.Printf("%s\x00", msg.File.Path)
+			} else {
+				fmt.Printf("%s\n", msg.File.Path)
+			}
+		case "uid":
+			fmt.Printf("%s:%d\n", msg.Folder, msg.Uid)
+		case "json":
+			result, err := NewFindResult(msg)
+			if err != nil {
+				return err
+			}
+			results = append(results, *result)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		return PrintJSON(results)
+	}
+	return nil
+}
+
+func NewFindResult(msg *Message) (*FindResult, error) {
+	result := FindResult{
+		Path:   msg.File.Path,
+		Folder: msg.Folder,
+		Uid:    msg.Uid,
+		Flags:  msg.File.FlagNames(),
+	}
+	var err error
+	result.Keywords, err = msg.KeywordNames()
+	if err != nil {
+		return nil, err
+	}
+	result.Size, err = msg.Size()
+	if err != nil {
+		return nil, err
+	}
+	date, err := msg.Date()
+	if err != nil {
+		return nil, err
+	}
+	result.Date = date.Format(time.RFC3339)
+	fields := map[string]*string{
+		"From":       &result.From,
+		"To":         &result.To,
+		"Subject":    &result.Subject,
+		"Message-Id": &result.MessageId,
+	}
+	for key, value := range fields {
+		*value, err = msg.HeaderValue(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+func init() {
+	rootCmd.AddCommand(findCmd)
+	findCmd.Flags().String("format", "path", "output format: path, uid or json")
+	viper.BindPFlag("find.format", findCmd.Flags().Lookup("format"))
+	findCmd.Flags().BoolP("print0", "0", false, "terminate output pathnames with NUL")
+	viper.BindPFlag("find.print0", findCmd.Flags().Lookup("print0"))
+}
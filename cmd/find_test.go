@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func findPaths(t *testing.T, root, text string) []string {
+	query, err := ParseQuery(text)
+	require.Nil(t, err)
+	viper.Set("all", true)
+	paths := []string{}
+	err = FindMessages(root, query, func(msg *Message) error {
+		paths = append(paths, msg.File.Path)
+		return nil
+	})
+	require.Nil(t, err)
+	return paths
+}
+
+func TestFindHeaders(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("recurse", true)
+	require.Len(t, findPaths(t, root, "from:alice"), 2)
+	require.Len(t, findPaths(t, root, "from:alice date:2024-03"), 1)
+	require.Len(t, findPaths(t, root, `subject:"april plans"`), 1)
+	require.Len(t, findPaths(t, root, "-from:alice"), 1)
+}
+
+func TestFindFlagsFolder(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("recurse", true)
+	require.Len(t, findPaths(t, root, "flag:seen"), 2)
+	require.Len(t, findPaths(t, root, "flag:flagged folder:Archive"), 1)
+	require.Len(t, findPaths(t, root, "folder:INBOX -flag:seen"), 1)
+	require.Len(t, findPaths(t, root, "compressed:no"), 1)
+}
+
+func TestFindNewMessages(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("recurse", false)
+	unseen := filepath.Join(root, "new", "1714521600.M4P1.host")
+	require.Nil(t, os.WriteFile(unseen, testMessage("carol@example.com", "may notes", "Wed, 01 May 2024 10:00:00 +0000", "garden"), 0600))
+	require.Equal(t, []string{unseen}, findPaths(t, root, "from:carol"))
+	require.Len(t, findPaths(t, root, "-flag:seen"), 2)
+}
+
+func TestFindDateSize(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("recurse", true)
+	require.Len(t, findPaths(t, root, "date:2024-01..2024-03"), 2)
+	require.Len(t, findPaths(t, root, "after:2024-03-02"), 1)
+	require.Len(t, findPaths(t, root, "size:<1K"), 3)
+	require.Len(t, findPaths(t, root, "larger:1M"), 0)
+	require.Empty(t, findPaths(t, root, "size:<0"))
+	require.Empty(t, findPaths(t, root, "smaller:0"))
+	require.Len(t, findPaths(t, root, "size:0.."), 3)
+}
+
+func TestFindQueryErrors(t *testing.T) {
+	for _, text := range []string{"bogus:value", "date:March", "size:>lots", `subject:"open`, "flag:sparkly"} {
+		_, err := ParseQuery(text)
+		require.NotNil(t, err, text)
+	}
+}
+
+func TestFindUid(t *testing.T) {
+	root := makeTestMaildir(t)
+	query, err := ParseQuery("from:bob")
+	require.Nil(t, err)
+	viper.Set("all", true)
+	var uid uint32
+	err = FindMessages(root, query, func(msg *Message) error {
+		uid = msg.Uid
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, uint32(2), uid)
+}
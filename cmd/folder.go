@@ -0,0 +1,376 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// folderCmd represents the folder command
+var folderCmd = &cobra.Command{
+	Use:   "folder",
+	Short: "manage maildir folders",
+	Long: `
+Manage the IMAP folders of the root maildir DIR.  The default DIR is
+~/Maildir.  Folder names use '/' to separate hierarchy levels and are decoded
+from IMAP modified UTF-7 unless --utf8 is given.  Use --layout fs for
+dovecot's LAYOUT=fs, where folders are nested directories instead of
+Maildir++ dot-directories.
+`,
+}
+
+// folderListCmd represents the folder list command
+var folderListCmd = &cobra.Command{
+	Use:   "list [DIR]",
+	Short: "list folders with message counts and sizes",
+	Long: `
+Output the name, message count and total message size of each folder of the
+root maildir DIR.  Use --verbose to include the maildir pathname.
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ListFolderStats(args))
+	},
+}
+
+// FolderStats returns the number and total size of the messages in a folder
+func FolderStats(dir string) (int64, int64, error) {
+	var count, size int64
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, 0, fmt.Errorf("ReadDir failed: %v", err)
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			msg, err := NewMessage(dir, "", nil, filepath.Join(dir, sub, entry.Name()))
+			if err != nil {
+				return 0, 0, err
+			}
+			msgSize, err := msg.Size()
+			if err != nil {
+				return 0, 0, err
+			}
+			count += 1
+			size += msgSize
+		}
+	}
+	return count, size, nil
+}
+
+func ListFolderStats(args []string) error {
+	verbose := viper.GetBool("verbose")
+	_, err := FolderLayout()
+	if err != nil {
+		return err
+	}
+	folders, err := ListFolders(MaildirRoot(args))
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	header := "FOLDER\tMESSAGES\tSIZE"
+	if verbose {
+		header += "\tPATH"
+	}
+	fmt.Fprintln(writer, header)
+	for _, folder := range folders {
+		count, size, err := FolderStats(folder.Path)
+		if err != nil {
+			return err
+		}
+		line := fmt.Sprintf("%s\t%d\t%d", folder.Name, count, size)
+		if verbose {
+			line += "\t" + folder.Path
+		}
+		fmt.Fprintln(writer, line)
+	}
+	return writer.Flush()
+}
+
+// folderCreateCmd represents the folder create command
+var folderCreateCmd = &cobra.Command{
+	Use:   "create NAME [DIR]",
+	Short: "create a folder",
+	Long: `
+Create the folder NAME with cur, new and tmp subdirectories in the root
+maildir DIR, with the mode and ownership of DIR, and subscribe to it.
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(CreateFolder(args))
+	},
+}
+
+// folderRenameCmd represents the folder rename command
+var folderRenameCmd = &cobra.Command{
+	Use:   "rename OLD NEW [DIR]",
+	Short: "rename a folder and its children",
+	Long: `
+Rename the folder OLD and all of its child folders to NEW in the root maildir
+DIR and update their subscriptions.  INBOX cannot be renamed.
+`,
+	Args: cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(RenameFolder(args))
+	},
+}
+
+// folderDeleteCmd represents the folder delete command
+var folderDeleteCmd = &cobra.Command{
+	Use:   "delete NAME [DIR]",
+	Short: "delete a folder",
+	Long: `
+Delete the folder NAME from the root maildir DIR and remove its subscription.
+INBOX cannot be deleted.
+
+Flags:
+    --recurse	also delete the child folders of NAME
+    --force	delete folders which contain messages
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(DeleteFolder(args))
+	},
+}
+
+func openRootMaildir(root string) (string, error) {
+	layout, err := FolderLayout()
+	if err != nil {
+		return "", err
+	}
+	maildir, err := IsMaildir(root)
+	if err != nil {
+		return "", err
+	}
+	if !maildir {
+		return "", fmt.Errorf("not a maildir: %s", root)
+	}
+	return layout, nil
+}
+
+// childFolders returns the folders below the named folder
+func childFolders(root, name string) ([]Folder, error) {
+	folders, err := ListFolders(root)
+	if err != nil {
+		return nil, err
+	}
+	children := []Folder{}
+	for _, folder := range folders {
+		if strings.HasPrefix(folder.Name, name+"/") {
+			children = append(children, folder)
+		}
+	}
+	return children, nil
+}
+
+func CreateFolder(args []string) error {
+	root := MaildirRoot(args[1:])
+	layout, err := openRootMaildir(root)
+	if err != nil {
+		return err
+	}
+	dir := FolderPath(root, args[0])
+	if dir == root {
+		return fmt.Errorf("cannot create INBOX")
+	}
+	_, err = os.Lstat(dir)
+	if err == nil {
+		return fmt.Errorf("folder exists: %s", args[0])
+	}
+	err = MakeMaildir(root, dir)
+	if err != nil {
+		return err
+	}
+	subscriptions, err := ReadSubscriptions(root, layout)
+	if err != nil {
+		return err
+	}
+	if subscriptions.Add(EncodeFolderName(args[0])) {
+		err = subscriptions.Write(root, layout)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("created %s\n", dir)
+	return nil
+}
+
+func RenameFolder(args []string) error {
+	root := MaildirRoot(args[2:])
+	layout, err := openRootMaildir(root)
+	if err != nil {
+		return err
+	}
+	oldDir, newDir := FolderPath(root, args[0]), FolderPath(root, args[1])
+	if oldDir == root || newDir == root {
+		return fmt.Errorf("cannot rename INBOX")
+	}
+	maildir, err := IsMaildir(oldDir)
+	if err != nil || !maildir {
+		return fmt.Errorf("folder not found: %s", args[0])
+	}
+	_, err = os.Lstat(newDir)
+	if err == nil {
+		return fmt.Errorf("folder exists: %s", args[1])
+	}
+	oldName, newName := FolderName(root, oldDir), FolderName(root, newDir)
+	moves := []LayoutMove{{Folder: oldName, From: oldDir, To: newDir}}
+	if layout == LayoutMaildirPlusPlus {
+		// Maildir++ children are separate directories named after their parent
+		children, err := childFolders(root, oldName)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			target := FolderPath(root, newName+strings.TrimPrefix(child.Name, oldName))
+			_, err = os.Lstat(target)
+			if err == nil {
+				return fmt.Errorf("folder exists: %s", target)
+			}
+			moves = append(moves, LayoutMove{Folder: child.Name, From: child.Path, To: target})
+		}
+	}
+	stat, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	for _, move := range moves {
+		for _, parent := range parentDirs(root, filepath.Dir(move.To)) {
+			err := os.Mkdir(parent, stat.Mode().Perm())
+			if err == nil {
+				err = SetOwner(parent, stat)
+			}
+			if err != nil && !os.IsExist(err) {
+				return fmt.Errorf("failed creating %s: %v", parent, err)
+			}
+		}
+		err = os.Rename(move.From, move.To)
+		if err != nil {
+			return fmt.Errorf("failed renaming folder %s: %v", move.Folder, err)
+		}
+		fmt.Printf("renamed %s -> %s\n", move.From, move.To)
+	}
+	subscriptions, err := ReadSubscriptions(root, layout)
+	if err != nil {
+		return err
+	}
+	if subscriptions.Rename(EncodeFolderName(oldName), EncodeFolderName(newName)) > 0 {
+		err = subscriptions.Write(root, layout)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DeleteFolder(args []string) error {
+	recurse := viper.GetBool("recurse")
+	force := viper.GetBool("folder.force")
+	root := MaildirRoot(args[1:])
+	layout, err := openRootMaildir(root)
+	if err != nil {
+		return err
+	}
+	dir := FolderPath(root, args[0])
+	if dir == root {
+		return fmt.Errorf("cannot delete INBOX")
+	}
+	maildir, err := IsMaildir(dir)
+	if err != nil || !maildir {
+		return fmt.Errorf("folder not found: %s", args[0])
+	}
+	name := FolderName(root, dir)
+	children, err := childFolders(root, name)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 && !recurse {
+		return fmt.Errorf("folder %s has %d child folders; use --recurse to delete them", name, len(children))
+	}
+	folders := append([]Folder{{Name: name, Path: dir}}, children...)
+	var size, count int64
+	for _, folder := range folders {
+		folderCount, folderSize, err := FolderStats(folder.Path)
+		if err != nil {
+			return err
+		}
+		count += folderCount
+		size += folderSize
+	}
+	if count > 0 && !force {
+		return fmt.Errorf("folder %s contains %d messages; use --force to delete them", name, count)
+	}
+	// children are removed first since fs layout folders contain their children
+	for i := len(folders) - 1; i >= 0; i-- {
+		err := os.RemoveAll(folders[i].Path)
+		if err != nil {
+			return fmt.Errorf("failed deleting folder %s: %v", folders[i].Name, err)
+		}
+		fmt.Printf("deleted %s\n", folders[i].Path)
+	}
+	err = AppendQuotaDelta(root, -size, -count)
+	if err != nil {
+		return err
+	}
+	subscriptions, err := ReadSubscriptions(root, layout)
+	if err != nil {
+		return err
+	}
+	if subscriptions.Remove(EncodeFolderName(name), recurse) > 0 {
+		err = subscriptions.Write(root, layout)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(folderCmd)
+	folderCmd.AddCommand(folderListCmd)
+	folderCmd.AddCommand(folderCreateCmd)
+	folderCmd.AddCommand(folderRenameCmd)
+	folderCmd.AddCommand(folderDeleteCmd)
+	folderDeleteCmd.Flags().Bool("force", false, "delete folders containing messages")
+	viper.BindPFlag("folder.force", folderDeleteCmd.Flags().Lookup("force"))
+}
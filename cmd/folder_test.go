@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestModifiedUTF7(t *testing.T) {
+	for encoded, decoded := range map[string]string{
+		"2024&AOk-t&AOk-": "2024été",
+		"Entw&APw-rfe":    "Entwürfe",
+		"Tom &- Jerry":    "Tom & Jerry",
+		"&ZeVnLIqe-":      "日本語",
+		"&2D3eAQ- emoji":  "\U0001F601 emoji",
+		"plain":           "plain",
+	} {
+		value, err := DecodeModifiedUTF7(encoded)
+		require.Nil(t, err)
+		require.Equal(t, decoded, value)
+		require.Equal(t, encoded, EncodeModifiedUTF7(decoded))
+	}
+	_, err := DecodeModifiedUTF7("bad&AOk")
+	require.NotNil(t, err)
+}
+
+func TestFolderNames(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	root := "/home/user/Maildir"
+	require.Equal(t, "INBOX", FolderName(root, root))
+	require.Equal(t, "Archive/2024été", FolderName(root, root+"/.Archive.2024&AOk-t&AOk-"))
+	require.Equal(t, root+"/.Archive.2024&AOk-t&AOk-", FolderPath(root, "Archive/2024été"))
+	require.Equal(t, root+"/.Archive.2024", FolderPath(root, "Archive.2024"))
+	require.Equal(t, root, FolderPath(root, "inbox"))
+
+	viper.Set("layout", "fs")
+	require.Equal(t, "Archive/2024été", FolderName(root, root+"/Archive/2024&AOk-t&AOk-"))
+	require.Equal(t, root+"/Archive/2024&AOk-t&AOk-", FolderPath(root, "Archive/2024été"))
+
+	viper.Set("utf8", true)
+	require.Equal(t, root+"/Archive/2024été", FolderPath(root, "Archive/2024été"))
+
+	viper.Set("layout", "mbox")
+	_, err := FolderLayout()
+	require.NotNil(t, err)
+}
+
+func TestSelectFolders(t *testing.T) {
+	root := makeTestMaildir(t)
+	require.Nil(t, MakeMaildir(root, filepath.Join(root, ".Archive.2024&AOk-t&AOk-")))
+	_, err := os.Stat(filepath.Join(root, ".Archive.2024&AOk-t&AOk-", "maildirfolder"))
+	require.Nil(t, err)
+
+	folders, err := ListFolders(root)
+	require.Nil(t, err)
+	names := []string{}
+	for _, folder := range folders {
+		names = append(names, folder.Name)
+	}
+	require.Equal(t, []string{"INBOX", "Archive", "Archive/2024été"}, names)
+
+	viper.Set("folder", "Archive")
+	dirs, err := SelectMaildirs(root)
+	require.Nil(t, err)
+	require.Equal(t, []string{filepath.Join(root, ".Archive")}, dirs)
+
+	viper.Set("recurse", true)
+	dirs, err = SelectMaildirs(root)
+	require.Nil(t, err)
+	require.Len(t, dirs, 2)
+
+	count, size, err := FolderStats(filepath.Join(root, ".Archive"))
+	require.Nil(t, err)
+	require.Equal(t, int64(1), count)
+	require.Equal(t, int64(159), size)
+
+	viper.Set("folder", "Missing")
+	_, err = SelectMaildirs(root)
+	require.NotNil(t, err)
+}
+
+func TestFolderManagement(t *testing.T) {
+	root := makeTestMaildir(t)
+	require.Nil(t, CreateFolder([]string{"Archive/2024", root}))
+	require.NotNil(t, CreateFolder([]string{"Archive/2024", root}))
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		_, err := os.Stat(filepath.Join(root, ".Archive.2024", sub))
+		require.Nil(t, err)
+	}
+
+	require.NotNil(t, RenameFolder([]string{"INBOX", "Old", root}))
+	require.Nil(t, RenameFolder([]string{"Archive", "Old", root}))
+	_, err := os.Stat(filepath.Join(root, ".Old.2024", "cur"))
+	require.Nil(t, err)
+	subscriptions, err := ReadSubscriptions(root, LayoutMaildirPlusPlus)
+	require.Nil(t, err)
+	require.True(t, subscriptions.Contains("Old/2024"))
+	require.False(t, subscriptions.Contains("Archive/2024"))
+
+	require.NotNil(t, DeleteFolder([]string{"Old", root}))
+	viper.Set("recurse", true)
+	require.NotNil(t, DeleteFolder([]string{"Old", root}))
+	viper.Set("folder.force", true)
+	require.Nil(t, DeleteFolder([]string{"Old", root}))
+	_, err = os.Stat(filepath.Join(root, ".Old"))
+	require.True(t, os.IsNotExist(err))
+	subscriptions, err = ReadSubscriptions(root, LayoutMaildirPlusPlus)
+	require.Nil(t, err)
+	require.False(t, subscriptions.Contains("Old/2024"))
+}
+
+func TestMoveCopyMessages(t *testing.T) {
+	root := makeTestMaildir(t)
+	require.Nil(t, os.WriteFile(filepath.Join(root, ".Archive", UidlistFile), []byte("3 V1700000001 N1\n"), 0600))
+	viper.Set("move.query", "from:bob")
+	require.Nil(t, TransferMessages([]string{"Archive", root}, "move"))
+	files, err := filepath.Glob(filepath.Join(root, ".Archive", "cur", "1711929600.M2P1.host*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	inbox, err := ReadUidlist(root)
+	require.Nil(t, err)
+	_, ok := inbox.Lookup(filepath.Join(root, "cur", filepath.Base(files[0])))
+	require.False(t, ok)
+	archive, err := ReadUidlist(filepath.Join(root, ".Archive"))
+	require.Nil(t, err)
+	uid, ok := archive.Lookup(files[0])
+	require.True(t, ok)
+	require.NotZero(t, uid)
+
+	viper.Set("copy.query", "subject:march")
+	require.Nil(t, TransferMessages([]string{"Archive", root}, "copy"))
+	files, err = filepath.Glob(filepath.Join(root, ".Archive", "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 3)
+	files, err = filepath.Glob(filepath.Join(root, "cur", "1709251200.M1P1.host*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+
+	require.NotNil(t, TransferMessages([]string{"Missing", root}, "copy"))
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// grepCmd represents the grep command
+var grepCmd = &cobra.Command{
+	Use:   "grep PATTERN [DIR]",
+	Short: "search message text bodies",
+	Long: `
+Output the pathname and each matching line of every message in the cur and
+new subdirectories of the specified maildir whose text body matches the
+regular expression PATTERN. The default DIR is ~/Maildir
+
+Messages are decompressed on the fly, quoted-printable and base64 text parts
+are decoded and converted from their charset to UTF-8 before matching.
+Attachments are not searched.  Parts which cannot be decoded are logged and
+skipped.
+
+Flags:
+    --recurse		    search all maildirs rooted at DIR
+    --ignore-case	    match PATTERN case-insensitively
+    --files-with-matches    output only the pathnames of matching messages
+    --query QUERY	    search only messages matching a find QUERY
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(GrepFiles(args))
+	},
+}
+
+func GrepFiles(args []string) error {
+	pattern := args[0]
+	if viper.GetBool("grep.ignore-case") {
+		pattern = "(?i)" + pattern
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	query, err := QueryFlag("grep.query")
+	if err != nil {
+		return err
+	}
+	filesOnly := viper.GetBool("grep.files-with-matches")
+	if !viper.GetBool("uncompressed") {
+		viper.Set("all", true)
+	}
+	return FindMessages(MaildirRoot(args[1:]), query, func(msg *Message) error {
+		lines, err := GrepMessage(msg.File.Path, regex)
+		if err != nil {
+			return err
+		}
+		if filesOnly {
+			if len(lines) > 0 {
+				fmt.Printf("%s\n", msg.File.Path)
+			}
+			return nil
+		}
+		for _, line := range lines {
+			fmt.Printf("%s:%s\n", msg.File.Path, line)
+		}
+		return nil
+	})
+}
+
+// GrepMessage returns the lines of the decoded text parts of a message matching regex
+func GrepMessage(pathName string, regex *regexp.Regexp) ([]string, error) {
+	data, _, err := ReadMessage(pathName)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	err = WalkMessageParts(data, func(part *Part) error {
+		if !part.IsText() {
+			return nil
+		}
+		text, err := part.Text()
+		if err != nil {
+			log.Printf("%s: skipped %s part: %v\n", pathName, part.MediaType, err)
+			return nil
+		}
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimRight(line, "\r")
+			if regex.MatchString(line) {
+				lines = append(lines, line)
+			}
+		}
+		return nil
+	})
+	var skipped *PartError
+	if errors.As(err, &skipped) {
+		// the other parts of the message are still searched
+		log.Printf("%s: %v\n", pathName, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", pathName, err)
+	}
+	return lines, nil
+}
+
+func init() {
+	rootCmd.AddCommand(grepCmd)
+	grepCmd.Flags().BoolP("ignore-case", "i", false, "ignore case distinctions")
+	viper.BindPFlag("grep.ignore-case", grepCmd.Flags().Lookup("ignore-case"))
+	grepCmd.Flags().BoolP("files-with-matches", "l", false, "output only matching pathnames")
+	viper.BindPFlag("grep.files-with-matches", grepCmd.Flags().Lookup("files-with-matches"))
+	grepCmd.Flags().String("query", "", "restrict search to messages matching query")
+	viper.BindPFlag("grep.query", grepCmd.Flags().Lookup("query"))
+}
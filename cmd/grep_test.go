@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const multipartMessage = "From: carol@example.com\r\n" +
+	"Subject: encoded\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"XYZ\"\r\n" +
+	"\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Caf=E9 meeting at noon\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PHA+YnVkZ2V0IGRyYWZ0PC9wPg==\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: application/octet-stream; name=\"secret.bin\"\r\n" +
+	"Content-Disposition: attachment; filename=\"secret.bin\"\r\n" +
+	"\r\n" +
+	"budget hidden\r\n" +
+	"--XYZ--\r\n"
+
+func TestGrepMessage(t *testing.T) {
+	root := makeTestMaildir(t)
+	pathName := writeTestMessage(t, root, "1712000000.M9P1.host", "", []byte(multipartMessage), "zstd")
+
+	lines, err := GrepMessage(pathName, regexp.MustCompile("Café"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"Café meeting at noon"}, lines)
+
+	lines, err = GrepMessage(pathName, regexp.MustCompile("budget"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"<p>budget draft</p>"}, lines)
+}
+
+func TestGrepCompressed(t *testing.T) {
+	root := makeTestMaildir(t)
+	files, err := filepath.Glob(filepath.Join(root, "cur", "1709251200*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "quarterly")
+	lines, err := GrepMessage(files[0], regexp.MustCompile("quarterly"))
+	require.Nil(t, err)
+	require.Len(t, lines, 1)
+}
+
+func TestGrepSkipsBadParts(t *testing.T) {
+	root := makeTestMaildir(t)
+	broken := strings.Replace(multipartMessage, "PHA+YnVkZ2V0IGRyYWZ0PC9wPg==", "!!not base64!!", 1)
+	pathName := writeTestMessage(t, root, "1712000000.M9P1.host", "", []byte(broken), "")
+
+	err := WalkMessageParts([]byte(broken), func(part *Part) error { return nil })
+	var skipped *PartError
+	require.True(t, errors.As(err, &skipped))
+	require.Len(t, skipped.Errors, 1)
+
+	// the other parts and messages are still searched
+	lines, err := GrepMessage(pathName, regexp.MustCompile("Café"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"Café meeting at noon"}, lines)
+	require.Nil(t, GrepFiles([]string{"budget", root}))
+}
@@ -0,0 +1,207 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// hardlinkCmd represents the hardlink command
+var hardlinkCmd = &cobra.Command{
+	Use:   "hardlink [DIR...]",
+	Short: "hard link identical message files",
+	Long: `
+Find byte-identical message files in the cur subdirectories of the specified
+maildirs and replace the copies with hard links to a single file.  The
+default DIR is ~/Maildir.  Files are compared by size first, then by SHA-256
+hash.  Only files on the same filesystem with the same owner, group, mode
+and modification time are linked, since hard links share these attributes
+and dovecot takes the received date of a maildir message from its
+modification time.
+
+Flags:
+    --recurse	scan all maildirs rooted at each DIR
+    --dry-run	report the files that would be linked
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(HardlinkFiles(args))
+	},
+}
+
+type linkCandidate struct {
+	path    string
+	stat    *syscall.Stat_t
+	modTime int64
+	hash    string
+}
+
+func HardlinkFiles(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	roots := args
+	if len(roots) == 0 {
+		roots = []string{MaildirRoot(args)}
+	}
+	viper.Set("all", true)
+
+	// group files by device and size
+	type sizeKey struct {
+		dev  uint64
+		size int64
+	}
+	bySize := map[sizeKey][]*linkCandidate{}
+	for _, root := range roots {
+		dirs, err := ListMaildirs(root)
+		if err != nil {
+			return err
+		}
+		for _, dir := range *dirs {
+			files, err := ListMaildirFiles(dir)
+			if err != nil {
+				return err
+			}
+			for _, pathName := range *files {
+				stat, err := os.Lstat(pathName)
+				if err != nil {
+					return fmt.Errorf("Stat failed: %v", err)
+				}
+				sys := stat.Sys().(*syscall.Stat_t)
+				key := sizeKey{uint64(sys.Dev), stat.Size()}
+				bySize[key] = append(bySize[key], &linkCandidate{path: pathName, stat: sys, modTime: stat.ModTime().UnixNano()})
+			}
+		}
+	}
+
+	var saved int64
+	linkCount := 0
+	keys := []sizeKey{}
+	for key := range bySize {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].size > keys[j].size })
+	for _, key := range keys {
+		candidates := bySize[key]
+		if len(candidates) < 2 {
+			continue
+		}
+		byHash := map[string][]*linkCandidate{}
+		for _, candidate := range candidates {
+			hash, err := hashFile(candidate.path)
+			if err != nil {
+				return err
+			}
+			candidate.hash = hash
+			byHash[hash] = append(byHash[hash], candidate)
+		}
+		for _, group := range byHash {
+			// links share ownership, mode and mod time, so only files agreeing on them are linked
+			type attrKey struct {
+				uid, gid uint32
+				mode     uint32
+				modTime  int64
+			}
+			byAttr := map[attrKey][]*linkCandidate{}
+			for _, candidate := range group {
+				akey := attrKey{candidate.stat.Uid, candidate.stat.Gid, uint32(candidate.stat.Mode), candidate.modTime}
+				byAttr[akey] = append(byAttr[akey], candidate)
+			}
+			for _, linked := range byAttr {
+				if len(linked) < 2 {
+					continue
+				}
+				// keep the file with the most links so existing links are reused
+				sort.SliceStable(linked, func(i, j int) bool { return linked[i].stat.Nlink > linked[j].stat.Nlink })
+				keep := linked[0]
+				for _, candidate := range linked[1:] {
+					if candidate.stat.Ino == keep.stat.Ino {
+						continue
+					}
+					if dryRun {
+						fmt.Printf("would link %s -> %s\n", candidate.path, keep.path)
+					} else {
+						err := replaceWithLink(keep.path, candidate.path)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("linked %s -> %s\n", candidate.path, keep.path)
+					}
+					linkCount += 1
+					if candidate.stat.Nlink == 1 {
+						saved += key.size
+					}
+				}
+			}
+		}
+	}
+	fmt.Printf("%d files linked, %d bytes saved\n", linkCount, saved)
+	return nil
+}
+
+func hashFile(pathName string) (string, error) {
+	file, err := os.Open(pathName)
+	if err != nil {
+		return "", fmt.Errorf("failed opening %s: %v", pathName, err)
+	}
+	defer file.Close()
+	digest := sha256.New()
+	_, err = io.Copy(digest, file)
+	if err != nil {
+		return "", fmt.Errorf("failed reading %s: %v", pathName, err)
+	}
+	return fmt.Sprintf("%x", digest.Sum(nil)), nil
+}
+
+// replaceWithLink atomically replaces target with a hard link to source,
+// creating the link in the tmp subdirectory of the target maildir
+func replaceWithLink(source, target string) error {
+	tmpPath := filepath.Join(filepath.Dir(filepath.Dir(target)), "tmp", filepath.Base(target))
+	err := os.Link(source, tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed linking %s: %v", target, err)
+	}
+	err = os.Rename(tmpPath, target)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed replacing %s: %v", target, err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(hardlinkCmd)
+}
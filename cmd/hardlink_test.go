@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHardlink(t *testing.T) {
+	data := testMessage("list@example.com", "all staff", "Mon, 01 Apr 2024 10:00:00 +0000", "announcement")
+	first := makeTestMaildir(t)
+	second := makeTestMaildir(t)
+	third := makeTestMaildir(t)
+	fourth := makeTestMaildir(t)
+	a := writeTestMessage(t, first, "1712000000.M1P1.host", "S", data, "")
+	b := writeTestMessage(t, second, "1712000001.M1P2.host", "", data, "")
+	c := writeTestMessage(t, third, "1712000002.M1P3.host", "", data, "")
+	d := writeTestMessage(t, fourth, "1712000003.M1P4.host", "", data, "")
+	require.Nil(t, os.Chmod(c, 0640))
+	received := time.Unix(1712000000, 0)
+	for _, pathName := range []string{a, b, c} {
+		require.Nil(t, os.Chtimes(pathName, received, received))
+	}
+	// a different mod time is a different received date
+	require.Nil(t, os.Chtimes(d, received.Add(time.Hour), received.Add(time.Hour)))
+
+	viper.Set("dry-run", true)
+	require.Nil(t, HardlinkFiles([]string{first, second, third, fourth}))
+	require.False(t, sameFile(t, a, b))
+
+	viper.Set("dry-run", false)
+	require.Nil(t, HardlinkFiles([]string{first, second, third, fourth}))
+	require.True(t, sameFile(t, a, b))
+	require.False(t, sameFile(t, a, c))
+	require.False(t, sameFile(t, a, d))
+	content, err := os.ReadFile(b)
+	require.Nil(t, err)
+	require.Equal(t, data, content)
+}
+
+func sameFile(t *testing.T, a, b string) bool {
+	statA, err := os.Stat(a)
+	require.Nil(t, err)
+	statB, err := os.Stat(b)
+	require.Nil(t, err)
+	return statA.Sys().(*syscall.Stat_t).Ino == statB.Sys().(*syscall.Stat_t).Ino
+}
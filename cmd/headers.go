@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/text/encoding/htmlindex"
+	"io"
+	"mime"
+	"net/mail"
+)
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: CharsetReader}
+
+// CharsetReader returns a reader converting input in the named charset to UTF-8
+func CharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+// DecodeHeader decodes RFC 2047 encoded words, returning the raw value if decoding fails
+func DecodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// ReadMessageHeader returns the header of a message file, reading through any compression
+func ReadMessageHeader(pathName string) (mail.Header, error) {
+	reader, _, err := OpenMessage(pathName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	msg, err := mail.ReadMessage(bufio.NewReader(reader))
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing message header %s: %v", pathName, err)
+	}
+	return msg.Header, nil
+}
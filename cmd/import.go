@@ -0,0 +1,166 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import messages into a maildir",
+	Long: `
+Import messages from other formats into the maildir rooted at DIR.
+`,
+}
+
+// importMboxCmd represents the import mbox command
+var importMboxCmd = &cobra.Command{
+	Use:   "mbox FILE [DIR]",
+	Short: "import an mbox file into a maildir folder",
+	Long: `
+Split the mbox FILE into messages and deliver each one through the tmp
+subdirectory into cur of the folder in the maildir rooted at DIR.  The default
+DIR is ~/Maildir.  The folder is created if it does not exist.  Status: and
+X-Status: headers are converted to maildir flags and each message is appended
+to dovecot-uidlist and maildirsize.
+
+Flags:
+    --folder NAME	destination folder (default INBOX)
+    --format FORMAT	mbox variant: mboxo, mboxrd, mboxcl or mboxcl2 (default mboxrd)
+    --compress CODEC	compress delivered messages with zstd or gzip
+    --reset-index MODE	remove the cache or all index files of the folder
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ImportMbox(args))
+	},
+}
+
+func ImportMbox(args []string) error {
+	verbose := viper.GetBool("verbose")
+	folder := viper.GetString("folder")
+	codec := viper.GetString("import.compress")
+	err := ValidateCodec(codec)
+	if err != nil {
+		return err
+	}
+	root := MaildirRoot(args[1:])
+	maildir, err := IsMaildir(root)
+	if err != nil {
+		return err
+	}
+	if !maildir {
+		return fmt.Errorf("not a maildir: %s", root)
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed opening mbox: %v", err)
+	}
+	defer file.Close()
+	reader, err := NewMboxReader(file, viper.GetString("import.format"))
+	if err != nil {
+		return err
+	}
+	dir := FolderPath(root, folder)
+	err = MakeMaildir(root, dir)
+	if err != nil {
+		return err
+	}
+	uidlist, err := ReadUidlist(dir)
+	if err != nil {
+		return err
+	}
+	count := 0
+	var totalSize int64
+	err = func() error {
+		for {
+			msg, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			received := msg.Received
+			if received.IsZero() {
+				received = time.Now()
+			}
+			data := StripHeaderFields(msg.Data, mboxHeaderFields...)
+			pathName, err := DeliverMessage(dir, data, msg.Flags(), received, codec)
+			if err != nil {
+				return err
+			}
+			uid := uidlist.Append(pathName)
+			count += 1
+			size, _ := MessageSizes(data)
+			totalSize += size
+			if verbose {
+				log.Printf("delivered uid=%d %s\n", uid, pathName)
+			}
+		}
+	}()
+	if count > 0 {
+		writeErr := uidlist.Write(dir)
+		if err == nil {
+			err = writeErr
+		}
+		quotaErr := AppendQuotaDelta(root, totalSize, int64(count))
+		if err == nil {
+			err = quotaErr
+		}
+		resetErr := ResetIndexes([]string{dir})
+		if err == nil {
+			err = resetErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d messages into %s\n", count, FolderName(root, dir))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importMboxCmd)
+	importMboxCmd.Flags().String("format", "mboxrd", "mbox variant: mboxo, mboxrd, mboxcl, mboxcl2")
+	viper.BindPFlag("import.format", importMboxCmd.Flags().Lookup("format"))
+	importMboxCmd.Flags().String("compress", "", "compress messages with zstd or gzip")
+	viper.BindPFlag("import.compress", importMboxCmd.Flags().Lookup("compress"))
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readMbox(t *testing.T, text, format string) []*MboxMessage {
+	reader, err := NewMboxReader(strings.NewReader(text), format)
+	require.Nil(t, err)
+	messages := []*MboxMessage{}
+	for {
+		msg, err := reader.Next()
+		if err == io.EOF {
+			return messages
+		}
+		require.Nil(t, err)
+		messages = append(messages, msg)
+	}
+}
+
+func TestMboxReaderVariants(t *testing.T) {
+	rd := "From a@example.com Fri Mar  1 10:00:00 2024\nSubject: one\nStatus: RO\nX-Status: AF\n\n>From here\n>>From there\n\nFrom b@example.com Sat Mar  2 10:00:00 2024\nSubject: two\n\nbody\n"
+	messages := readMbox(t, rd, "mboxrd")
+	require.Len(t, messages, 2)
+	require.Equal(t, "Subject: one\nStatus: RO\nX-Status: AF\n\nFrom here\n>From there\n", string(messages[0].Data))
+	require.Equal(t, "FRS", messages[0].Flags())
+	require.Equal(t, 2, messages[1].Received.Day())
+	require.Equal(t, "", messages[1].Flags())
+
+	messages = readMbox(t, rd, "mboxo")
+	require.Equal(t, "Subject: one\nStatus: RO\nX-Status: AF\n\nFrom here\n>>From there\n", string(messages[0].Data))
+
+	cl2 := "From a@example.com Fri Mar  1 10:00:00 2024\nSubject: one\nContent-Length: 21\n\nFrom unescaped\n\nlast\n\nFrom b@example.com Sat Mar  2 10:00:00 2024\nSubject: two\nContent-Length: 5\n\nbody\n"
+	messages = readMbox(t, cl2, "mboxcl2")
+	require.Len(t, messages, 2)
+	require.Equal(t, "Subject: one\n\nFrom unescaped\n\nlast\n", string(messages[0].Data))
+	require.Equal(t, "Subject: two\n\nbody\n", string(messages[1].Data))
+}
+
+func TestImportExportRoundTrip(t *testing.T) {
+	root := makeTestMaildir(t)
+	output := t.TempDir()
+	viper.Set("export.output", output)
+	require.Nil(t, ExportMbox([]string{root}))
+
+	viper.Set("folder", "Restored")
+	viper.Set("import.format", "mboxrd")
+	viper.Set("import.compress", "zstd")
+	require.Nil(t, ImportMbox([]string{filepath.Join(output, "INBOX.mbox"), root}))
+
+	dir := filepath.Join(root, ".Restored")
+	_, err := os.Stat(filepath.Join(dir, "maildirfolder"))
+	require.Nil(t, err)
+	uidlist, err := ReadUidlist(dir)
+	require.Nil(t, err)
+	require.Len(t, uidlist.Entries, 2)
+	require.Equal(t, uint32(3), uidlist.NextUid)
+
+	viper.Set("all", true)
+	files, err := ListMaildirFiles(dir)
+	require.Nil(t, err)
+	require.Len(t, *files, 2)
+	seen := 0
+	for _, pathName := range *files {
+		compressed, err := IsCompressed(pathName)
+		require.Nil(t, err)
+		require.True(t, compressed)
+		file, err := ParseMessageFile(pathName)
+		require.Nil(t, err)
+		data, _, err := ReadMessage(pathName)
+		require.Nil(t, err)
+		require.Equal(t, int64(len(data)), file.Size)
+		require.NotContains(t, string(data), "Status:")
+		if file.HasFlag('S') {
+			seen += 1
+		}
+		uid, ok := uidlist.Lookup(pathName)
+		require.True(t, ok)
+		require.NotZero(t, uid)
+	}
+	require.Equal(t, 1, seen)
+}
@@ -0,0 +1,378 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// indexCmd represents the index command
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "inspect dovecot index files",
+	Long: `
+Inspect the dovecot.index, dovecot.index.log and dovecot.index.cache files of
+the maildirs selected by --folder and --recurse below DIR.  The default DIR is
+~/Maildir.  The dump and check commands only read the index files.
+`,
+}
+
+// indexResetCmd represents the index reset command
+var indexResetCmd = &cobra.Command{
+	Use:   "reset [DIR]",
+	Short: "remove dovecot index files",
+	Long: `
+Remove the dovecot.index, dovecot.index.log and dovecot.index.cache files of
+the selected maildirs so that dovecot rebuilds them from the message files
+and dovecot-uidlist on the next access.  Message uids are kept.  Dovecot
+should not be running.
+
+Flags:
+    --cache-only	remove only dovecot.index.cache
+    --dry-run		output the files which would be removed
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ResetIndexFiles(args))
+	},
+}
+
+// indexDumpCmd represents the index dump command
+var indexDumpCmd = &cobra.Command{
+	Use:   "dump [DIR]",
+	Short: "output the contents of dovecot index files as json",
+	Long: `
+Output the index header, extensions, transaction log records and the
+resulting message records of each selected maildir as json.  Message records
+include the uidlist filename and the sizes cached in dovecot.index.cache.
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(DumpIndexes(args))
+	},
+}
+
+// indexCheckCmd represents the index check command
+var indexCheckCmd = &cobra.Command{
+	Use:   "check [DIR]",
+	Short: "compare dovecot index files with message files",
+	Long: `
+Compare the flags, keywords and cached sizes recorded in the dovecot index
+files of each selected maildir with the message files, reporting differences
+dovecot will find on its next access.  Messages recorded in the index whose
+files are missing are reported as expunged.
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(CheckIndexes(args))
+	},
+}
+
+// IndexDump is the json output of a maildir's index files
+type IndexDump struct {
+	Folder     string            `json:"folder"`
+	Path       string            `json:"path"`
+	Header     IndexHeader       `json:"header"`
+	Extensions []IndexExtension  `json:"extensions"`
+	Log        []*IndexLog       `json:"log"`
+	Cache      *IndexCache       `json:"cache,omitempty"`
+	Messages   []IndexDumpRecord `json:"messages"`
+}
+
+// IndexDumpRecord is the json output of an index record
+type IndexDumpRecord struct {
+	Uid          uint32   `json:"uid"`
+	Filename     string   `json:"filename,omitempty"`
+	Flags        []string `json:"flags"`
+	Keywords     []string `json:"keywords"`
+	Modseq       uint64   `json:"modseq,omitempty"`
+	PhysicalSize *int64   `json:"physical_size,omitempty"`
+	VirtualSize  *int64   `json:"virtual_size,omitempty"`
+}
+
+// maildirIndex holds the index files of a maildir; cache is nil unless the
+// cache file exists and belongs to the index
+type maildirIndex struct {
+	index *MailIndex
+	cache *IndexCache
+}
+
+// readMaildirIndex reads the index files of a maildir, returning nil if it
+// has no index
+func readMaildirIndex(dir string) (*maildirIndex, error) {
+	index, err := ReadMailIndex(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result := maildirIndex{index: index}
+	cache, err := ReadIndexCache(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && cache.Header.IndexId == index.Header.IndexId {
+		ext, ok := index.Extension("cache")
+		if ok && ext.ResetId == cache.Header.FileSeq {
+			result.cache = cache
+		}
+	}
+	return &result, nil
+}
+
+// cachedSize returns a size cached for an index record, or nil
+func (m *maildirIndex) cachedSize(record *IndexRecord, field string) (*int64, error) {
+	if m.cache == nil || record.CacheOffset == 0 {
+		return nil, nil
+	}
+	size, ok, err := m.cache.CachedSize(record.CacheOffset, field)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &size, nil
+}
+
+func indexFlagNames(bits uint8) []string {
+	names := []string{}
+	for _, letter := range IndexFlags(bits) {
+		names = append(names, flagNames[letter])
+	}
+	return names
+}
+
+func DumpIndexes(args []string) error {
+	root := MaildirRoot(args)
+	dirs, err := SelectMaildirs(root)
+	if err != nil {
+		return err
+	}
+	dumps := []IndexDump{}
+	for _, dir := range dirs {
+		maildirIndex, err := readMaildirIndex(dir)
+		if err != nil {
+			return err
+		}
+		if maildirIndex == nil {
+			continue
+		}
+		uidlist, err := ReadUidlist(dir)
+		if err != nil {
+			return err
+		}
+		filenames := map[uint32]string{}
+		for _, entry := range uidlist.Entries {
+			filenames[entry.Uid] = entry.Name
+		}
+		index := maildirIndex.index
+		dump := IndexDump{
+			Folder:     FolderName(root, dir),
+			Path:       dir,
+			Header:     index.Header,
+			Extensions: index.Extensions,
+			Log:        index.Log,
+			Cache:      maildirIndex.cache,
+			Messages:   []IndexDumpRecord{},
+		}
+		for i := range index.Records {
+			record := &index.Records[i]
+			message := IndexDumpRecord{
+				Uid:      record.Uid,
+				Filename: filenames[record.Uid],
+				Flags:    indexFlagNames(record.Flags),
+				Keywords: record.Keywords,
+				Modseq:   record.Modseq,
+			}
+			message.PhysicalSize, err = maildirIndex.cachedSize(record, CacheFieldPhysicalSize)
+			if err != nil {
+				return err
+			}
+			message.VirtualSize, err = maildirIndex.cachedSize(record, CacheFieldVirtualSize)
+			if err != nil {
+				return err
+			}
+			dump.Messages = append(dump.Messages, message)
+		}
+		dumps = append(dumps, dump)
+	}
+	return PrintJSON(dumps)
+}
+
+// CheckIndex returns the differences between the index files of a maildir
+// and its message files
+func CheckIndex(dir string) ([]string, error) {
+	problems := []string{}
+	maildirIndex, err := readMaildirIndex(dir)
+	if err != nil || maildirIndex == nil {
+		return problems, err
+	}
+	index := maildirIndex.index
+	uidlist, err := ReadUidlist(dir)
+	if err != nil {
+		return nil, err
+	}
+	if index.Header.UidValidity != 0 && uidlist.UidValidity != 0 && index.Header.UidValidity != uidlist.UidValidity {
+		problems = append(problems, fmt.Sprintf("uidvalidity %d of index differs from %d of uidlist", index.Header.UidValidity, uidlist.UidValidity))
+	}
+	keywords, err := ReadKeywords(dir)
+	if err != nil {
+		return nil, err
+	}
+	// every message is indexed, including those still in new
+	files := []string{}
+	for _, sub := range []string{"cur", "new"} {
+		matches, err := filepath.Glob(filepath.Join(dir, sub, "*"))
+		if err != nil {
+			return nil, fmt.Errorf("Glob failed: %v", err)
+		}
+		files = append(files, matches...)
+	}
+	found := map[uint32]bool{}
+	for _, file := range files {
+		uid, ok := uidlist.Lookup(file)
+		if !ok {
+			continue
+		}
+		record, ok := index.Lookup(uid)
+		if !ok {
+			continue
+		}
+		found[uid] = true
+		msg, err := NewMessage(dir, "", nil, file)
+		if err != nil {
+			return nil, err
+		}
+		fileFlags := IndexFlags(maildirIndexFlags(msg.File.Flags))
+		indexFlags := IndexFlags(record.Flags)
+		if fileFlags != indexFlags {
+			problems = append(problems, fmt.Sprintf("uid %d: index flags '%s' differ from file flags '%s': %s", uid, indexFlags, fileFlags, file))
+		}
+		fileKeywords, _ := msg.File.KeywordNames(keywords)
+		if !sameKeywords(record.Keywords, fileKeywords) {
+			problems = append(problems, fmt.Sprintf("uid %d: index keywords %v differ from file keywords %v: %s", uid, record.Keywords, fileKeywords, file))
+		}
+		cached, err := maildirIndex.cachedSize(record, CacheFieldPhysicalSize)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			size, err := msg.Size()
+			if err != nil {
+				return nil, err
+			}
+			if *cached != size {
+				problems = append(problems, fmt.Sprintf("uid %d: cached size %d differs from message size %d: %s", uid, *cached, size, file))
+			}
+		}
+	}
+	for _, record := range index.Records {
+		if !found[record.Uid] {
+			problems = append(problems, fmt.Sprintf("uid %d: indexed message file not found; dovecot will expunge it", record.Uid))
+		}
+	}
+	return problems, nil
+}
+
+func sameKeywords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, "\n") == strings.Join(b, "\n")
+}
+
+func CheckIndexes(args []string) error {
+	root := MaildirRoot(args)
+	dirs, err := SelectMaildirs(root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		problems, err := CheckIndex(dir)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", FolderName(root, dir), problem)
+		}
+	}
+	return nil
+}
+
+func ResetIndexFiles(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	mode := IndexResetAll
+	if viper.GetBool("index.cache-only") {
+		mode = IndexResetCache
+	}
+	dirs, err := SelectMaildirs(MaildirRoot(args))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if dryRun {
+			for _, name := range indexResetFiles[mode] {
+				pathName := filepath.Join(dir, name)
+				_, err := os.Stat(pathName)
+				if err == nil {
+					fmt.Printf("would remove %s\n", pathName)
+				}
+			}
+			continue
+		}
+		removed, err := ResetIndex(dir, mode)
+		if err != nil {
+			return err
+		}
+		for _, pathName := range removed {
+			fmt.Printf("removed %s\n", pathName)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(indexCmd)
+	indexCmd.AddCommand(indexDumpCmd)
+	indexCmd.AddCommand(indexCheckCmd)
+	indexCmd.AddCommand(indexResetCmd)
+	indexResetCmd.Flags().Bool("cache-only", false, "remove only the index cache file")
+	viper.BindPFlag("index.cache-only", indexResetCmd.Flags().Lookup("cache-only"))
+}
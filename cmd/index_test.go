@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testIndexRecord struct {
+	uid         uint32
+	flags       uint8
+	cacheOffset uint32
+	keywords    uint8
+}
+
+func testIndexExtension(name string, header []byte, recordOffset, recordSize uint16) []byte {
+	le := binary.LittleEndian
+	ext := make([]byte, align8(16+len(name))+align8(len(header)))
+	le.PutUint32(ext, uint32(len(header)))
+	le.PutUint32(ext[4:], 7)
+	le.PutUint16(ext[8:], recordOffset)
+	le.PutUint16(ext[10:], recordSize)
+	le.PutUint16(ext[12:], recordSize)
+	le.PutUint16(ext[14:], uint16(len(name)))
+	copy(ext[16:], name)
+	copy(ext[align8(16+len(name)):], header)
+	return ext
+}
+
+// writeTestIndex writes a dovecot.index with cache and keywords extensions
+// and a dovecot.index.log removing the flagged flag from uid 2 and expunging
+// uid 4 the way dovecot tags expunges
+func writeTestIndex(t *testing.T, dir string, records []testIndexRecord) {
+	le := binary.LittleEndian
+	keywordsHeader := []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	keywordsHeader = append(keywordsHeader, []byte("$Junk\x00")...)
+	extensions := append(testIndexExtension("cache", nil, 8, 4), testIndexExtension("keywords", keywordsHeader, 12, 1)...)
+	data := [][]byte{}
+	for _, record := range records {
+		rec := make([]byte, 16)
+		le.PutUint32(rec, record.uid)
+		rec[4] = record.flags
+		le.PutUint32(rec[8:], record.cacheOffset)
+		rec[12] = record.keywords
+		data = append(data, rec)
+	}
+	writeTestIndexFile(t, filepath.Join(dir, IndexFile), extensions, 16, data)
+	writeTestIndexLog(t, dir)
+}
+
+// writeTestIndexFile writes an index file of records of recordSize bytes
+// following the extension headers
+func writeTestIndexFile(t *testing.T, pathName string, extensions []byte, recordSize int, records [][]byte) {
+	le := binary.LittleEndian
+	data := make([]byte, indexBaseHeaderSize)
+	data[0] = indexMajorVersion
+	data[1] = 3
+	le.PutUint16(data[2:], indexBaseHeaderSize)
+	le.PutUint32(data[4:], uint32(indexBaseHeaderSize+len(extensions)))
+	le.PutUint32(data[8:], uint32(recordSize))
+	data[12] = indexCompatLittleEnd
+	le.PutUint32(data[16:], 42)
+	le.PutUint32(data[24:], 1700000000)
+	le.PutUint32(data[28:], 4)
+	le.PutUint32(data[32:], uint32(len(records)))
+	le.PutUint32(data[60:], 1)
+	le.PutUint32(data[68:], indexLogHeaderSize)
+	data = append(data, extensions...)
+	for _, record := range records {
+		data = append(data, record...)
+	}
+	require.Nil(t, os.WriteFile(pathName, data, 0600))
+}
+
+// writeTestIndexLog writes the dovecot.index.log of writeTestIndex
+func writeTestIndexLog(t *testing.T, dir string) {
+	le := binary.LittleEndian
+	log := make([]byte, indexLogHeaderSize+20+28)
+	log[0] = indexLogMajorVersion
+	log[1] = 3
+	le.PutUint16(log[2:], indexLogHeaderSize)
+	le.PutUint32(log[4:], 42)
+	le.PutUint32(log[8:], 1)
+	log[32] = indexCompatLittleEnd
+	copy(log[indexLogHeaderSize:], uint32ToOffset(20))
+	le.PutUint32(log[indexLogHeaderSize+4:], logFlagUpdate)
+	le.PutUint32(log[indexLogHeaderSize+8:], 2)
+	le.PutUint32(log[indexLogHeaderSize+12:], 2)
+	log[indexLogHeaderSize+17] = indexFlagFlagged
+	expunge := log[indexLogHeaderSize+20:]
+	copy(expunge, uint32ToOffset(28))
+	le.PutUint32(expunge[4:], logExpungeGuid|logExpungeProt)
+	le.PutUint32(expunge[8:], 4)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, IndexLogFile), log, 0600))
+}
+
+// writeTestIndexCache writes a dovecot.index.cache with a size.physical
+// record at offset 68
+func writeTestIndexCache(t *testing.T, dir string, size uint64) {
+	le := binary.LittleEndian
+	data := make([]byte, 88)
+	data[0] = indexCacheMajorVersion
+	data[1] = 8
+	le.PutUint32(data[4:], 42)
+	le.PutUint32(data[8:], 7)
+	copy(data[28:], uint32ToOffset(32))
+	le.PutUint32(data[36:], 36)
+	le.PutUint32(data[40:], 1)
+	le.PutUint32(data[48:], 8)
+	copy(data[54:], "size.physical\x00")
+	le.PutUint32(data[72:], 20)
+	le.PutUint64(data[80:], size)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, IndexCacheFile), data, 0600))
+}
+
+func TestOffsetEncoding(t *testing.T) {
+	for _, value := range []uint32{0, 4, 40, 1024, 123456, 0x3ffffffc} {
+		require.Equal(t, value, offsetToUint32(uint32ToOffset(value)))
+	}
+	require.Equal(t, uint32(0), offsetToUint32([]byte{0, 0, 0, 0}))
+}
+
+func TestReadMailIndex(t *testing.T) {
+	root := makeTestMaildir(t)
+	_, err := ReadMailIndex(root)
+	require.True(t, os.IsNotExist(err))
+
+	writeTestIndex(t, root, []testIndexRecord{
+		{uid: 1, flags: indexFlagSeen, cacheOffset: 68, keywords: 1},
+		{uid: 2, flags: indexFlagFlagged},
+		{uid: 4},
+	})
+	writeTestIndexCache(t, root, 170)
+	index, err := ReadMailIndex(root)
+	require.Nil(t, err)
+	require.Equal(t, uint32(1700000000), index.Header.UidValidity)
+	require.Len(t, index.Extensions, 2)
+	require.Len(t, index.Records, 2)
+	require.Equal(t, []string{"$Junk"}, index.Records[0].Keywords)
+	require.Equal(t, "", IndexFlags(index.Records[1].Flags))
+	require.Equal(t, uint32(2), index.Records[1].Uid)
+	require.Len(t, index.Log, 1)
+	require.Equal(t, "flag-update", index.Log[0].Records[0].TypeName)
+	require.Equal(t, "expunge-guid", index.Log[0].Records[1].TypeName)
+	require.Equal(t, [][2]uint32{{4, 4}}, index.Log[0].Records[1].UidRanges)
+
+	cache, err := ReadIndexCache(root)
+	require.Nil(t, err)
+	size, ok, err := cache.CachedSize(68, CacheFieldPhysicalSize)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(170), size)
+	require.Nil(t, DumpIndexes([]string{root}))
+}
+
+func TestCheckIndex(t *testing.T) {
+	root := makeTestMaildir(t)
+	writeTestIndex(t, root, []testIndexRecord{
+		{uid: 1, flags: indexFlagSeen, cacheOffset: 68, keywords: 1},
+		{uid: 2, flags: indexFlagFlagged},
+		{uid: 3},
+		{uid: 4},
+	})
+	writeTestIndexCache(t, root, 999)
+	problems, err := CheckIndex(root)
+	require.Nil(t, err)
+	require.Len(t, problems, 3)
+	require.Contains(t, problems[0], "uid 1: index keywords [$Junk]")
+	require.Contains(t, problems[1], "uid 1: cached size 999 differs from message size 170")
+	require.Contains(t, problems[2], "uid 3: indexed message file not found")
+
+	problems, err = CheckIndex(filepath.Join(root, ".Archive"))
+	require.Nil(t, err)
+	require.Empty(t, problems)
+}
+
+func TestResetIndex(t *testing.T) {
+	root := makeTestMaildir(t)
+	writeTestIndex(t, root, []testIndexRecord{{uid: 1, flags: indexFlagSeen, cacheOffset: 68}})
+	writeTestIndexCache(t, root, 170)
+	removed, err := ResetIndex(root, IndexResetCache)
+	require.Nil(t, err)
+	require.Equal(t, []string{filepath.Join(root, IndexCacheFile)}, removed)
+	_, err = ResetIndex(root, "everything")
+	require.NotNil(t, err)
+
+	writeTestIndexCache(t, root, 170)
+	viper.Set("reset-index", IndexResetAll)
+	viper.Set("move.query", "from:bob")
+	require.Nil(t, TransferMessages([]string{"Archive", root}, "move"))
+	for _, name := range []string{IndexFile, IndexLogFile, IndexCacheFile} {
+		_, err := os.Stat(filepath.Join(root, name))
+		require.True(t, os.IsNotExist(err))
+	}
+	_, err = os.Stat(filepath.Join(root, UidlistFile))
+	require.Nil(t, err)
+}
@@ -0,0 +1,259 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// keywordCmd represents the keyword command
+var keywordCmd = &cobra.Command{
+	Use:   "keyword",
+	Short: "manage custom IMAP keywords",
+	Long: `
+Manage the custom IMAP keywords such as $Junk or $label1 of the messages in
+the maildirs selected by --folder and --recurse below DIR.  The default DIR is
+~/Maildir.  Dovecot stores keywords as the lowercase letters a-z in the flags
+of message filenames, mapped to names by the dovecot-keywords file of each
+maildir.  Keyword names are matched ignoring case.
+`,
+}
+
+// keywordListCmd represents the keyword list command
+var keywordListCmd = &cobra.Command{
+	Use:   "list [DIR]",
+	Short: "list the keywords of each folder",
+	Long: `
+Output the letter, name and number of messages of each keyword mapped in the
+dovecot-keywords files of the selected maildirs.
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ListKeywords(args))
+	},
+}
+
+// keywordAddCmd represents the keyword add command
+var keywordAddCmd = &cobra.Command{
+	Use:   "add NAME [DIR]",
+	Short: "set a keyword on messages",
+	Long: `
+Set the keyword NAME on the messages matching a find QUERY, adding it to the
+dovecot-keywords file of their maildir if necessary.  Dovecot should not be
+running while keywords are changed.
+
+Flags:
+    --query QUERY	set the keyword on messages matching QUERY (required)
+    --dry-run		output the renames without performing them
+    --reset-index MODE	remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(SetKeyword(args, true))
+	},
+}
+
+// keywordRemoveCmd represents the keyword remove command
+var keywordRemoveCmd = &cobra.Command{
+	Use:   "remove NAME [DIR]",
+	Short: "clear a keyword from messages",
+	Long: `
+Clear the keyword NAME from the messages matching a find QUERY.  The
+dovecot-keywords mapping is left in place.
+
+Flags:
+    --query QUERY	clear the keyword from messages matching QUERY (required)
+    --dry-run		output the renames without performing them
+    --reset-index MODE	remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(SetKeyword(args, false))
+	},
+}
+
+// keywordCheckCmd represents the keyword check command
+var keywordCheckCmd = &cobra.Command{
+	Use:   "check [DIR]",
+	Short: "report keyword letters with no mapping",
+	Long: `
+Output each message in the selected maildirs with keyword letters in its
+flags which are not mapped by the dovecot-keywords file of its maildir.
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(CheckKeywords(args))
+	},
+}
+
+func ListKeywords(args []string) error {
+	root := MaildirRoot(args)
+	viper.Set("all", true)
+	dirs, err := SelectMaildirs(root)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "FOLDER\tLETTER\tKEYWORD\tMESSAGES")
+	for _, dir := range dirs {
+		keywords, err := ReadKeywords(dir)
+		if err != nil {
+			return err
+		}
+		counts := map[rune]int{}
+		files, err := ListMaildirFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range *files {
+			msg, err := ParseMessageFile(file)
+			if err != nil {
+				return err
+			}
+			for _, letter := range msg.Flags {
+				counts[letter] += 1
+			}
+		}
+		for i, name := range keywords.Names {
+			if name != "" {
+				letter := 'a' + rune(i)
+				fmt.Fprintf(writer, "%s\t%c\t%s\t%d\n", FolderName(root, dir), letter, name, counts[letter])
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+// SetKeyword sets or clears a keyword on the messages matching the query flag
+func SetKeyword(args []string, set bool) error {
+	dryRun := viper.GetBool("dry-run")
+	name := args[0]
+	query, err := QueryFlag("keyword.query")
+	if err != nil {
+		return err
+	}
+	if query == nil {
+		return fmt.Errorf("a --query selecting the messages to change is required")
+	}
+	viper.Set("all", true)
+	messages := []*Message{}
+	err = FindMessages(MaildirRoot(args[1:]), query, func(msg *Message) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mover := NewMessageMover(MaildirRoot(args[1:]))
+	for _, msg := range messages {
+		var flags string
+		if set {
+			letter, err := mover.keywordLetter(msg.Maildir, name)
+			if err != nil {
+				return fmt.Errorf("%s: %v", msg.Folder, err)
+			}
+			flags = SortFlags(msg.File.Flags + string(letter))
+		} else {
+			keywords, err := mover.readKeywords(msg.Maildir)
+			if err != nil {
+				return err
+			}
+			letter, ok := keywords.Letter(name)
+			if !ok {
+				continue
+			}
+			flags = strings.ReplaceAll(msg.File.Flags, string(letter), "")
+		}
+		if flags == msg.File.Flags {
+			continue
+		}
+		if dryRun {
+			target := filepath.Join(filepath.Dir(msg.File.Path), msg.File.Filename(flags))
+			fmt.Printf("rename %s -> %s\n", msg.File.Path, target)
+			continue
+		}
+		target, err := mover.SetFlagLetters(msg, flags)
+		if err != nil {
+			mover.Close()
+			return err
+		}
+		fmt.Printf("renamed %s -> %s\n", msg.File.Path, target)
+	}
+	if dryRun {
+		return nil
+	}
+	return mover.Close()
+}
+
+func CheckKeywords(args []string) error {
+	viper.Set("all", true)
+	dirs, err := SelectMaildirs(MaildirRoot(args))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		keywords, err := ReadKeywords(dir)
+		if err != nil {
+			return err
+		}
+		files, err := ListMaildirFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range *files {
+			msg, err := ParseMessageFile(file)
+			if err != nil {
+				return err
+			}
+			_, unmapped := msg.KeywordNames(keywords)
+			if unmapped != "" {
+				fmt.Printf("%s: unmapped keyword letters: %s\n", file, unmapped)
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(keywordCmd)
+	keywordCmd.AddCommand(keywordListCmd)
+	keywordCmd.AddCommand(keywordAddCmd)
+	keywordCmd.AddCommand(keywordRemoveCmd)
+	keywordCmd.AddCommand(keywordCheckCmd)
+	keywordCmd.PersistentFlags().String("query", "", "change messages matching query")
+	viper.BindPFlag("keyword.query", keywordCmd.PersistentFlags().Lookup("query"))
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetKeyword(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("keyword.query", "from:bob")
+	require.Nil(t, SetKeyword([]string{"$Junk", root}, true))
+	files, err := filepath.Glob(filepath.Join(root, "cur", "1711929600.M2P1.host*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "1711929600.M2P1.host,S=165,W=172:2,a", filepath.Base(files[0]))
+
+	query, err := ParseQuery("keyword:$junk")
+	require.Nil(t, err)
+	viper.Set("all", true)
+	viper.Set("recurse", true)
+	found := []string{}
+	require.Nil(t, FindMessages(root, query, func(msg *Message) error {
+		found = append(found, msg.File.Base)
+		return nil
+	}))
+	require.Equal(t, []string{"1711929600.M2P1.host"}, found)
+
+	viper.Set("recurse", false)
+	viper.Set("move.query", "keyword:$junk")
+	require.Nil(t, TransferMessages([]string{"Archive", root}, "move"))
+	keywords, err := ReadKeywords(filepath.Join(root, ".Archive"))
+	require.Nil(t, err)
+	require.Equal(t, "$Junk", keywords.Names[0])
+
+	viper.Set("keyword.query", "keyword:$junk")
+	viper.Set("folder", "Archive")
+	require.Nil(t, SetKeyword([]string{"$Junk", root}, false))
+	files, err = filepath.Glob(filepath.Join(root, ".Archive", "cur", "1711929600.M2P1.host*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "1711929600.M2P1.host,S=165,W=172:2,", filepath.Base(files[0]))
+}
@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dovecot maildir directory layouts
+const (
+	LayoutMaildirPlusPlus = "maildir++"
+	LayoutFS              = "fs"
+)
+
+// Folder is an IMAP folder stored as a maildir
+type Folder struct {
+	Name string
+	Path string
+}
+
+// FolderLayout returns the configured maildir layout
+func FolderLayout() (string, error) {
+	layout := strings.ToLower(viper.GetString("layout"))
+	switch layout {
+	case "", LayoutMaildirPlusPlus:
+		return LayoutMaildirPlusPlus, nil
+	case LayoutFS:
+		return LayoutFS, nil
+	}
+	return "", fmt.Errorf("unknown maildir layout: %s", layout)
+}
+
+// LayoutSeparator returns the character separating hierarchy levels in folder directory names
+func LayoutSeparator(layout string) string {
+	if layout == LayoutFS {
+		return "/"
+	}
+	return "."
+}
+
+// currentLayout returns the configured layout, defaulting to Maildir++ if it
+// is invalid; commands validate the layout with FolderLayout
+func currentLayout() string {
+	layout, err := FolderLayout()
+	if err != nil {
+		return LayoutMaildirPlusPlus
+	}
+	return layout
+}
+
+// decodeFolderComponent converts a directory name component to a folder name
+// component, decoding modified UTF-7 unless the UTF8 option is configured
+func decodeFolderComponent(component string) string {
+	if viper.GetBool("utf8") {
+		return component
+	}
+	decoded, err := DecodeModifiedUTF7(component)
+	if err != nil {
+		return component
+	}
+	return decoded
+}
+
+func encodeFolderComponent(component string) string {
+	if viper.GetBool("utf8") {
+		return component
+	}
+	return EncodeModifiedUTF7(component)
+}
+
+// EncodeFolderName returns a folder name with each hierarchy level encoded as
+// it is stored on disk and in the subscriptions file
+func EncodeFolderName(name string) string {
+	components := splitFolderName(name, currentLayout())
+	if len(components) == 1 && strings.EqualFold(components[0], "INBOX") {
+		return "INBOX"
+	}
+	for i, component := range components {
+		components[i] = encodeFolderComponent(component)
+	}
+	return strings.Join(components, "/")
+}
+
+// DecodeFolderName returns the display form of a folder name stored on disk
+func DecodeFolderName(name string) string {
+	components := strings.Split(name, "/")
+	for i, component := range components {
+		components[i] = decodeFolderComponent(component)
+	}
+	return strings.Join(components, "/")
+}
+
+// FolderName returns the IMAP folder name, with '/' separating hierarchy
+// levels, of a maildir below the root maildir
+func FolderName(root, dir string) string {
+	return LayoutFolderName(root, dir, currentLayout())
+}
+
+// LayoutFolderName returns the IMAP folder name of a maildir in the given layout
+func LayoutFolderName(root, dir, layout string) string {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return "INBOX"
+	}
+	rel = filepath.ToSlash(rel)
+	if layout == LayoutMaildirPlusPlus {
+		rel = strings.TrimPrefix(rel, ".")
+	}
+	components := strings.Split(rel, LayoutSeparator(layout))
+	for i, component := range components {
+		components[i] = decodeFolderComponent(component)
+	}
+	return strings.Join(components, "/")
+}
+
+// FolderPath returns the maildir directory of a folder below the root maildir;
+// in the Maildir++ layout '.' is also accepted as the hierarchy separator
+func FolderPath(root, folder string) string {
+	return LayoutFolderPath(root, folder, currentLayout())
+}
+
+// LayoutFolderPath returns the maildir directory of a folder in the given layout
+func LayoutFolderPath(root, folder, layout string) string {
+	components := splitFolderName(folder, layout)
+	if len(components) == 0 || (len(components) == 1 && strings.EqualFold(components[0], "INBOX")) {
+		return root
+	}
+	for i, component := range components {
+		components[i] = encodeFolderComponent(component)
+	}
+	if layout == LayoutFS {
+		return filepath.Join(root, filepath.Join(components...))
+	}
+	return filepath.Join(root, "."+strings.Join(components, "."))
+}
+
+func splitFolderName(folder, layout string) []string {
+	if layout == LayoutMaildirPlusPlus {
+		folder = strings.ReplaceAll(folder, ".", "/")
+	}
+	components := []string{}
+	for _, component := range strings.Split(folder, "/") {
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// ListFolders returns all folders of the root maildir sorted by name
+func ListFolders(root string) ([]Folder, error) {
+	layout, err := FolderLayout()
+	if err != nil {
+		return nil, err
+	}
+	return ListLayoutFolders(root, layout)
+}
+
+// ListLayoutFolders returns all folders of the root maildir in the given layout
+func ListLayoutFolders(root, layout string) ([]Folder, error) {
+	recurse := viper.GetBool("recurse")
+	viper.Set("recurse", true)
+	dirs, err := ListMaildirs(root)
+	viper.Set("recurse", recurse)
+	if err != nil {
+		return nil, err
+	}
+	folders := []Folder{}
+	for _, dir := range *dirs {
+		if layout == LayoutMaildirPlusPlus && dir != root {
+			// Maildir++ folders are dot-directories directly below the root
+			rel, err := filepath.Rel(root, dir)
+			if err != nil || strings.Contains(rel, string(filepath.Separator)) || !strings.HasPrefix(rel, ".") {
+				continue
+			}
+		}
+		folders = append(folders, Folder{Name: LayoutFolderName(root, dir, layout), Path: dir})
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Path == root {
+			return true
+		}
+		if folders[j].Path == root {
+			return false
+		}
+		return folders[i].Name < folders[j].Name
+	})
+	return folders, nil
+}
+
+// SelectMaildirs returns the maildirs below the root maildir selected by the
+// --folder and --recurse flags; with --recurse a folder selects its children
+func SelectMaildirs(root string) ([]string, error) {
+	folder := viper.GetString("folder")
+	if folder == "" {
+		dirs, err := ListMaildirs(root)
+		if err != nil {
+			return nil, err
+		}
+		return *dirs, nil
+	}
+	dir := FolderPath(root, folder)
+	maildir, err := IsMaildir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("folder not found: %s", folder)
+	}
+	if !maildir {
+		return nil, fmt.Errorf("not a maildir: %s", dir)
+	}
+	if !viper.GetBool("recurse") {
+		return []string{dir}, nil
+	}
+	folders, err := ListFolders(root)
+	if err != nil {
+		return nil, err
+	}
+	name := FolderName(root, dir)
+	dirs := []string{}
+	for _, f := range folders {
+		if dir == root || f.Name == name || strings.HasPrefix(f.Name, name+"/") {
+			dirs = append(dirs, f.Path)
+		}
+	}
+	return dirs, nil
+}
+
+// parentDirs returns the directories from below root down to dir
+func parentDirs(root, dir string) []string {
+	dirs := []string{}
+	for ; dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	return dirs
+}
+
+// MakeMaildir creates a maildir folder below the root maildir with the mode
+// and ownership of the root
+func MakeMaildir(root, dir string) error {
+	stat, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	for _, path := range append(parentDirs(root, dir), filepath.Join(dir, "cur"), filepath.Join(dir, "new"), filepath.Join(dir, "tmp")) {
+		err := os.Mkdir(path, stat.Mode().Perm())
+		if err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed creating maildir: %v", err)
+		}
+		err = SetOwner(path, stat)
+		if err != nil {
+			return err
+		}
+	}
+	if dir == root {
+		return nil
+	}
+	// Maildir++ marks folders with an empty maildirfolder file
+	marker := filepath.Join(dir, "maildirfolder")
+	_, err = os.Stat(marker)
+	if os.IsNotExist(err) {
+		err = os.WriteFile(marker, []byte{}, 0600)
+		if err != nil {
+			return fmt.Errorf("failed creating maildirfolder: %v", err)
+		}
+		err = SetOwner(marker, stat)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -36,6 +36,7 @@ import (
 	"github.com/spf13/viper"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // listCmd represents the list command
@@ -48,9 +49,19 @@ of the specified maildir. The default DIR is ~/Maildir
 
 Flags:
     --recurse	    scan all maildirs rooted at DIR
+    --folder NAME   scan the named folder of DIR instead of DIR
     --uncompressed  output uncompressed message pathnames
     --all	    output all message pathnames
-    --maildirs	    output maildirs containing selected files
+    --maildirs	    output the folder names of maildirs containing selected files
+    --flags	    append the flag names and dovecot-keywords of each message
+    --cache	    only examine files new or changed since the last cached scan
+    --rescan	    examine every file and rebuild the scan cache
+
+With --cache the compression and uncompressed sizes found in each file are
+kept in the dovecot-maildir-cache file of its maildir, keyed by filename, size
+and mod time, so later runs open only new or changed files.  With --verbose a
+message whose S= or W= filename value mismatches its content is reported when
+it is scanned.
 `,
 	Args: cobra.RangeArgs(0, 1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -60,18 +71,37 @@ Flags:
 
 func ListFiles(args []string) error {
 	maildirs := viper.GetBool("maildirs")
-	dirs, err := ListMaildirs(MaildirRoot(args))
+	showFlags := viper.GetBool("list.flags")
+	root := MaildirRoot(args)
+	dirs, err := SelectMaildirs(root)
 	if err != nil {
 		return err
 	}
-	for _, dir := range *dirs {
+	for _, dir := range dirs {
 		files, err := ListMaildirFiles(dir)
 		if err != nil {
 			return err
 		}
 		if maildirs {
 			if len(*files) > 0 {
-				fmt.Printf("%s\n", dir)
+				fmt.Printf("%s\n", FolderName(root, dir))
+			}
+		} else if showFlags {
+			keywords, err := ReadKeywords(dir)
+			if err != nil {
+				return err
+			}
+			for _, file := range *files {
+				msg, err := ParseMessageFile(file)
+				if err != nil {
+					return err
+				}
+				names, unmapped := msg.KeywordNames(keywords)
+				names = append(msg.FlagNames(), names...)
+				for _, letter := range unmapped {
+					names = append(names, "?"+string(letter))
+				}
+				fmt.Printf("%s\t%s\n", file, strings.Join(names, ","))
 			}
 		} else {
 			for _, file := range *files {
@@ -94,5 +124,6 @@ func MaildirRoot(args []string) string {
 
 func init() {
 	rootCmd.AddCommand(listCmd)
-
+	listCmd.Flags().Bool("flags", false, "output message flags and keywords")
+	viper.BindPFlag("list.flags", listCmd.Flags().Lookup("flags"))
 }
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const MaildirsizeFile = "maildirsize"
+
+// Maildirsize holds the contents of a Maildir++ maildirsize quota file
+type Maildirsize struct {
+	Definition   string
+	StorageLimit int64
+	MessageLimit int64
+	Size         int64
+	Count        int64
+	Lines        int
+}
+
+// ParseQuotaDefinition parses a quota definition such as 1000000S,1000C
+func ParseQuotaDefinition(definition string) (int64, int64, error) {
+	var storage, messages int64
+	for _, field := range strings.Split(strings.TrimSpace(definition), ",") {
+		if field == "" {
+			continue
+		}
+		value, err := strconv.ParseInt(field[:len(field)-1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid quota definition: %s", definition)
+		}
+		switch field[len(field)-1] {
+		case 'S':
+			storage = value
+		case 'C':
+			messages = value
+		default:
+			return 0, 0, fmt.Errorf("invalid quota definition: %s", definition)
+		}
+	}
+	return storage, messages, nil
+}
+
+// ReadMaildirsize reads the maildirsize file of a root maildir, summing the
+// size and count lines
+func ReadMaildirsize(root string) (*Maildirsize, error) {
+	file, err := os.Open(filepath.Join(root, MaildirsizeFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed opening maildirsize: %v", err)
+	}
+	defer file.Close()
+	quota := Maildirsize{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineNumber += 1
+		if lineNumber == 1 {
+			quota.Definition = line
+			quota.StorageLimit, quota.MessageLimit, err = ParseQuotaDefinition(line)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("maildirsize line %d: invalid format: %s", lineNumber, line)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("maildirsize line %d: invalid size: %s", lineNumber, fields[0])
+		}
+		count, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("maildirsize line %d: invalid count: %s", lineNumber, fields[1])
+		}
+		quota.Size += size
+		quota.Count += count
+		quota.Lines += 1
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading maildirsize: %v", err)
+	}
+	return &quota, nil
+}
+
+// Write replaces the maildirsize file of a root maildir with the definition
+// and a single line holding the totals
+func (m *Maildirsize) Write(root string) error {
+	pathName := filepath.Join(root, MaildirsizeFile)
+	stat, err := os.Stat(pathName)
+	if os.IsNotExist(err) {
+		stat, err = os.Stat(root)
+	}
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	tmpPath := fmt.Sprintf("%s.%d", pathName, os.Getpid())
+	content := fmt.Sprintf("%s\n%d %d\n", m.Definition, m.Size, m.Count)
+	err = os.WriteFile(tmpPath, []byte(content), 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing maildirsize: %v", err)
+	}
+	err = SetOwner(tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, pathName)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed replacing maildirsize: %v", err)
+	}
+	m.Lines = 1
+	return nil
+}
+
+// CalculateQuota returns the total S= size and message count of the cur and
+// new messages in all maildirs rooted at root
+func CalculateQuota(root string) (int64, int64, error) {
+	var size, count int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		maildir, err := IsMaildir(path)
+		if err != nil {
+			return err
+		}
+		if !maildir {
+			return nil
+		}
+		for _, sub := range []string{"cur", "new"} {
+			entries, err := os.ReadDir(filepath.Join(path, sub))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			for _, entry := range entries {
+				if !entry.Type().IsRegular() {
+					continue
+				}
+				msg, err := NewMessage(path, "", nil, filepath.Join(path, sub, entry.Name()))
+				if err != nil {
+					return err
+				}
+				msgSize, err := msg.Size()
+				if err != nil {
+					return err
+				}
+				size += msgSize
+				count += 1
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("WalkDir failed: %v", err)
+	}
+	return size, count, nil
+}
+
+// RecalcQuota rebuilds the maildirsize file of a root maildir from the
+// message sizes, keeping the existing definition unless one is given
+func RecalcQuota(root, definition string) (*Maildirsize, error) {
+	quota, err := ReadMaildirsize(root)
+	if err != nil {
+		if definition == "" {
+			return nil, err
+		}
+		quota = &Maildirsize{}
+	}
+	if definition != "" {
+		quota.StorageLimit, quota.MessageLimit, err = ParseQuotaDefinition(definition)
+		if err != nil {
+			return nil, err
+		}
+		quota.Definition = definition
+	}
+	quota.Size, quota.Count, err = CalculateQuota(root)
+	if err != nil {
+		return nil, err
+	}
+	err = quota.Write(root)
+	if err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// UpdateQuota recalculates the maildirsize file of a root maildir if it
+// exists, unless quota updates are skipped
+func UpdateQuota(root string) error {
+	if viper.GetBool("skip-quota") {
+		return nil
+	}
+	_, err := os.Stat(filepath.Join(root, MaildirsizeFile))
+	if err != nil {
+		return nil
+	}
+	_, err = RecalcQuota(root, "")
+	return err
+}
+
+// AppendQuotaDelta records a size and message count change in the maildirsize
+// file of the root maildir if it exists, unless quota updates are skipped
+func AppendQuotaDelta(root string, size, count int64) error {
+	if viper.GetBool("skip-quota") {
+		return nil
+	}
+	if size == 0 && count == 0 {
+		return nil
+	}
+	pathName := filepath.Join(root, MaildirsizeFile)
+	file, err := os.OpenFile(pathName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed opening maildirsize: %v", err)
+	}
+	_, err = fmt.Fprintf(file, "%d %d\n", size, count)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed writing maildirsize: %v", err)
+	}
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("failed writing maildirsize: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,574 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/spf13/viper"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	IndexFile      = "dovecot.index"
+	IndexLogFile   = "dovecot.index.log"
+	IndexCacheFile = "dovecot.index.cache"
+)
+
+// dovecot.index header values
+const (
+	indexMajorVersion    = 7
+	indexBaseHeaderSize  = 120
+	indexCompatLittleEnd = 0x01
+)
+
+// system flag bits of dovecot index records
+const (
+	indexFlagAnswered = 0x01
+	indexFlagFlagged  = 0x02
+	indexFlagDeleted  = 0x04
+	indexFlagSeen     = 0x08
+	indexFlagDraft    = 0x10
+)
+
+// index flag bits and the maildir flag letters they correspond to
+var indexFlagLetters = []struct {
+	bit    uint8
+	letter rune
+}{
+	{indexFlagDraft, 'D'},
+	{indexFlagFlagged, 'F'},
+	{indexFlagAnswered, 'R'},
+	{indexFlagSeen, 'S'},
+	{indexFlagDeleted, 'T'},
+}
+
+// IndexFlags returns the sorted maildir flag letters of index flag bits
+func IndexFlags(bits uint8) string {
+	flags := ""
+	for _, mapping := range indexFlagLetters {
+		if bits&mapping.bit != 0 {
+			flags += string(mapping.letter)
+		}
+	}
+	return flags
+}
+
+// maildirIndexFlags returns the index flag bits of maildir flag letters
+func maildirIndexFlags(flags string) uint8 {
+	var bits uint8
+	for _, mapping := range indexFlagLetters {
+		for _, letter := range flags {
+			if letter == mapping.letter {
+				bits |= mapping.bit
+			}
+		}
+	}
+	return bits
+}
+
+// IndexHeader is the base header of a dovecot.index file
+type IndexHeader struct {
+	MajorVersion      uint8  `json:"major_version"`
+	MinorVersion      uint8  `json:"minor_version"`
+	BaseHeaderSize    uint16 `json:"base_header_size"`
+	HeaderSize        uint32 `json:"header_size"`
+	RecordSize        uint32 `json:"record_size"`
+	CompatFlags       uint8  `json:"compat_flags"`
+	IndexId           uint32 `json:"indexid"`
+	Flags             uint32 `json:"flags"`
+	UidValidity       uint32 `json:"uid_validity"`
+	NextUid           uint32 `json:"next_uid"`
+	MessagesCount     uint32 `json:"messages_count"`
+	SeenCount         uint32 `json:"seen_messages_count"`
+	DeletedCount      uint32 `json:"deleted_messages_count"`
+	FirstRecentUid    uint32 `json:"first_recent_uid"`
+	LogFileSeq        uint32 `json:"log_file_seq"`
+	LogFileTailOffset uint32 `json:"log_file_tail_offset"`
+	LogFileHeadOffset uint32 `json:"log_file_head_offset"`
+}
+
+// IndexExtension describes an extension registered in the index header
+type IndexExtension struct {
+	Name         string `json:"name"`
+	ResetId      uint32 `json:"reset_id"`
+	HeaderSize   uint32 `json:"header_size"`
+	RecordOffset uint16 `json:"record_offset"`
+	RecordSize   uint16 `json:"record_size"`
+	header       []byte
+}
+
+// IndexRecord is the indexed state of a message
+type IndexRecord struct {
+	Uid         uint32   `json:"uid"`
+	Flags       uint8    `json:"-"`
+	Keywords    []string `json:"keywords"`
+	Modseq      uint64   `json:"modseq,omitempty"`
+	CacheOffset uint32   `json:"-"`
+	// Ext holds the record data of each extension by name
+	Ext map[string][]byte `json:"-"`
+}
+
+// MailIndex holds the contents of a dovecot.index file with the changes of
+// the transaction log applied
+type MailIndex struct {
+	Header     IndexHeader      `json:"header"`
+	Extensions []IndexExtension `json:"extensions"`
+	Records    []IndexRecord    `json:"records"`
+	Log        []*IndexLog      `json:"log"`
+}
+
+// align8 rounds up to the 64 bit alignment of index header extensions
+func align8(size int) int {
+	return (size + 7) &^ 7
+}
+
+// ReadMailIndex reads the dovecot.index and dovecot.index.log files of a
+// maildir; either may be missing, and an error satisfying os.IsNotExist is
+// returned only if both are
+func ReadMailIndex(dir string) (*MailIndex, error) {
+	index := MailIndex{Extensions: []IndexExtension{}, Records: []IndexRecord{}, Log: []*IndexLog{}}
+	data, err := os.ReadFile(filepath.Join(dir, IndexFile))
+	indexFound := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed reading index: %v", err)
+	}
+	if indexFound {
+		err = index.parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Join(dir, IndexFile), err)
+		}
+	}
+	logs, err := ReadIndexLogs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !indexFound && len(logs) == 0 {
+		return nil, &os.PathError{Op: "open", Path: filepath.Join(dir, IndexFile), Err: os.ErrNotExist}
+	}
+	for _, logFile := range logs {
+		err = index.apply(logFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	index.Log = logs
+	sort.Slice(index.Records, func(i, j int) bool { return index.Records[i].Uid < index.Records[j].Uid })
+	return &index, nil
+}
+
+func (m *MailIndex) parse(data []byte) error {
+	if len(data) < indexBaseHeaderSize {
+		return fmt.Errorf("index file truncated")
+	}
+	le := binary.LittleEndian
+	h := &m.Header
+	h.MajorVersion = data[0]
+	h.MinorVersion = data[1]
+	h.BaseHeaderSize = le.Uint16(data[2:])
+	h.HeaderSize = le.Uint32(data[4:])
+	h.RecordSize = le.Uint32(data[8:])
+	h.CompatFlags = data[12]
+	if h.MajorVersion != indexMajorVersion {
+		return fmt.Errorf("unsupported index version: %d.%d", h.MajorVersion, h.MinorVersion)
+	}
+	if h.CompatFlags&indexCompatLittleEnd == 0 {
+		return fmt.Errorf("index is not little-endian")
+	}
+	h.IndexId = le.Uint32(data[16:])
+	h.Flags = le.Uint32(data[20:])
+	h.UidValidity = le.Uint32(data[24:])
+	h.NextUid = le.Uint32(data[28:])
+	h.MessagesCount = le.Uint32(data[32:])
+	h.SeenCount = le.Uint32(data[40:])
+	h.DeletedCount = le.Uint32(data[44:])
+	h.FirstRecentUid = le.Uint32(data[48:])
+	h.LogFileSeq = le.Uint32(data[60:])
+	h.LogFileTailOffset = le.Uint32(data[64:])
+	h.LogFileHeadOffset = le.Uint32(data[68:])
+	if int(h.BaseHeaderSize) < indexBaseHeaderSize || h.HeaderSize < uint32(h.BaseHeaderSize) || int(h.HeaderSize) > len(data) {
+		return fmt.Errorf("invalid index header size")
+	}
+	if h.RecordSize < 8 {
+		return fmt.Errorf("invalid index record size: %d", h.RecordSize)
+	}
+
+	offset := int(h.BaseHeaderSize)
+	for offset+16 <= int(h.HeaderSize) {
+		ext := IndexExtension{
+			HeaderSize:   le.Uint32(data[offset:]),
+			ResetId:      le.Uint32(data[offset+4:]),
+			RecordOffset: le.Uint16(data[offset+8:]),
+			RecordSize:   le.Uint16(data[offset+10:]),
+		}
+		nameSize := int(le.Uint16(data[offset+14:]))
+		headerOffset := offset + align8(16+nameSize)
+		next := headerOffset + align8(int(ext.HeaderSize))
+		if next > int(h.HeaderSize) {
+			return fmt.Errorf("index extension header overflows header")
+		}
+		ext.Name = string(data[offset+16 : offset+16+nameSize])
+		ext.header = data[headerOffset : headerOffset+int(ext.HeaderSize)]
+		if int(ext.RecordOffset)+int(ext.RecordSize) > int(h.RecordSize) {
+			return fmt.Errorf("index extension %s overflows record", ext.Name)
+		}
+		m.Extensions = append(m.Extensions, ext)
+		offset = next
+	}
+
+	keywordNames, err := m.keywordNames()
+	if err != nil {
+		return err
+	}
+	end := int(h.HeaderSize) + int(h.MessagesCount)*int(h.RecordSize)
+	if end > len(data) {
+		return fmt.Errorf("index records truncated")
+	}
+	for offset := int(h.HeaderSize); offset < end; offset += int(h.RecordSize) {
+		record := data[offset : offset+int(h.RecordSize)]
+		m.Records = append(m.Records, m.parseRecord(record, keywordNames))
+	}
+	return nil
+}
+
+func (m *MailIndex) parseRecord(data []byte, keywordNames []string) IndexRecord {
+	le := binary.LittleEndian
+	record := IndexRecord{Uid: le.Uint32(data), Flags: data[4], Keywords: []string{}, Ext: map[string][]byte{}}
+	for _, ext := range m.Extensions {
+		if ext.RecordSize == 0 {
+			continue
+		}
+		field := data[ext.RecordOffset : ext.RecordOffset+ext.RecordSize]
+		if ext.Name == "keywords" {
+			for i, name := range keywordNames {
+				if i/8 < len(field) && field[i/8]&(1<<(i%8)) != 0 {
+					record.Keywords = append(record.Keywords, name)
+				}
+			}
+			continue
+		}
+		record.setExt(ext.Name, field)
+	}
+	return record
+}
+
+// setExt stores the extension data of a record, decoding the modseq and
+// cache offset extensions
+func (r *IndexRecord) setExt(name string, data []byte) {
+	le := binary.LittleEndian
+	r.Ext[name] = append([]byte{}, data...)
+	switch name {
+	case "modseq":
+		if len(data) >= 8 {
+			r.Modseq = le.Uint64(data)
+		}
+	case "cache":
+		if len(data) >= 4 {
+			r.CacheOffset = le.Uint32(data)
+		}
+	}
+}
+
+// Extension returns the named extension
+func (m *MailIndex) Extension(name string) (*IndexExtension, bool) {
+	for i := range m.Extensions {
+		if m.Extensions[i].Name == name {
+			return &m.Extensions[i], true
+		}
+	}
+	return nil, false
+}
+
+// keywordNames returns the keyword names of the keywords extension header, in
+// the order of their record bits
+func (m *MailIndex) keywordNames() ([]string, error) {
+	ext, ok := m.Extension("keywords")
+	if !ok {
+		return []string{}, nil
+	}
+	le := binary.LittleEndian
+	data := ext.header
+	if len(data) < 4 {
+		return nil, fmt.Errorf("invalid keywords header")
+	}
+	count := int(le.Uint32(data))
+	namesStart := 4 + count*8
+	if namesStart > len(data) {
+		return nil, fmt.Errorf("invalid keywords header")
+	}
+	names := []string{}
+	for i := 0; i < count; i++ {
+		start := namesStart + int(le.Uint32(data[4+i*8+4:]))
+		end := start
+		for end < len(data) && data[end] != 0 {
+			end += 1
+		}
+		if start > len(data) || end == len(data) {
+			return nil, fmt.Errorf("invalid keyword name offset")
+		}
+		names = append(names, string(data[start:end]))
+	}
+	return names, nil
+}
+
+// Lookup returns the record of a uid
+func (m *MailIndex) Lookup(uid uint32) (*IndexRecord, bool) {
+	i := sort.Search(len(m.Records), func(i int) bool { return m.Records[i].Uid >= uid })
+	if i < len(m.Records) && m.Records[i].Uid == uid {
+		return &m.Records[i], true
+	}
+	return nil, false
+}
+
+// WriteMailIndex writes a dovecot.index holding the flags and keywords of
+// records, which must be in uid order, for a mailbox without a transaction
+// log or cache; dovecot creates those when it opens the mailbox
+func WriteMailIndex(dir string, uidValidity, nextUid uint32, records []IndexRecord) error {
+	le := binary.LittleEndian
+	names := []string{}
+	bits := map[string]int{}
+	for _, record := range records {
+		for _, name := range record.Keywords {
+			_, ok := bits[name]
+			if !ok {
+				bits[name] = len(names)
+				names = append(names, name)
+			}
+		}
+	}
+	recordSize := 8
+	extensions := []byte{}
+	keywordsSize := (len(names) + 7) / 8
+	if len(names) > 0 {
+		header := make([]byte, 4+len(names)*8)
+		le.PutUint32(header, uint32(len(names)))
+		nameOffset := 0
+		for i, name := range names {
+			le.PutUint32(header[4+i*8+4:], uint32(nameOffset))
+			header = append(header, name...)
+			header = append(header, 0)
+			nameOffset += len(name) + 1
+		}
+		ext := make([]byte, align8(16+len("keywords"))+align8(len(header)))
+		le.PutUint32(ext, uint32(len(header)))
+		le.PutUint16(ext[8:], uint16(recordSize))
+		le.PutUint16(ext[10:], uint16(keywordsSize))
+		le.PutUint16(ext[12:], 1)
+		le.PutUint16(ext[14:], uint16(len("keywords")))
+		copy(ext[16:], "keywords")
+		copy(ext[align8(16+len("keywords")):], header)
+		extensions = append(extensions, ext...)
+		recordSize = (recordSize + keywordsSize + 3) &^ 3
+	}
+
+	data := make([]byte, indexBaseHeaderSize)
+	data[0] = indexMajorVersion
+	data[1] = 3
+	le.PutUint16(data[2:], indexBaseHeaderSize)
+	le.PutUint32(data[4:], uint32(indexBaseHeaderSize+len(extensions)))
+	le.PutUint32(data[8:], uint32(recordSize))
+	data[12] = indexCompatLittleEnd
+	le.PutUint32(data[16:], uint32(time.Now().Unix()))
+	le.PutUint32(data[24:], uidValidity)
+	le.PutUint32(data[28:], nextUid)
+	le.PutUint32(data[32:], uint32(len(records)))
+	// no message is recent
+	le.PutUint32(data[48:], nextUid)
+	le.PutUint32(data[60:], 1)
+	data = append(data, extensions...)
+	var seen, deleted uint32
+	for _, record := range records {
+		rec := make([]byte, recordSize)
+		le.PutUint32(rec, record.Uid)
+		rec[4] = record.Flags
+		for _, name := range record.Keywords {
+			bit := bits[name]
+			rec[8+bit/8] |= 1 << (bit % 8)
+		}
+		if record.Flags&indexFlagSeen != 0 {
+			seen += 1
+		}
+		if record.Flags&indexFlagDeleted != 0 {
+			deleted += 1
+		}
+		data = append(data, rec...)
+	}
+	le.PutUint32(data[40:], seen)
+	le.PutUint32(data[44:], deleted)
+	pathName := filepath.Join(dir, IndexFile)
+	tmpPath := fmt.Sprintf("%s.%d", pathName, os.Getpid())
+	err := os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed writing index: %v", err)
+	}
+	err = os.Rename(tmpPath, pathName)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed replacing index: %v", err)
+	}
+	return nil
+}
+
+// apply replays the records of a transaction log file which follow the
+// position the index was written at
+func (m *MailIndex) apply(logFile *IndexLog) error {
+	start := uint32(0)
+	if m.Header.LogFileSeq != 0 {
+		if logFile.Header.FileSeq < m.Header.LogFileSeq {
+			return nil
+		}
+		if logFile.Header.FileSeq == m.Header.LogFileSeq {
+			start = m.Header.LogFileHeadOffset
+		}
+	}
+	extName := ""
+	for _, record := range logFile.Records {
+		if record.Type&logTypeMask == logExtIntro {
+			// extension records refer to the most recently introduced extension
+			extName = record.ExtName
+			if extName == "" && int(record.ExtId) < len(m.Extensions) {
+				extName = m.Extensions[record.ExtId].Name
+			}
+			_, ok := m.Extension(extName)
+			if !ok && extName != "" {
+				m.Extensions = append(m.Extensions, IndexExtension{Name: extName, RecordSize: record.ExtRecordSize})
+			}
+		}
+		if record.Offset < start {
+			continue
+		}
+		switch record.Type & logTypeMask {
+		case logExtRecordUpdate:
+			for _, update := range record.ExtRecords {
+				rec, ok := m.Lookup(update.Uid)
+				if ok && extName != "" && extName != "keywords" {
+					rec.setExt(extName, update.Data)
+				}
+			}
+		case logAppend:
+			for _, appended := range record.Appends {
+				m.Records = append(m.Records, IndexRecord{Uid: appended.Uid, Flags: appended.Flags, Keywords: []string{}, Ext: map[string][]byte{}})
+				if appended.Uid >= m.Header.NextUid {
+					m.Header.NextUid = appended.Uid + 1
+				}
+			}
+		case logExpunge, logExpungeGuid, logExpunge | logExpungeProt, logExpungeGuid | logExpungeProt:
+			records := []IndexRecord{}
+			for _, rec := range m.Records {
+				if !record.containsUid(rec.Uid) {
+					records = append(records, rec)
+				}
+			}
+			m.Records = records
+		case logFlagUpdate:
+			for _, update := range record.FlagUpdates {
+				for i := range m.Records {
+					uid := m.Records[i].Uid
+					if uid >= update.Uid1 && uid <= update.Uid2 {
+						m.Records[i].Flags = (m.Records[i].Flags | update.Add) &^ update.Remove
+					}
+				}
+			}
+		case logKeywordUpdate:
+			for i := range m.Records {
+				if record.containsUid(m.Records[i].Uid) {
+					m.Records[i].Keywords = updateKeywords(m.Records[i].Keywords, record.Keyword, record.Modify)
+				}
+			}
+		case logKeywordReset:
+			for i := range m.Records {
+				if record.containsUid(m.Records[i].Uid) {
+					m.Records[i].Keywords = []string{}
+				}
+			}
+		}
+	}
+	m.Header.LogFileSeq = logFile.Header.FileSeq
+	m.Header.LogFileHeadOffset = logFile.Size
+	m.Header.MessagesCount = uint32(len(m.Records))
+	if m.Header.IndexId == 0 {
+		m.Header.IndexId = logFile.Header.IndexId
+	}
+	return nil
+}
+
+func updateKeywords(keywords []string, name string, modify uint8) []string {
+	updated := []string{}
+	for _, keyword := range keywords {
+		if keyword != name {
+			updated = append(updated, keyword)
+		}
+	}
+	if modify == logModifyAdd {
+		updated = append(updated, name)
+	}
+	return updated
+}
+
+// index reset modes of the --reset-index flag
+const (
+	IndexResetCache = "cache"
+	IndexResetAll   = "all"
+)
+
+// index files removed by each reset mode; dovecot rebuilds missing index
+// files from the maildir and its dovecot-uidlist on the next access
+var indexResetFiles map[string][]string = map[string][]string{
+	IndexResetCache: {IndexCacheFile},
+	IndexResetAll:   {IndexFile, IndexLogFile, IndexLogFile + ".2", IndexCacheFile, "dovecot.index.thread"},
+}
+
+// ValidateIndexReset returns an error for an unknown reset mode; an empty mode is valid
+func ValidateIndexReset(mode string) error {
+	_, ok := indexResetFiles[mode]
+	if mode != "" && !ok {
+		return fmt.Errorf("unknown index reset mode: %s", mode)
+	}
+	return nil
+}
+
+// ResetIndex removes the index files of a maildir selected by mode and
+// returns the pathnames removed
+func ResetIndex(dir, mode string) ([]string, error) {
+	err := ValidateIndexReset(mode)
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, name := range indexResetFiles[mode] {
+		pathName := filepath.Join(dir, name)
+		err := os.Remove(pathName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, fmt.Errorf("failed removing index file: %v", err)
+		}
+		removed = append(removed, pathName)
+	}
+	return removed, nil
+}
+
+// ResetIndexes applies the --reset-index mode to the maildirs changed by a command
+func ResetIndexes(dirs []string) error {
+	mode := viper.GetString("reset-index")
+	if mode == "" || viper.GetBool("dry-run") {
+		return nil
+	}
+	verbose := viper.GetBool("verbose")
+	for _, dir := range dirs {
+		removed, err := ResetIndex(dir, mode)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			for _, pathName := range removed {
+				log.Printf("removed %s\n", pathName)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// dovecot.index.cache values
+const (
+	indexCacheMajorVersion = 1
+	indexCacheHeaderSize   = 32
+	cacheVariableSize      = 0xffffffff
+)
+
+// cache field names used to check message sizes
+const (
+	CacheFieldPhysicalSize = "size.physical"
+	CacheFieldVirtualSize  = "size.virtual"
+)
+
+// IndexCacheHeader is the header of a dovecot.index.cache file
+type IndexCacheHeader struct {
+	MajorVersion uint8  `json:"major_version"`
+	MinorVersion uint8  `json:"minor_version"`
+	IndexId      uint32 `json:"indexid"`
+	FileSeq      uint32 `json:"file_seq"`
+	RecordCount  uint32 `json:"record_count"`
+}
+
+// IndexCacheField is a field registered in the cache file
+type IndexCacheField struct {
+	Name string `json:"name"`
+	Size uint32 `json:"size"`
+	Type uint8  `json:"type"`
+}
+
+// IndexCache is a parsed dovecot.index.cache file
+type IndexCache struct {
+	Header IndexCacheHeader  `json:"header"`
+	Fields []IndexCacheField `json:"fields"`
+	data   []byte
+}
+
+// ReadIndexCache reads the dovecot.index.cache file of a maildir
+func ReadIndexCache(dir string) (*IndexCache, error) {
+	pathName := filepath.Join(dir, IndexCacheFile)
+	data, err := os.ReadFile(pathName)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := ParseIndexCache(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pathName, err)
+	}
+	return cache, nil
+}
+
+// ParseIndexCache parses the contents of a dovecot.index.cache file
+func ParseIndexCache(data []byte) (*IndexCache, error) {
+	if len(data) < indexCacheHeaderSize {
+		return nil, fmt.Errorf("index cache truncated")
+	}
+	le := binary.LittleEndian
+	cache := IndexCache{Fields: []IndexCacheField{}, data: data}
+	h := &cache.Header
+	h.MajorVersion = data[0]
+	h.MinorVersion = data[2]
+	if h.MajorVersion != indexCacheMajorVersion {
+		return nil, fmt.Errorf("unsupported index cache version: %d.%d", h.MajorVersion, h.MinorVersion)
+	}
+	if data[1] != 8 {
+		return nil, fmt.Errorf("unsupported index cache offset size: %d", data[1])
+	}
+	h.IndexId = le.Uint32(data[4:])
+	h.FileSeq = le.Uint32(data[8:])
+	h.RecordCount = le.Uint32(data[16:])
+
+	// the newest field header, at the end of the chain, lists every field
+	offset := offsetToUint32(data[28:])
+	for offset != 0 {
+		if int(offset)+12 > len(data) {
+			return nil, fmt.Errorf("invalid field header offset: %d", offset)
+		}
+		next := offsetToUint32(data[offset:])
+		if next == 0 {
+			break
+		}
+		if next <= offset {
+			return nil, fmt.Errorf("field header chain loops at offset %d", offset)
+		}
+		offset = next
+	}
+	if offset != 0 {
+		err := cache.parseFields(data, int(offset))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cache, nil
+}
+
+func (c *IndexCache) parseFields(data []byte, offset int) error {
+	le := binary.LittleEndian
+	size := int(le.Uint32(data[offset+4:]))
+	count := int(le.Uint32(data[offset+8:]))
+	if offset+size > len(data) || 12+count*10 > size {
+		return fmt.Errorf("invalid field header at offset %d", offset)
+	}
+	block := data[offset : offset+size]
+	sizes := 12 + count*4
+	types := sizes + count*4
+	names := bytes.Split(block[types+count*2:], []byte{0})
+	if len(names) < count {
+		return fmt.Errorf("missing field names at offset %d", offset)
+	}
+	for i := 0; i < count; i++ {
+		c.Fields = append(c.Fields, IndexCacheField{
+			Name: string(names[i]),
+			Size: le.Uint32(block[sizes+i*4:]),
+			Type: block[types+i],
+		})
+	}
+	return nil
+}
+
+// Lookup returns the cached fields of the record chain at offset; the newest
+// value of each field is returned
+func (c *IndexCache) Lookup(offset uint32) (map[string][]byte, error) {
+	le := binary.LittleEndian
+	fields := map[string][]byte{}
+	visited := map[uint32]bool{}
+	for offset != 0 {
+		if visited[offset] || int(offset)+8 > len(c.data) {
+			return nil, fmt.Errorf("invalid cache record offset: %d", offset)
+		}
+		visited[offset] = true
+		prev := le.Uint32(c.data[offset:])
+		size := le.Uint32(c.data[offset+4:])
+		if size < 8 || int(offset)+int(size) > len(c.data) {
+			return nil, fmt.Errorf("invalid cache record size at offset %d", offset)
+		}
+		record := c.data[offset+8 : offset+size]
+		for pos := 0; pos+4 <= len(record); {
+			index := int(le.Uint32(record[pos:]))
+			pos += 4
+			if index >= len(c.Fields) {
+				return nil, fmt.Errorf("unknown cache field %d at offset %d", index, offset)
+			}
+			dataSize := c.Fields[index].Size
+			if dataSize == cacheVariableSize {
+				if pos+4 > len(record) {
+					return nil, fmt.Errorf("cache record truncated at offset %d", offset)
+				}
+				dataSize = le.Uint32(record[pos:])
+				pos += 4
+			}
+			if pos+int(dataSize) > len(record) {
+				return nil, fmt.Errorf("cache record truncated at offset %d", offset)
+			}
+			name := c.Fields[index].Name
+			// records are chained newest first
+			_, seen := fields[name]
+			if !seen {
+				fields[name] = record[pos : pos+int(dataSize)]
+			}
+			pos += (int(dataSize) + 3) &^ 3
+		}
+		offset = prev
+	}
+	return fields, nil
+}
+
+// CachedSize returns a cached size field of a record, if present
+func (c *IndexCache) CachedSize(offset uint32, field string) (int64, bool, error) {
+	fields, err := c.Lookup(offset)
+	if err != nil {
+		return 0, false, err
+	}
+	value, ok := fields[field]
+	if !ok || len(value) != 8 {
+		return 0, false, nil
+	}
+	return int64(binary.LittleEndian.Uint64(value)), true, nil
+}
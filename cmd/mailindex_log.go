@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dovecot.index.log values
+const (
+	indexLogMajorVersion = 1
+	indexLogHeaderSize   = 40
+)
+
+// transaction record types
+const (
+	logExpunge          = 0x00000001
+	logAppend           = 0x00000002
+	logFlagUpdate       = 0x00000004
+	logHeaderUpdate     = 0x00000020
+	logExtIntro         = 0x00000040
+	logExtReset         = 0x00000080
+	logExtHeaderUpdate  = 0x00000100
+	logExtRecordUpdate  = 0x00000200
+	logKeywordUpdate    = 0x00000400
+	logKeywordReset     = 0x00000800
+	logExtAtomicInc     = 0x00001000
+	logExpungeGuid      = 0x00002000
+	logModseqUpdate     = 0x00008000
+	logExtHeaderUpdate2 = 0x00010000
+	logIndexDeleted     = 0x00020000
+	logIndexUndeleted   = 0x00040000
+	logBoundary         = 0x00080000
+	logAttributeUpdate  = 0x00100000
+	logExpungeProt      = 0x0000cd90
+	logTypeMask         = 0x0fffffff
+	logExternal         = 0x10000000
+	logSync             = 0x20000000
+)
+
+// keyword update modify types
+const (
+	logModifyAdd    = 0
+	logModifyRemove = 1
+)
+
+var logTypeNames map[uint32]string = map[uint32]string{
+	logExpunge:          "expunge",
+	logAppend:           "append",
+	logFlagUpdate:       "flag-update",
+	logHeaderUpdate:     "header-update",
+	logExtIntro:         "ext-intro",
+	logExtReset:         "ext-reset",
+	logExtHeaderUpdate:  "ext-hdr-update",
+	logExtRecordUpdate:  "ext-rec-update",
+	logKeywordUpdate:    "keyword-update",
+	logKeywordReset:     "keyword-reset",
+	logExtAtomicInc:     "ext-atomic-inc",
+	logExpungeGuid:      "expunge-guid",
+	logModseqUpdate:     "modseq-update",
+	logExtHeaderUpdate2: "ext-hdr-update32",
+	logIndexDeleted:     "index-deleted",
+	logIndexUndeleted:   "index-undeleted",
+	logBoundary:         "boundary",
+	logAttributeUpdate:  "attribute-update",
+}
+
+// IndexLogHeader is the header of a dovecot.index.log file
+type IndexLogHeader struct {
+	MajorVersion   uint8  `json:"major_version"`
+	MinorVersion   uint8  `json:"minor_version"`
+	HeaderSize     uint16 `json:"hdr_size"`
+	IndexId        uint32 `json:"indexid"`
+	FileSeq        uint32 `json:"file_seq"`
+	PrevFileSeq    uint32 `json:"prev_file_seq"`
+	PrevFileOffset uint32 `json:"prev_file_offset"`
+	CreateStamp    uint32 `json:"create_stamp"`
+	InitialModseq  uint64 `json:"initial_modseq"`
+}
+
+// IndexLog is a parsed dovecot.index.log file
+type IndexLog struct {
+	File    string           `json:"file"`
+	Header  IndexLogHeader   `json:"header"`
+	Records []IndexLogRecord `json:"records"`
+	Size    uint32           `json:"size"`
+}
+
+// IndexLogRecord is a transaction log record; only the record types which
+// change message state are decoded
+type IndexLogRecord struct {
+	Offset        uint32              `json:"offset"`
+	Type          uint32              `json:"-"`
+	TypeName      string              `json:"type"`
+	Size          uint32              `json:"size"`
+	Appends       []IndexLogAppend    `json:"appends,omitempty"`
+	UidRanges     [][2]uint32         `json:"uids,omitempty"`
+	FlagUpdates   []IndexLogFlags     `json:"flag_updates,omitempty"`
+	Keyword       string              `json:"keyword,omitempty"`
+	Modify        uint8               `json:"modify,omitempty"`
+	ExtId         uint32              `json:"ext_id,omitempty"`
+	ExtName       string              `json:"ext_name,omitempty"`
+	ExtRecords    []IndexLogExtRecord `json:"ext_records,omitempty"`
+	ExtRecordSize uint16              `json:"-"`
+}
+
+// IndexLogExtRecord is an update of the extension data of a message
+type IndexLogExtRecord struct {
+	Uid  uint32 `json:"uid"`
+	Data []byte `json:"data"`
+}
+
+// IndexLogAppend is a message appended by a transaction
+type IndexLogAppend struct {
+	Uid   uint32 `json:"uid"`
+	Flags uint8  `json:"flags"`
+}
+
+// IndexLogFlags is a flag change of a uid range
+type IndexLogFlags struct {
+	Uid1   uint32 `json:"uid1"`
+	Uid2   uint32 `json:"uid2"`
+	Add    uint8  `json:"add"`
+	Remove uint8  `json:"remove"`
+}
+
+func (r *IndexLogRecord) containsUid(uid uint32) bool {
+	for _, uidRange := range r.UidRanges {
+		if uid >= uidRange[0] && uid <= uidRange[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// offsetToUint32 decodes dovecot's 4-byte offset encoding, where the high bit
+// of each byte is set and the value is stored in 7 bit groups of 4 byte units
+func offsetToUint32(data []byte) uint32 {
+	if data[0]&data[1]&data[2]&data[3]&0x80 == 0 {
+		return 0
+	}
+	return uint32(data[3]&0x7f)<<2 | uint32(data[2]&0x7f)<<9 | uint32(data[1]&0x7f)<<16 | uint32(data[0]&0x7f)<<23
+}
+
+// uint32ToOffset encodes a multiple of 4 in dovecot's offset encoding
+func uint32ToOffset(value uint32) []byte {
+	value >>= 2
+	return []byte{
+		0x80 | byte(value>>21)&0x7f,
+		0x80 | byte(value>>14)&0x7f,
+		0x80 | byte(value>>7)&0x7f,
+		0x80 | byte(value)&0x7f,
+	}
+}
+
+// ReadIndexLogs reads the rotated and current transaction logs of a maildir,
+// oldest first
+func ReadIndexLogs(dir string) ([]*IndexLog, error) {
+	logs := []*IndexLog{}
+	for _, name := range []string{IndexLogFile + ".2", IndexLogFile} {
+		pathName := filepath.Join(dir, name)
+		data, err := os.ReadFile(pathName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed reading index log: %v", err)
+		}
+		log, err := ParseIndexLog(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pathName, err)
+		}
+		log.File = name
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// ParseIndexLog parses the contents of a dovecot.index.log file
+func ParseIndexLog(data []byte) (*IndexLog, error) {
+	if len(data) < indexLogHeaderSize {
+		return nil, fmt.Errorf("index log truncated")
+	}
+	le := binary.LittleEndian
+	log := IndexLog{Records: []IndexLogRecord{}}
+	h := &log.Header
+	h.MajorVersion = data[0]
+	h.MinorVersion = data[1]
+	h.HeaderSize = le.Uint16(data[2:])
+	h.IndexId = le.Uint32(data[4:])
+	h.FileSeq = le.Uint32(data[8:])
+	h.PrevFileSeq = le.Uint32(data[12:])
+	h.PrevFileOffset = le.Uint32(data[16:])
+	h.CreateStamp = le.Uint32(data[20:])
+	h.InitialModseq = le.Uint64(data[24:])
+	if h.MajorVersion != indexLogMajorVersion {
+		return nil, fmt.Errorf("unsupported index log version: %d.%d", h.MajorVersion, h.MinorVersion)
+	}
+	if data[32]&indexCompatLittleEnd == 0 {
+		return nil, fmt.Errorf("index log is not little-endian")
+	}
+	if int(h.HeaderSize) < indexLogHeaderSize || int(h.HeaderSize) > len(data) {
+		return nil, fmt.Errorf("invalid index log header size")
+	}
+	offset := int(h.HeaderSize)
+	// extension record updates are sized by the preceding extension intro
+	var extRecordSize uint16
+	for offset+8 <= len(data) {
+		size := offsetToUint32(data[offset:])
+		if size == 0 {
+			// an unfinished or preallocated tail
+			break
+		}
+		if size < 8 || offset+int(size) > len(data) {
+			return nil, fmt.Errorf("invalid transaction size at offset %d", offset)
+		}
+		record, err := parseIndexLogRecord(data[offset:offset+int(size)], uint32(offset), extRecordSize)
+		if err != nil {
+			return nil, fmt.Errorf("transaction at offset %d: %v", offset, err)
+		}
+		if record.Type&logTypeMask == logExtIntro {
+			extRecordSize = record.ExtRecordSize
+		}
+		log.Records = append(log.Records, *record)
+		offset += int(size)
+	}
+	log.Size = uint32(offset)
+	return &log, nil
+}
+
+func parseIndexLogRecord(data []byte, offset uint32, extRecordSize uint16) (*IndexLogRecord, error) {
+	le := binary.LittleEndian
+	record := IndexLogRecord{Offset: offset, Size: uint32(len(data)), Type: le.Uint32(data[4:])}
+	record.TypeName = logTypeName(record.Type)
+	body := data[8:]
+	switch record.Type & logTypeMask {
+	case logAppend:
+		for i := 0; i+8 <= len(body); i += 8 {
+			record.Appends = append(record.Appends, IndexLogAppend{Uid: le.Uint32(body[i:]), Flags: body[i+4]})
+		}
+	case logExpunge, logExpunge | logExpungeProt, logKeywordReset:
+		record.UidRanges = parseUidRanges(body, 8)
+	case logExpungeGuid, logExpungeGuid | logExpungeProt:
+		// uid followed by a 16 byte guid
+		record.UidRanges = parseUidRanges(body, 20)
+	case logFlagUpdate:
+		for i := 0; i+12 <= len(body); i += 12 {
+			record.FlagUpdates = append(record.FlagUpdates, IndexLogFlags{
+				Uid1:   le.Uint32(body[i:]),
+				Uid2:   le.Uint32(body[i+4:]),
+				Add:    body[i+8],
+				Remove: body[i+9],
+			})
+		}
+	case logExtIntro:
+		if len(body) < 20 {
+			return nil, fmt.Errorf("extension intro truncated")
+		}
+		record.ExtId = le.Uint32(body)
+		record.ExtRecordSize = le.Uint16(body[12:])
+		nameSize := int(le.Uint16(body[18:]))
+		if 20+nameSize > len(body) {
+			return nil, fmt.Errorf("extension intro truncated")
+		}
+		record.ExtName = string(body[20 : 20+nameSize])
+	case logExtRecordUpdate:
+		size := (4 + int(extRecordSize) + 3) &^ 3
+		for i := 0; i+4+int(extRecordSize) <= len(body); i += size {
+			record.ExtRecords = append(record.ExtRecords, IndexLogExtRecord{
+				Uid:  le.Uint32(body[i:]),
+				Data: body[i+4 : i+4+int(extRecordSize)],
+			})
+		}
+	case logKeywordUpdate:
+		if len(body) < 4 {
+			return nil, fmt.Errorf("keyword update truncated")
+		}
+		record.Modify = body[0]
+		nameSize := int(le.Uint16(body[2:]))
+		rangesOffset := (4 + nameSize + 3) &^ 3
+		if rangesOffset > len(body) {
+			return nil, fmt.Errorf("keyword update truncated")
+		}
+		record.Keyword = string(body[4 : 4+nameSize])
+		record.UidRanges = parseUidRanges(body[rangesOffset:], 8)
+	}
+	return &record, nil
+}
+
+// parseUidRanges returns the uid1, uid2 pairs of fixed size entries; entries
+// holding a single uid yield a range of that uid
+func parseUidRanges(data []byte, size int) [][2]uint32 {
+	le := binary.LittleEndian
+	ranges := [][2]uint32{}
+	for i := 0; i+size <= len(data); i += size {
+		uid1 := le.Uint32(data[i:])
+		uid2 := uid1
+		if size == 8 {
+			uid2 = le.Uint32(data[i+4:])
+		}
+		ranges = append(ranges, [2]uint32{uid1, uid2})
+	}
+	return ranges
+}
+
+func logTypeName(recordType uint32) string {
+	baseType := recordType & logTypeMask
+	if baseType&logExpungeProt == logExpungeProt {
+		// dovecot tags expunge records with protection bits
+		baseType &^= logExpungeProt
+	}
+	name, ok := logTypeNames[baseType]
+	if !ok {
+		name = fmt.Sprintf("0x%x", recordType&logTypeMask)
+	}
+	modifiers := []string{name}
+	if recordType&logExternal != 0 {
+		modifiers = append(modifiers, "external")
+	}
+	if recordType&logSync != 0 {
+		modifiers = append(modifiers, "sync")
+	}
+	return strings.Join(modifiers, ",")
+}
@@ -0,0 +1,365 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const mboxDateFormat = "Mon Jan _2 15:04:05 2006"
+
+// MboxWriter writes messages to an mboxrd file
+type MboxWriter struct {
+	writer *bufio.Writer
+}
+
+func NewMboxWriter(w io.Writer) *MboxWriter {
+	return &MboxWriter{writer: bufio.NewWriter(w)}
+}
+
+// WriteMessage appends a message with the Status and X-Status headers
+// corresponding to the maildir flags
+func (m *MboxWriter) WriteMessage(data []byte, received time.Time, flags string) error {
+	header, body := splitMessage(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")))
+	sender := envelopeSender(header)
+	_, err := fmt.Fprintf(m.writer, "From %s %s\n", sender, received.UTC().Format(mboxDateFormat))
+	if err != nil {
+		return fmt.Errorf("failed writing mbox: %v", err)
+	}
+	lines := [][]byte{}
+	for _, line := range bytes.SplitAfter(header, []byte("\n")) {
+		if len(line) == 0 || len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = removeHeaderFields(lines, "Status", "X-Status")
+	for _, line := range lines {
+		m.writer.Write(line)
+	}
+	status, xStatus := MboxStatus(flags)
+	fmt.Fprintf(m.writer, "Status: %s\n", status)
+	if xStatus != "" {
+		fmt.Fprintf(m.writer, "X-Status: %s\n", xStatus)
+	}
+	m.writer.WriteString("\n")
+	for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if isFromLine(bytes.TrimLeft(line, ">")) {
+			m.writer.WriteString(">")
+		}
+		m.writer.Write(line)
+	}
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		m.writer.WriteString("\n")
+	}
+	_, err = m.writer.WriteString("\n")
+	if err != nil {
+		return fmt.Errorf("failed writing mbox: %v", err)
+	}
+	return nil
+}
+
+func (m *MboxWriter) Flush() error {
+	err := m.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("failed writing mbox: %v", err)
+	}
+	return nil
+}
+
+// MboxStatus returns the Status and X-Status header values for maildir flags
+func MboxStatus(flags string) (string, string) {
+	status := ""
+	if strings.ContainsRune(flags, 'S') {
+		status += "R"
+	}
+	status += "O"
+	xStatus := ""
+	for _, mapping := range []struct {
+		flag   rune
+		status string
+	}{{'R', "A"}, {'F', "F"}, {'D', "T"}, {'T', "D"}} {
+		if strings.ContainsRune(flags, mapping.flag) {
+			xStatus += mapping.status
+		}
+	}
+	return status, xStatus
+}
+
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("From "))
+}
+
+// splitMessage returns the header including its final line break and the body
+func splitMessage(data []byte) ([]byte, []byte) {
+	if bytes.HasPrefix(data, []byte("\n")) {
+		return []byte{}, data[1:]
+	}
+	i := bytes.Index(data, []byte("\n\n"))
+	if i < 0 {
+		return data, []byte{}
+	}
+	return data[:i+1], data[i+2:]
+}
+
+// removeHeaderFields drops the named fields and their continuation lines
+func removeHeaderFields(lines [][]byte, names ...string) [][]byte {
+	kept := [][]byte{}
+	skipping := false
+	for _, line := range lines {
+		if line[0] == ' ' || line[0] == '\t' {
+			if !skipping {
+				kept = append(kept, line)
+			}
+			continue
+		}
+		skipping = false
+		name, _, _ := bytes.Cut(line, []byte(":"))
+		for _, remove := range names {
+			if strings.EqualFold(string(bytes.TrimSpace(name)), remove) {
+				skipping = true
+			}
+		}
+		if !skipping {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+func envelopeSender(header []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(append(header, '\n')))
+	if err == nil {
+		for _, key := range []string{"Return-Path", "From"} {
+			address, err := mail.ParseAddress(msg.Header.Get(key))
+			if err == nil && address.Address != "" {
+				return address.Address
+			}
+		}
+	}
+	return "MAILER-DAEMON"
+}
+
+// mbox variants accepted by MboxReader
+var mboxFormats []string = []string{"mboxo", "mboxrd", "mboxcl", "mboxcl2"}
+
+// MboxMessage is a message read from an mbox file
+type MboxMessage struct {
+	Sender   string
+	Received time.Time
+	Data     []byte
+}
+
+// Flags returns the maildir flags corresponding to the Status and X-Status headers
+func (m *MboxMessage) Flags() string {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return ""
+	}
+	flags := ""
+	if strings.Contains(msg.Header.Get("Status"), "R") {
+		flags += "S"
+	}
+	xStatus := msg.Header.Get("X-Status")
+	for _, mapping := range []struct {
+		status string
+		flag   string
+	}{{"A", "R"}, {"F", "F"}, {"T", "D"}, {"D", "T"}} {
+		if strings.Contains(xStatus, mapping.status) {
+			flags += mapping.flag
+		}
+	}
+	return SortFlags(flags)
+}
+
+// MboxReader splits an mbox file into messages
+type MboxReader struct {
+	reader   *bufio.Reader
+	format   string
+	fromLine string
+}
+
+func NewMboxReader(r io.Reader, format string) (*MboxReader, error) {
+	valid := false
+	for _, name := range mboxFormats {
+		if name == format {
+			valid = true
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("unknown mbox format: %s", format)
+	}
+	return &MboxReader{reader: bufio.NewReader(r), format: format}, nil
+}
+
+func (m *MboxReader) readLine() (string, error) {
+	line, err := m.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return line, nil
+	}
+	return line, err
+}
+
+// Next returns the next message, or io.EOF after the last message
+func (m *MboxReader) Next() (*MboxMessage, error) {
+	if m.fromLine == "" {
+		for {
+			line, err := m.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if !isFromLine([]byte(line)) {
+				return nil, fmt.Errorf("mbox does not begin with a From line")
+			}
+			m.fromLine = line
+			break
+		}
+	}
+	msg := parseFromLine(m.fromLine)
+	m.fromLine = ""
+
+	var buf bytes.Buffer
+	contentLength := int64(-1)
+	for {
+		line, err := m.readLine()
+		if err == io.EOF {
+			msg.Data = buf.Bytes()
+			return msg, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed reading mbox: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		line = strings.TrimSuffix(line, "\n") + "\n"
+		if line == "\n" {
+			buf.WriteString(line)
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(name, "Content-Length") && strings.HasPrefix(m.format, "mboxcl") {
+			length, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err == nil {
+				contentLength = length
+			}
+			continue
+		}
+		buf.WriteString(line)
+	}
+
+	if contentLength >= 0 {
+		body := make([]byte, contentLength)
+		_, err := io.ReadFull(m.reader, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading mbox message body: %v", err)
+		}
+		if m.format == "mboxcl" {
+			body = unescapeFromLines(body, false)
+		}
+		buf.Write(body)
+		for {
+			line, err := m.readLine()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed reading mbox: %v", err)
+			}
+			if isFromLine([]byte(line)) {
+				m.fromLine = line
+				break
+			}
+			if strings.TrimSpace(line) != "" {
+				return nil, fmt.Errorf("unexpected data following Content-Length body")
+			}
+		}
+		msg.Data = buf.Bytes()
+		return msg, nil
+	}
+
+	var body bytes.Buffer
+	for {
+		line, err := m.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed reading mbox: %v", err)
+		}
+		if isFromLine([]byte(line)) {
+			m.fromLine = line
+			break
+		}
+		body.WriteString(line)
+	}
+	data := bytes.TrimSuffix(body.Bytes(), []byte("\n"))
+	if !bytes.HasSuffix(data, []byte("\n")) && len(data) > 0 {
+		data = append(data, '\n')
+	}
+	buf.Write(unescapeFromLines(data, m.format == "mboxrd"))
+	msg.Data = buf.Bytes()
+	return msg, nil
+}
+
+// unescapeFromLines removes the '>' quoting of From lines; mboxrd removes one
+// level from any number of quotes, the other formats only from a single quote
+func unescapeFromLines(data []byte, rd bool) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		if !bytes.HasPrefix(line, []byte(">")) {
+			continue
+		}
+		if rd && isFromLine(bytes.TrimLeft(line, ">")) {
+			lines[i] = line[1:]
+		} else if isFromLine(line[1:]) {
+			lines[i] = line[1:]
+		}
+	}
+	return bytes.Join(lines, nil)
+}
+
+func parseFromLine(line string) *MboxMessage {
+	msg := MboxMessage{}
+	fields := strings.Fields(strings.TrimPrefix(line, "From "))
+	if len(fields) > 0 {
+		msg.Sender = fields[0]
+	}
+	if len(fields) > 1 {
+		dateText := strings.Join(fields[1:], " ")
+		for _, layout := range []string{time.ANSIC, "Mon Jan 2 15:04:05 2006", "Mon Jan 2 15:04:05 MST 2006", "Mon Jan 2 15:04:05 -0700 2006", time.RFC1123Z} {
+			date, err := time.Parse(layout, dateText)
+			if err == nil {
+				msg.Received = date
+				break
+			}
+		}
+	}
+	return &msg
+}
+
+// mbox bookkeeping header fields which are dropped on import
+var mboxHeaderFields []string = []string{"Status", "X-Status", "X-UID", "X-IMAP", "X-IMAPbase", "X-Keywords"}
+
+// StripHeaderFields removes the named fields from the header of message data
+func StripHeaderFields(data []byte, names ...string) []byte {
+	header, body := splitMessage(data)
+	lines := [][]byte{}
+	for _, line := range bytes.SplitAfter(header, []byte("\n")) {
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	lines = removeHeaderFields(lines, names...)
+	lines = append(lines, []byte("\n"), body)
+	return bytes.Join(lines, nil)
+}
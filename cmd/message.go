@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// standard maildir flag letters and their IMAP names
+var flagNames map[rune]string = map[rune]string{
+	'D': "draft",
+	'F': "flagged",
+	'P': "passed",
+	'R': "replied",
+	'S': "seen",
+	'T': "trashed",
+}
+
+// MessageFile holds the fields encoded in a maildir message filename
+type MessageFile struct {
+	Path  string
+	Name  string
+	Base  string
+	Size  int64
+	SizeW int64
+	Flags string
+}
+
+func ParseMessageFile(pathName string) (*MessageFile, error) {
+	filename := filepath.Base(pathName)
+	name, info, found := strings.Cut(filename, ":")
+	msg := MessageFile{Path: pathName, Name: name}
+	if found {
+		if !strings.HasPrefix(info, "2,") {
+			return nil, fmt.Errorf("unsupported info in filename: %s", pathName)
+		}
+		msg.Flags = info[2:]
+	}
+	parts := strings.Split(name, ",")
+	msg.Base = parts[0]
+	for _, part := range parts[1:] {
+		key, numStr, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		numVal, err := strconv.ParseInt(numStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "S":
+			msg.Size = numVal
+		case "W":
+			msg.SizeW = numVal
+		}
+	}
+	return &msg, nil
+}
+
+// HasFlag returns true if the flag letter is set on the message
+func (m *MessageFile) HasFlag(flag rune) bool {
+	return strings.ContainsRune(m.Flags, flag)
+}
+
+// FlagNames returns the IMAP names of the standard flags set on the message
+func (m *MessageFile) FlagNames() []string {
+	names := []string{}
+	for _, flag := range m.Flags {
+		name, ok := flagNames[flag]
+		if ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Delivered returns the delivery time encoded in the leading field of the base name
+func (m *MessageFile) Delivered() (time.Time, bool) {
+	secStr, _, _ := strings.Cut(m.Base, ".")
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// Filename returns the maildir filename for the message with the given flags
+func (m *MessageFile) Filename(flags string) string {
+	return m.Name + ":2," + SortFlags(flags)
+}
+
+// SizedName returns the message name with its S= and W= fields set to new
+// sizes, keeping any other fields
+func (m *MessageFile) SizedName(size, sizeW int64) string {
+	parts := strings.Split(m.Name, ",")
+	fields := []string{parts[0]}
+	for _, part := range parts[1:] {
+		if !strings.HasPrefix(part, "S=") && !strings.HasPrefix(part, "W=") {
+			fields = append(fields, part)
+		}
+	}
+	fields = append(fields, fmt.Sprintf("S=%d", size), fmt.Sprintf("W=%d", sizeW))
+	return strings.Join(fields, ",")
+}
+
+// SortFlags returns the flag letters in the ASCII order maildir requires
+func SortFlags(flags string) string {
+	letters := []rune{}
+	for _, flag := range flags {
+		if !strings.ContainsRune(string(letters), flag) {
+			letters = append(letters, flag)
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i] < letters[j] })
+	return string(letters)
+}
+
+// FlagLetter returns the maildir flag letter for an IMAP flag name or letter
+func FlagLetter(name string) (rune, error) {
+	name = strings.TrimPrefix(name, "\\")
+	if len(name) == 1 {
+		letter := rune(strings.ToUpper(name)[0])
+		_, ok := flagNames[letter]
+		if ok {
+			return letter, nil
+		}
+	}
+	name = strings.ToLower(name)
+	if name == "answered" {
+		name = "replied"
+	}
+	if name == "deleted" {
+		name = "trashed"
+	}
+	for letter, flagName := range flagNames {
+		if flagName == name {
+			return letter, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown flag: %s", name)
+}
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Part is a leaf MIME part of a message with its transfer encoding removed
+type Part struct {
+	Header    textproto.MIMEHeader
+	MediaType string
+	Params    map[string]string
+	Content   []byte
+}
+
+// Filename returns the decoded attachment filename of the part, if any
+func (p *Part) Filename() string {
+	_, params, err := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		return DecodeHeader(params["filename"])
+	}
+	return DecodeHeader(p.Params["name"])
+}
+
+// IsText returns true for text parts which are not attachments
+func (p *Part) IsText() bool {
+	if !strings.HasPrefix(p.MediaType, "text/") {
+		return false
+	}
+	disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	return disposition != "attachment"
+}
+
+// IsAttachment returns true for parts which are not message text
+func (p *Part) IsAttachment() bool {
+	return !p.IsText()
+}
+
+// Text returns the part content converted from its charset to UTF-8
+func (p *Part) Text() (string, error) {
+	charset := strings.ToLower(p.Params["charset"])
+	switch charset {
+	case "", "utf-8", "us-ascii":
+		return string(p.Content), nil
+	}
+	reader, err := CharsetReader(charset, bytes.NewReader(p.Content))
+	if err != nil {
+		// fall back to the raw bytes for unknown charsets
+		return string(p.Content), nil
+	}
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed decoding %s text: %v", charset, err)
+	}
+	return string(text), nil
+}
+
+// PartError reports the MIME parts of a message which could not be decoded
+// and were skipped
+type PartError struct {
+	Errors []error
+}
+
+func (e *PartError) Error() string {
+	messages := []string{}
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return "skipped undecodable parts: " + strings.Join(messages, "; ")
+}
+
+// WalkMessageParts parses a message and calls fn for each leaf MIME part,
+// descending into multipart and message/rfc822 parts.  Parts which cannot be
+// decoded are skipped and reported together in a *PartError once the other
+// parts have been walked.
+func WalkMessageParts(data []byte, fn func(*Part) error) error {
+	msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return fmt.Errorf("failed parsing message: %v", err)
+	}
+	skipped := PartError{}
+	err = walkPart(textproto.MIMEHeader(msg.Header), msg.Body, fn, &skipped)
+	if err != nil {
+		return err
+	}
+	if len(skipped.Errors) > 0 {
+		return &skipped
+	}
+	return nil
+}
+
+func walkPart(header textproto.MIMEHeader, body io.Reader, fn func(*Part) error, skipped *PartError) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				skipped.Errors = append(skipped.Errors, fmt.Errorf("failed reading multipart: %v", err))
+				return nil
+			}
+			err = walkPart(part.Header, part, fn, skipped)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	content, err := io.ReadAll(TransferDecoder(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		skipped.Errors = append(skipped.Errors, fmt.Errorf("failed decoding %s part: %v", mediaType, err))
+		return nil
+	}
+	if mediaType == "message/rfc822" {
+		msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(content)))
+		if err == nil {
+			return walkPart(textproto.MIMEHeader(msg.Header), msg.Body, fn, skipped)
+		}
+	}
+	return fn(&Part{Header: header, MediaType: mediaType, Params: params, Content: content})
+}
+
+// TransferDecoder returns a reader removing a Content-Transfer-Encoding
+func TransferDecoder(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Filter{reader: body})
+	}
+	return body
+}
+
+// base64Filter drops line breaks and other characters outside the base64 alphabet
+type base64Filter struct {
+	reader io.Reader
+}
+
+func (f *base64Filter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.reader.Read(p)
+		count := 0
+		for _, c := range p[:n] {
+			if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '+' || c == '/' || c == '=' {
+				p[count] = c
+				count += 1
+			}
+		}
+		if count > 0 || err != nil {
+			return count, err
+		}
+	}
+}
+
+// StrippedPart describes an attachment removed from a message
+type StrippedPart struct {
+	Name      string
+	MediaType string
+	Size      int64
+}
+
+// StripAttachments replaces each attachment of the multipart sections of a
+// message whose decoded size exceeds minSize with a text/plain placeholder
+// and returns the new message data and the removed parts; all other bytes of
+// the message are kept
+func StripAttachments(data []byte, minSize int64, date time.Time) ([]byte, []StrippedPart, error) {
+	s := attachmentStripper{minSize: minSize, date: date, newline: "\n", stripped: []StrippedPart{}}
+	if bytes.Contains(data, []byte("\r\n")) {
+		s.newline = "\r\n"
+	}
+	result, err := s.stripPart(data, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, s.stripped, nil
+}
+
+type attachmentStripper struct {
+	minSize  int64
+	date     time.Time
+	newline  string
+	stripped []StrippedPart
+}
+
+// splitRawPart returns the header, the blank line and the body of a raw part
+func splitRawPart(data []byte) ([]byte, []byte, []byte) {
+	for _, separator := range []string{"\r\n", "\n"} {
+		if bytes.HasPrefix(data, []byte(separator)) {
+			return nil, data[:len(separator)], data[len(separator):]
+		}
+	}
+	crlf := bytes.Index(data, []byte("\n\r\n"))
+	lf := bytes.Index(data, []byte("\n\n"))
+	switch {
+	case crlf >= 0 && (lf < 0 || crlf < lf):
+		return data[:crlf+1], data[crlf+1 : crlf+3], data[crlf+3:]
+	case lf >= 0:
+		return data[:lf+1], data[lf+1 : lf+2], data[lf+2:]
+	}
+	return data, nil, nil
+}
+
+func (s *attachmentStripper) stripPart(raw []byte, topLevel bool) ([]byte, error) {
+	header, separator, body := splitRawPart(raw)
+	mimeHeader, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(append([]byte{}, header...), s.newline...)))).ReadMIMEHeader()
+	if err != nil && len(header) > 0 {
+		return nil, fmt.Errorf("failed parsing part header: %v", err)
+	}
+	contentType := mimeHeader.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		stripped := s.stripMultipart(body, params["boundary"])
+		if stripped == nil {
+			return raw, nil
+		}
+		result := append(append(append([]byte{}, header...), separator...), stripped...)
+		return result, nil
+	}
+	part := Part{Header: mimeHeader, MediaType: mediaType, Params: params}
+	if topLevel || !part.IsAttachment() {
+		return raw, nil
+	}
+	size, err := io.Copy(io.Discard, TransferDecoder(mimeHeader.Get("Content-Transfer-Encoding"), bytes.NewReader(body)))
+	if err != nil {
+		size = int64(len(body))
+	}
+	if size <= s.minSize {
+		return raw, nil
+	}
+	name := part.Filename()
+	s.stripped = append(s.stripped, StrippedPart{Name: name, MediaType: mediaType, Size: size})
+	placeholder := []string{
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+		"Content-Disposition: inline",
+		"",
+		fmt.Sprintf("[attachment \"%s\" (%s, %d bytes) removed %s]", name, mediaType, size, s.date.Format(time.RFC1123Z)),
+	}
+	return []byte(strings.Join(placeholder, s.newline)), nil
+}
+
+// stripMultipart strips the parts of a multipart body, returning nil if the
+// body has no delimiter lines
+func (s *attachmentStripper) stripMultipart(body []byte, boundary string) []byte {
+	delimiter := []byte("--" + boundary)
+	// the start and end offsets of each delimiter line
+	lines := [][2]int{}
+	for offset := 0; offset < len(body); {
+		end := bytes.IndexByte(body[offset:], '\n')
+		if end < 0 {
+			end = len(body)
+		} else {
+			end += offset + 1
+		}
+		if bytes.HasPrefix(body[offset:end], delimiter) {
+			lines = append(lines, [2]int{offset, end})
+		}
+		offset = end
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	result := append([]byte{}, body[:lines[0][0]]...)
+	for i, line := range lines {
+		result = append(result, body[line[0]:line[1]]...)
+		closing := bytes.HasPrefix(body[line[0]:line[1]], append(append([]byte{}, delimiter...), '-', '-'))
+		if closing {
+			return append(result, body[line[1]:]...)
+		}
+		end := len(body)
+		if i+1 < len(lines) {
+			end = lines[i+1][0]
+		}
+		raw := body[line[1]:end]
+		// the line break before a delimiter belongs to the delimiter
+		trailing := []byte{}
+		for _, newline := range []string{"\r\n", "\n"} {
+			if bytes.HasSuffix(raw, []byte(newline)) {
+				trailing = []byte(newline)
+				raw = raw[:len(raw)-len(newline)]
+				break
+			}
+		}
+		stripped, err := s.stripPart(raw, false)
+		if err != nil {
+			// parts which cannot be parsed are kept as they are
+			stripped = raw
+		}
+		result = append(append(result, stripped...), trailing...)
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// moveCmd represents the move command
+var moveCmd = &cobra.Command{
+	Use:   "move DEST [DIR]",
+	Short: "move messages into another folder",
+	Long: `
+Move the messages matching a find QUERY from the maildirs selected by
+--folder and --recurse into the folder DEST of the root maildir DIR.  Moved
+messages are removed from the source dovecot-uidlist and assigned new uids in
+the destination.  Dovecot should not be running while messages are moved.
+
+Flags:
+    --query QUERY	move messages matching a find QUERY (required)
+    --folder NAME	move messages from the named folder
+    --recurse		move messages from all folders
+    --dry-run		output the moves without performing them
+    --reset-index MODE	remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(TransferMessages(args, "move"))
+	},
+}
+
+// copyCmd represents the copy command
+var copyCmd = &cobra.Command{
+	Use:   "copy DEST [DIR]",
+	Short: "copy messages into another folder",
+	Long: `
+Copy the messages matching a find QUERY from the maildirs selected by
+--folder and --recurse into the folder DEST of the root maildir DIR.  Copies
+are hard links where possible, are given new unique filenames and are
+assigned new uids in the destination dovecot-uidlist.
+
+Flags:
+    --query QUERY	copy messages matching a find QUERY (required)
+    --folder NAME	copy messages from the named folder
+    --recurse		copy messages from all folders
+    --dry-run		output the copies without performing them
+    --reset-index MODE	remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(TransferMessages(args, "copy"))
+	},
+}
+
+// TransferMessages moves or copies the messages matching the query flag
+// into the destination folder
+func TransferMessages(args []string, operation string) error {
+	dryRun := viper.GetBool("dry-run")
+	query, err := QueryFlag(operation + ".query")
+	if err != nil {
+		return err
+	}
+	if query == nil {
+		return fmt.Errorf("a --query selecting the messages to %s is required", operation)
+	}
+	viper.Set("all", true)
+	root := MaildirRoot(args[1:])
+	dest := FolderPath(root, args[0])
+	maildir, err := IsMaildir(dest)
+	if err != nil || !maildir {
+		return fmt.Errorf("destination folder not found: %s", args[0])
+	}
+	// collect the messages first so that the walk never sees transferred files
+	messages := []*Message{}
+	err = FindMessages(root, query, func(msg *Message) error {
+		if msg.Maildir != dest {
+			messages = append(messages, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mover := NewMessageMover(root)
+	for _, msg := range messages {
+		if dryRun {
+			fmt.Printf("%s %s -> %s\n", operation, msg.File.Path, args[0])
+			continue
+		}
+		var target string
+		if operation == "move" {
+			target, err = mover.Move(msg, dest)
+		} else {
+			target, err = mover.Copy(msg, dest)
+		}
+		if err != nil {
+			mover.Close()
+			return err
+		}
+		fmt.Printf("%s %s -> %s\n", operation, msg.File.Path, target)
+	}
+	return mover.Close()
+}
+
+func init() {
+	rootCmd.AddCommand(moveCmd)
+	rootCmd.AddCommand(copyCmd)
+	moveCmd.Flags().String("query", "", "move messages matching query")
+	viper.BindPFlag("move.query", moveCmd.Flags().Lookup("query"))
+	copyCmd.Flags().String("query", "", "copy messages matching query")
+	viper.BindPFlag("copy.query", copyCmd.Flags().Lookup("query"))
+}
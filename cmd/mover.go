@@ -0,0 +1,431 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MessageMover moves and copies messages between the maildirs of a root
+// maildir, keeping their dovecot-uidlist, dovecot-keywords and maildirsize
+// files up to date
+type MessageMover struct {
+	root            string
+	uidlists        map[string]*Uidlist
+	changed         map[string]bool
+	stale           map[string]bool
+	keywords        map[string]*Keywords
+	changedKeywords map[string]bool
+	size            int64
+	count           int64
+}
+
+func NewMessageMover(root string) *MessageMover {
+	return &MessageMover{
+		root:            root,
+		uidlists:        map[string]*Uidlist{},
+		changed:         map[string]bool{},
+		stale:           map[string]bool{},
+		keywords:        map[string]*Keywords{},
+		changedKeywords: map[string]bool{},
+	}
+}
+
+func (m *MessageMover) readKeywords(dir string) (*Keywords, error) {
+	keywords, ok := m.keywords[dir]
+	if !ok {
+		var err error
+		keywords, err = ReadKeywords(dir)
+		if err != nil {
+			return nil, err
+		}
+		m.keywords[dir] = keywords
+	}
+	return keywords, nil
+}
+
+// destFlags returns the flags of a message with its keyword letters mapped
+// to the dovecot-keywords of the destination maildir
+func (m *MessageMover) destFlags(msg *Message, dir string) (string, error) {
+	source, err := m.readKeywords(msg.Maildir)
+	if err != nil {
+		return "", err
+	}
+	dest, err := m.readKeywords(dir)
+	if err != nil {
+		return "", err
+	}
+	flags, changed, err := TranslateKeywords(msg.File.Flags, source, dest)
+	if err != nil {
+		return "", err
+	}
+	if changed {
+		m.changedKeywords[dir] = true
+	}
+	return flags, nil
+}
+
+func (m *MessageMover) uidlist(dir string) (*Uidlist, error) {
+	uidlist, ok := m.uidlists[dir]
+	if !ok {
+		var err error
+		uidlist, err = ReadUidlist(dir)
+		if err != nil {
+			return nil, err
+		}
+		m.uidlists[dir] = uidlist
+	}
+	return uidlist, nil
+}
+
+// Move renames a message into the cur subdirectory of the destination maildir,
+// assigning it a new uid, and returns the new pathname
+func (m *MessageMover) Move(msg *Message, dir string) (string, error) {
+	flags, err := m.destFlags(msg, dir)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(dir, "cur", msg.File.Filename(flags))
+	_, err = os.Lstat(target)
+	if err == nil {
+		return "", fmt.Errorf("message exists in destination: %s", target)
+	}
+	err = os.Rename(msg.File.Path, target)
+	if err != nil {
+		// rename fails across filesystems, so fall back to copying
+		err = copyFile(msg.File.Path, filepath.Join(dir, "tmp", filepath.Base(msg.File.Path)), target)
+		if err != nil {
+			return "", err
+		}
+		err = os.Remove(msg.File.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed removing moved message: %v", err)
+		}
+	}
+	err = m.moved(msg, dir, target)
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// moved transfers the uidlist entry of a moved message to the destination
+// maildir, assigning it a new uid
+func (m *MessageMover) moved(msg *Message, dir, target string) error {
+	source, err := m.uidlist(msg.Maildir)
+	if err != nil {
+		return err
+	}
+	if source.Remove(msg.File.Path) {
+		m.changed[msg.Maildir] = true
+	}
+	dest, err := m.uidlist(dir)
+	if err != nil {
+		return err
+	}
+	dest.Append(target)
+	m.changed[dir] = true
+	return nil
+}
+
+// MoveCompressed moves a message like Move, compressing it with codec on the
+// way unless it is already compressed or codec is empty
+func (m *MessageMover) MoveCompressed(msg *Message, dir, codec string) (string, error) {
+	if codec == "" {
+		return m.Move(msg, dir)
+	}
+	data, cmpType, err := ReadMessage(msg.File.Path)
+	if err != nil {
+		return "", err
+	}
+	if cmpType != "" {
+		return m.Move(msg, dir)
+	}
+	stat, err := msg.Stat()
+	if err != nil {
+		return "", err
+	}
+	flags, err := m.destFlags(msg, dir)
+	if err != nil {
+		return "", err
+	}
+	// S= and W= in the filename keep the uncompressed sizes, and are added
+	// if missing since dovecot would otherwise use the compressed file size
+	name := msg.File.SizedName(MessageSizes(data))
+	target := filepath.Join(dir, "cur", name+":2,"+SortFlags(flags))
+	_, err = os.Lstat(target)
+	if err == nil {
+		return "", fmt.Errorf("message exists in destination: %s", target)
+	}
+	content, err := CompressData(data, codec)
+	if err != nil {
+		return "", err
+	}
+	tmpPath := filepath.Join(dir, "tmp", name)
+	err = os.WriteFile(tmpPath, content, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed writing compressed message: %v", err)
+	}
+	err = SetStat(tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	err = os.Rename(tmpPath, target)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed moving message to cur: %v", err)
+	}
+	err = os.Remove(msg.File.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed removing moved message: %v", err)
+	}
+	err = m.moved(msg, dir, target)
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// Copy links or copies a message into the cur subdirectory of the destination
+// maildir with a new unique name, assigning it a new uid, and returns the new pathname
+func (m *MessageMover) Copy(msg *Message, dir string) (string, error) {
+	flags, err := m.destFlags(msg, dir)
+	if err != nil {
+		return "", err
+	}
+	received, err := msg.Received()
+	if err != nil {
+		return "", err
+	}
+	name := UniqueName(received)
+	_, sizes, _ := strings.Cut(msg.File.Name, ",")
+	if sizes != "" {
+		name += "," + sizes
+	}
+	target := filepath.Join(dir, "cur", name+":2,"+flags)
+	tmpPath := filepath.Join(dir, "tmp", name)
+	err = os.Link(msg.File.Path, tmpPath)
+	if err == nil {
+		err = os.Rename(tmpPath, target)
+		if err != nil {
+			os.Remove(tmpPath)
+			return "", fmt.Errorf("failed moving message to cur: %v", err)
+		}
+	} else {
+		err = copyFile(msg.File.Path, tmpPath, target)
+		if err != nil {
+			return "", err
+		}
+	}
+	dest, err := m.uidlist(dir)
+	if err != nil {
+		return "", err
+	}
+	dest.Append(target)
+	m.changed[dir] = true
+	size, err := msg.Size()
+	if err != nil {
+		return "", err
+	}
+	m.size += size
+	m.count += 1
+	return target, nil
+}
+
+// keywordLetter returns the letter of a keyword in the dovecot-keywords of a
+// maildir, adding the keyword if needed
+func (m *MessageMover) keywordLetter(dir, name string) (rune, error) {
+	keywords, err := m.readKeywords(dir)
+	if err != nil {
+		return 0, err
+	}
+	letter, added, err := keywords.Add(name)
+	if err != nil {
+		return 0, err
+	}
+	if added {
+		m.changedKeywords[dir] = true
+	}
+	return letter, nil
+}
+
+// SetFlags renames a message to carry the standard flags and keywords of
+// names, where standard flags are given as \name, and returns the new pathname
+func (m *MessageMover) SetFlags(msg *Message, names []string) (string, error) {
+	flags := ""
+	for _, name := range names {
+		if strings.HasPrefix(name, "\\") {
+			letter, err := FlagLetter(name)
+			if err != nil {
+				return "", err
+			}
+			flags += string(letter)
+			continue
+		}
+		letter, err := m.keywordLetter(msg.Maildir, name)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", msg.Folder, err)
+		}
+		flags += string(letter)
+	}
+	return m.SetFlagLetters(msg, flags)
+}
+
+// SetFlagLetters renames a message to carry the flag and keyword letters of
+// flags and returns the new pathname
+func (m *MessageMover) SetFlagLetters(msg *Message, flags string) (string, error) {
+	// a message with flags belongs in cur, even if it was still in new
+	target := filepath.Join(msg.Maildir, "cur", msg.File.Filename(flags))
+	if target == msg.File.Path {
+		return target, nil
+	}
+	// the mapping is written before any message uses a new letter
+	if m.changedKeywords[msg.Maildir] {
+		err := m.keywords[msg.Maildir].Write(msg.Maildir)
+		if err != nil {
+			return "", err
+		}
+		delete(m.changedKeywords, msg.Maildir)
+	}
+	err := os.Rename(msg.File.Path, target)
+	if err != nil {
+		return "", fmt.Errorf("failed renaming message: %v", err)
+	}
+	// the uidlist is unchanged, but the maildir index is stale
+	m.stale[msg.Maildir] = true
+	return target, nil
+}
+
+// Remove deletes a message, dropping it from its uidlist and maildirsize
+func (m *MessageMover) Remove(msg *Message) error {
+	size, err := msg.Size()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(msg.File.Path)
+	if err != nil {
+		return fmt.Errorf("failed removing message: %v", err)
+	}
+	uidlist, err := m.uidlist(msg.Maildir)
+	if err != nil {
+		return err
+	}
+	uidlist.Remove(msg.File.Path)
+	m.changed[msg.Maildir] = true
+	m.size -= size
+	m.count -= 1
+	return nil
+}
+
+// Replace delivers new content for a message as a new message with the same
+// flags and mod time, so it is given a new uid, and removes the original
+func (m *MessageMover) Replace(msg *Message, data []byte, codec string) (string, error) {
+	stat, err := msg.Stat()
+	if err != nil {
+		return "", err
+	}
+	size, err := msg.Size()
+	if err != nil {
+		return "", err
+	}
+	target, err := DeliverMessage(msg.Maildir, data, msg.File.Flags, stat.ModTime(), codec)
+	if err != nil {
+		return "", err
+	}
+	err = os.Remove(msg.File.Path)
+	if err != nil {
+		os.Remove(target)
+		return "", fmt.Errorf("failed removing replaced message: %v", err)
+	}
+	uidlist, err := m.uidlist(msg.Maildir)
+	if err != nil {
+		return "", err
+	}
+	uidlist.Remove(msg.File.Path)
+	uidlist.Append(target)
+	m.changed[msg.Maildir] = true
+	newSize, _ := MessageSizes(data)
+	m.size += newSize - size
+	return target, nil
+}
+
+// copyFile copies source through tmpPath to target, keeping its mode,
+// modification time and ownership
+func copyFile(source, tmpPath, target string) error {
+	stat, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed opening %s: %v", source, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed creating %s: %v", tmpPath, err)
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed copying %s: %v", source, err)
+	}
+	err = SetStat(tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, target)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed moving message to cur: %v", err)
+	}
+	return nil
+}
+
+// Close writes the changed uidlists which already exist, since a new uidlist
+// would not match an existing dovecot.index, and the changed keywords, applies
+// --reset-index to the changed maildirs and records copied messages in
+// maildirsize
+func (m *MessageMover) Close() error {
+	for dir := range m.changedKeywords {
+		err := m.keywords[dir].Write(dir)
+		if err != nil {
+			return err
+		}
+	}
+	m.changedKeywords = map[string]bool{}
+	dirs := []string{}
+	for dir := range m.changed {
+		_, err := os.Stat(filepath.Join(dir, UidlistFile))
+		if err == nil {
+			err = m.uidlists[dir].Write(dir)
+			if err != nil {
+				return err
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+	for dir := range m.stale {
+		if !m.changed[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	m.changed = map[string]bool{}
+	m.stale = map[string]bool{}
+	err := ResetIndexes(dirs)
+	if err != nil {
+		return err
+	}
+	err = AppendQuotaDelta(m.root, m.size, m.count)
+	m.size, m.count = 0, 0
+	return err
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageMoverSetFlags(t *testing.T) {
+	root := makeTestMaildir(t)
+	dir := filepath.Join(root, ".Archive")
+	pathName := findTestMessage(t, dir, "1704067200.M3P1.host")
+	msg, err := NewMessage(dir, "Archive", nil, pathName)
+	require.Nil(t, err)
+	mover := NewMessageMover(root)
+	target, err := mover.SetFlags(msg, []string{"\\Seen", "\\Answered", "$Junk"})
+	require.Nil(t, err)
+	require.Nil(t, mover.Close())
+	require.Equal(t, target, findTestMessage(t, dir, "1704067200.M3P1.host"))
+	require.Contains(t, target, ":2,RSa")
+	keywords, err := ReadKeywords(dir)
+	require.Nil(t, err)
+	require.Equal(t, "$Junk", keywords.Names[0])
+
+	// renaming a message leaves a missing uidlist missing
+	require.NoFileExists(t, filepath.Join(dir, UidlistFile))
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// PrintJSON writes a value to stdout as indented json
+func PrintJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(value)
+	if err != nil {
+		return fmt.Errorf("failed formatting json: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,559 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"io/fs"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message holds a message file and its lazily loaded attributes
+type Message struct {
+	File     *MessageFile
+	Maildir  string
+	Folder   string
+	Uid      uint32
+	header   mail.Header
+	stat     fs.FileInfo
+	cmpType  *string
+	keywords *Keywords
+}
+
+func NewMessage(maildir, folder string, uidlist *Uidlist, pathName string) (*Message, error) {
+	file, err := ParseMessageFile(pathName)
+	if err != nil {
+		return nil, err
+	}
+	msg := Message{File: file, Maildir: maildir, Folder: folder}
+	if uidlist != nil {
+		msg.Uid, _ = uidlist.Lookup(pathName)
+	}
+	return &msg, nil
+}
+
+// Keywords returns the dovecot-keywords mapping of the message's maildir
+func (m *Message) Keywords() (*Keywords, error) {
+	if m.keywords == nil {
+		keywords, err := ReadKeywords(m.Maildir)
+		if err != nil {
+			return nil, err
+		}
+		m.keywords = keywords
+	}
+	return m.keywords, nil
+}
+
+// KeywordNames returns the custom keywords set on the message
+func (m *Message) KeywordNames() ([]string, error) {
+	keywords, err := m.Keywords()
+	if err != nil {
+		return nil, err
+	}
+	names, _ := m.File.KeywordNames(keywords)
+	return names, nil
+}
+
+func (m *Message) Header() (mail.Header, error) {
+	if m.header == nil {
+		header, err := ReadMessageHeader(m.File.Path)
+		if err != nil {
+			return nil, err
+		}
+		m.header = header
+	}
+	return m.header, nil
+}
+
+// HeaderValue returns the decoded value of a header field, or an empty string
+func (m *Message) HeaderValue(key string) (string, error) {
+	header, err := m.Header()
+	if err != nil {
+		return "", err
+	}
+	return DecodeHeader(header.Get(key)), nil
+}
+
+func (m *Message) Stat() (fs.FileInfo, error) {
+	if m.stat == nil {
+		stat, err := os.Stat(m.File.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Stat failed: %v", err)
+		}
+		m.stat = stat
+	}
+	return m.stat, nil
+}
+
+// Compression returns the detected compression type, empty for uncompressed files
+func (m *Message) Compression() (string, error) {
+	if m.cmpType == nil {
+		file, err := os.Open(m.File.Path)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		cmpType, err := DetectCompressedFile(file)
+		if err != nil {
+			return "", fmt.Errorf("DetectCompressedFile: %v", err)
+		}
+		if cmpType == nil {
+			cmpType = new(string)
+		}
+		m.cmpType = cmpType
+	}
+	return *m.cmpType, nil
+}
+
+// Size returns the uncompressed message size, preferring the S= filename value
+func (m *Message) Size() (int64, error) {
+	if m.File.Size > 0 {
+		return m.File.Size, nil
+	}
+	cmpType, err := m.Compression()
+	if err != nil {
+		return 0, err
+	}
+	if cmpType == "" {
+		stat, err := m.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return stat.Size(), nil
+	}
+	reader, _, err := OpenMessage(m.File.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	size, err := io.Copy(io.Discard, reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading message %s: %v", m.File.Path, err)
+	}
+	m.File.Size = size
+	return size, nil
+}
+
+// Date returns the Date header time, falling back to the delivery time and
+// then the file modification time
+func (m *Message) Date() (time.Time, error) {
+	value, err := m.HeaderValue("Date")
+	if err != nil {
+		return time.Time{}, err
+	}
+	if value != "" {
+		date, err := mail.ParseDate(value)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return m.Received()
+}
+
+// Received returns the delivery time from the filename, falling back to the
+// file modification time
+func (m *Message) Received() (time.Time, error) {
+	delivered, ok := m.File.Delivered()
+	if ok {
+		return delivered, nil
+	}
+	stat, err := m.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return stat.ModTime(), nil
+}
+
+// Query is a list of terms which must all match a message
+type Query struct {
+	Text  string
+	terms []queryTerm
+}
+
+type queryTerm struct {
+	negate bool
+	match  func(*Message) (bool, error)
+}
+
+var headerKeys map[string]string = map[string]string{
+	"from":       "From",
+	"to":         "To",
+	"cc":         "Cc",
+	"subject":    "Subject",
+	"message-id": "Message-Id",
+}
+
+// ParseQuery parses a query of space-separated KEY:VALUE terms
+func ParseQuery(text string) (*Query, error) {
+	query := Query{Text: text}
+	tokens, err := splitQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		term := queryTerm{}
+		if strings.HasPrefix(token, "-") || strings.HasPrefix(token, "!") {
+			term.negate = true
+			token = token[1:]
+		}
+		key, value, found := strings.Cut(token, ":")
+		if !found {
+			key, value = "subject", token
+		}
+		key = strings.ToLower(key)
+		if value == "" {
+			return nil, fmt.Errorf("missing value in query term: %s", token)
+		}
+		term.match, err = parseTerm(key, value)
+		if err != nil {
+			return nil, err
+		}
+		query.terms = append(query.terms, term)
+	}
+	return &query, nil
+}
+
+func parseTerm(key, value string) (func(*Message) (bool, error), error) {
+	headerKey, ok := headerKeys[key]
+	if ok {
+		return matchHeader(headerKey, value), nil
+	}
+	switch key {
+	case "header":
+		name, headerValue, _ := strings.Cut(value, "=")
+		return matchHeader(name, headerValue), nil
+	case "date":
+		start, end, err := parseDateRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchDate(start, end), nil
+	case "after", "since":
+		start, _, err := parseDateRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchDate(start, time.Time{}), nil
+	case "before":
+		end, _, err := parseDateRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchDate(time.Time{}, end), nil
+	case "size":
+		return parseSizeTerm(value)
+	case "larger":
+		size, err := ParseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchSize(size+1, 0, false), nil
+	case "smaller":
+		size, err := ParseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchSize(0, size-1, true), nil
+	case "flag":
+		letter, err := FlagLetter(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(m *Message) (bool, error) {
+			return m.File.HasFlag(letter), nil
+		}, nil
+	case "keyword":
+		return func(m *Message) (bool, error) {
+			keywords, err := m.Keywords()
+			if err != nil {
+				return false, err
+			}
+			letter, ok := keywords.Letter(value)
+			return ok && m.File.HasFlag(letter), nil
+		}, nil
+	case "folder":
+		_, err := filepath.Match(value, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid folder pattern: %s", value)
+		}
+		return func(m *Message) (bool, error) {
+			return MatchFolder(value, m.Folder), nil
+		}, nil
+	case "compressed":
+		var want bool
+		switch strings.ToLower(value) {
+		case "yes", "true":
+			want = true
+		case "no", "false":
+			want = false
+		default:
+			return nil, fmt.Errorf("invalid compressed value: %s", value)
+		}
+		return func(m *Message) (bool, error) {
+			cmpType, err := m.Compression()
+			if err != nil {
+				return false, err
+			}
+			return (cmpType != "") == want, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown query key: %s", key)
+}
+
+func matchHeader(key, value string) func(*Message) (bool, error) {
+	value = strings.ToLower(value)
+	return func(m *Message) (bool, error) {
+		header, err := m.HeaderValue(key)
+		if err != nil {
+			return false, err
+		}
+		return strings.Contains(strings.ToLower(header), value), nil
+	}
+}
+
+func matchDate(start, end time.Time) func(*Message) (bool, error) {
+	return func(m *Message) (bool, error) {
+		date, err := m.Date()
+		if err != nil {
+			return false, err
+		}
+		if !start.IsZero() && date.Before(start) {
+			return false, nil
+		}
+		if !end.IsZero() && !date.Before(end) {
+			return false, nil
+		}
+		return true, nil
+	}
+}
+
+// matchSize matches sizes of at least min and, if hasMax is set, at most
+// max, so a negative max matches no message
+func matchSize(min, max int64, hasMax bool) func(*Message) (bool, error) {
+	return func(m *Message) (bool, error) {
+		size, err := m.Size()
+		if err != nil {
+			return false, err
+		}
+		if size < min {
+			return false, nil
+		}
+		if hasMax && size > max {
+			return false, nil
+		}
+		return true, nil
+	}
+}
+
+// MatchFolder matches a folder name against a glob pattern, ignoring the case of INBOX
+func MatchFolder(pattern, folder string) bool {
+	if strings.EqualFold(folder, "INBOX") && strings.EqualFold(pattern, "INBOX") {
+		return true
+	}
+	matched, _ := filepath.Match(pattern, folder)
+	return matched
+}
+
+// parseDateRange returns the start and end of a date, month or year, or of a START..END range
+func parseDateRange(value string) (time.Time, time.Time, error) {
+	first, last, isRange := strings.Cut(value, "..")
+	if !isRange {
+		return parseDate(value)
+	}
+	var start, end time.Time
+	var err error
+	if first != "" {
+		start, _, err = parseDate(first)
+		if err != nil {
+			return start, end, err
+		}
+	}
+	if last != "" {
+		_, end, err = parseDate(last)
+		if err != nil {
+			return start, end, err
+		}
+	}
+	return start, end, nil
+}
+
+func parseDate(value string) (time.Time, time.Time, error) {
+	layouts := []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	}
+	for _, layout := range layouts {
+		start, err := time.ParseInLocation(layout.layout, value, time.Local)
+		if err == nil {
+			return start, start.AddDate(layout.years, layout.months, layout.days), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date: %s", value)
+}
+
+func parseSizeTerm(value string) (func(*Message) (bool, error), error) {
+	switch {
+	case strings.HasPrefix(value, ">"):
+		size, err := ParseSize(value[1:])
+		if err != nil {
+			return nil, err
+		}
+		return matchSize(size+1, 0, false), nil
+	case strings.HasPrefix(value, "<"):
+		size, err := ParseSize(value[1:])
+		if err != nil {
+			return nil, err
+		}
+		return matchSize(0, size-1, true), nil
+	}
+	first, last, isRange := strings.Cut(value, "..")
+	if !isRange {
+		size, err := ParseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchSize(size, size, true), nil
+	}
+	var min, max int64
+	var err error
+	if first != "" {
+		min, err = ParseSize(first)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if last != "" {
+		max, err = ParseSize(last)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matchSize(min, max, last != ""), nil
+}
+
+// ParseSize parses a byte count with an optional K, M or G suffix
+func ParseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	upper := strings.TrimSuffix(strings.ToUpper(value), "B")
+	switch {
+	case strings.HasSuffix(upper, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(upper, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(upper, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		upper = upper[:len(upper)-1]
+	}
+	size, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	return size * multiplier, nil
+}
+
+func splitQuery(text string) ([]string, error) {
+	tokens := []string{}
+	var token strings.Builder
+	quoted := false
+	for _, c := range text {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t' || c == '\n'):
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(c)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in query: %s", text)
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
+
+// Match returns true if all query terms match the message; a nil query matches all
+func (q *Query) Match(msg *Message) (bool, error) {
+	if q == nil {
+		return true, nil
+	}
+	for _, term := range q.terms {
+		matched, err := term.match(msg)
+		if err != nil {
+			return false, err
+		}
+		if matched == term.negate {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// FindMessages calls fn for each message file in cur and new of the maildirs
+// below root selected by the --folder and --recurse flags matching the query
+func FindMessages(root string, query *Query, fn func(*Message) error) error {
+	dirs, err := SelectMaildirs(root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		folder := FolderName(root, dir)
+		uidlist, err := ReadUidlist(dir)
+		if err != nil {
+			return err
+		}
+		keywords, err := ReadKeywords(dir)
+		if err != nil {
+			return err
+		}
+		files, err := ListMessageFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, pathName := range files {
+			msg, err := NewMessage(dir, folder, uidlist, pathName)
+			if err != nil {
+				return err
+			}
+			msg.keywords = keywords
+			matched, err := query.Match(msg)
+			if err != nil {
+				return err
+			}
+			if matched {
+				err = fn(msg)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// QueryFlag parses the query stored in a viper key, returning nil if it is empty
+func QueryFlag(key string) (*Query, error) {
+	text := viper.GetString(key)
+	if text == "" {
+		return nil, nil
+	}
+	return ParseQuery(text)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"path/filepath"
+)
+
+// quotaCmd represents the quota command
+var quotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "show or rebuild the maildirsize quota file",
+	Long: `
+Manage the Maildir++ maildirsize file used by the dovecot maildir quota
+backend.  The file lives in the root maildir DIR and covers all of its
+folders.  The default DIR is ~/Maildir.
+`,
+}
+
+// quotaShowCmd represents the quota show command
+var quotaShowCmd = &cobra.Command{
+	Use:   "show [DIR]",
+	Short: "output quota limits and usage",
+	Long: `
+Output the quota definition and the storage and message usage recorded in the
+maildirsize file of the root maildir DIR.
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ShowQuota(args))
+	},
+}
+
+// quotaRecalcCmd represents the quota recalc command
+var quotaRecalcCmd = &cobra.Command{
+	Use:   "recalc [DIR]",
+	Short: "rebuild maildirsize from message sizes",
+	Long: `
+Rebuild the maildirsize file of the root maildir DIR from the S= sizes of the
+messages in all of its folders.  Messages without S= are counted with their
+uncompressed size.  The quota definition line is kept unless --definition is
+given, which is required if the file does not exist.
+
+Flags:
+    --definition DEF	quota definition, for example 1073741824S,100000C
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(RecalcQuotaFile(args))
+	},
+}
+
+func ShowQuota(args []string) error {
+	root := MaildirRoot(args)
+	quota, err := ReadMaildirsize(root)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("maildirsize: %s\n", filepath.Join(root, MaildirsizeFile))
+	fmt.Printf("definition: %s\n", quota.Definition)
+	fmt.Printf("storage: %s\n", quotaUsage(quota.Size, quota.StorageLimit, "bytes"))
+	fmt.Printf("messages: %s\n", quotaUsage(quota.Count, quota.MessageLimit, "messages"))
+	fmt.Printf("lines: %d\n", quota.Lines)
+	return nil
+}
+
+func quotaUsage(value, limit int64, unit string) string {
+	if limit <= 0 {
+		return fmt.Sprintf("%d %s (unlimited)", value, unit)
+	}
+	return fmt.Sprintf("%d of %d %s (%d%%)", value, limit, unit, value*100/limit)
+}
+
+func RecalcQuotaFile(args []string) error {
+	root := MaildirRoot(args)
+	quota, err := RecalcQuota(root, viper.GetString("quota.definition"))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d bytes in %d messages\n", filepath.Join(root, MaildirsizeFile), quota.Size, quota.Count)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(quotaCmd)
+	quotaCmd.AddCommand(quotaShowCmd)
+	quotaCmd.AddCommand(quotaRecalcCmd)
+	quotaRecalcCmd.Flags().String("definition", "", "quota definition line")
+	viper.BindPFlag("quota.definition", quotaRecalcCmd.Flags().Lookup("definition"))
+}
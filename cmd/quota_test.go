@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadMaildirsize(t *testing.T) {
+	root := makeTestMaildir(t)
+	require.Nil(t, os.WriteFile(filepath.Join(root, MaildirsizeFile), []byte("1000000S,1000C\n500 3\n-100 -1\n20 1\n"), 0600))
+	quota, err := ReadMaildirsize(root)
+	require.Nil(t, err)
+	require.Equal(t, int64(1000000), quota.StorageLimit)
+	require.Equal(t, int64(1000), quota.MessageLimit)
+	require.Equal(t, int64(420), quota.Size)
+	require.Equal(t, int64(3), quota.Count)
+	require.Equal(t, 3, quota.Lines)
+
+	_, _, err = ParseQuotaDefinition("100X")
+	require.NotNil(t, err)
+}
+
+func TestRecalcQuota(t *testing.T) {
+	root := makeTestMaildir(t)
+	_, err := RecalcQuota(root, "")
+	require.NotNil(t, err)
+
+	quota, err := RecalcQuota(root, "2000S")
+	require.Nil(t, err)
+	require.Equal(t, int64(3), quota.Count)
+	require.Equal(t, int64(170+165+159), quota.Size)
+
+	data, err := os.ReadFile(filepath.Join(root, MaildirsizeFile))
+	require.Nil(t, err)
+	require.Equal(t, "2000S\n494 3\n", string(data))
+
+	require.Nil(t, AppendQuotaDelta(root, -165, -1))
+	viper.Set("skip-quota", true)
+	require.Nil(t, AppendQuotaDelta(root, -170, -1))
+	quota, err = ReadMaildirsize(root)
+	require.Nil(t, err)
+	require.Equal(t, int64(329), quota.Size)
+	require.Equal(t, int64(2), quota.Count)
+}
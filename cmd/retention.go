@@ -0,0 +1,421 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// retention age bases
+const (
+	RetentionDelivered = "delivered"
+	RetentionDate      = "date"
+	RetentionMtime     = "mtime"
+)
+
+// retention actions
+const (
+	RetentionDelete = "delete"
+	RetentionMove   = "move"
+	RetentionExport = "export"
+)
+
+// retentionCmd represents the retention command
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "expire messages by folder retention policy",
+	Long: `
+Expire old messages according to the retention policy of the config file.
+The policy is a list of rules under retention.rules; the first rule with a
+folder pattern matching a folder applies to it, and folders matching no rule
+keep their messages forever:
+
+    retention:
+      audit-log: /var/log/maildir-retention.log
+      rules:
+        - folders: [Trash, "Trash/*"]
+          days: 30
+        - folders: [Junk]
+          days: 14
+          basis: date
+          action: export
+          export: /var/backups/junk
+        - folders: ["Lists/*"]
+          days: 365
+          action: move
+          target: Archive/Lists
+
+Folder patterns are shell globs of folder names with '/' separators.  The
+age basis is the delivery time from the filename (delivered, the default),
+the Date header (date) or the file mod time (mtime).  The action deletes
+expired messages (delete, the default), moves them to the target folder,
+which is created if necessary (move), or appends them to FOLDER.mbox in the
+export directory and then deletes them (export).
+`,
+}
+
+// retentionApplyCmd represents the retention apply command
+var retentionApplyCmd = &cobra.Command{
+	Use:   "apply [DIR]",
+	Short: "apply the retention policy to a root maildir",
+	Long: `
+Apply the retention policy of the config file to every folder of the root
+maildir DIR.  The default DIR is ~/Maildir.  Messages in both cur and new
+are expired, and a message whose age cannot be read is logged and skipped.
+Each expired message is reported, and each action taken is appended to the
+audit log with the time, action, folder, uid, message age and rule.  Removed
+messages are dropped from dovecot-uidlist and maildirsize.
+
+Flags:
+    --audit-log PATH	    append actions to PATH (retention.audit-log)
+    --dry-run		    report expired messages without changing them
+    --reset-index MODE	    remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ApplyRetention(args))
+	},
+}
+
+// RetentionRule expires the messages of matching folders
+type RetentionRule struct {
+	Folders []string `mapstructure:"folders"`
+	Days    int      `mapstructure:"days"`
+	Basis   string   `mapstructure:"basis"`
+	Action  string   `mapstructure:"action"`
+	Target  string   `mapstructure:"target"`
+	Export  string   `mapstructure:"export"`
+}
+
+// ReadRetentionRules returns the validated retention rules of the config
+func ReadRetentionRules() ([]RetentionRule, error) {
+	rules := []RetentionRule{}
+	err := viper.UnmarshalKey("retention.rules", &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retention policy: %v", err)
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Basis == "" {
+			rule.Basis = RetentionDelivered
+		}
+		if rule.Action == "" {
+			rule.Action = RetentionDelete
+		}
+		err = rule.validate()
+		if err != nil {
+			return nil, fmt.Errorf("retention rule %d: %v", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *RetentionRule) validate() error {
+	if len(r.Folders) == 0 {
+		return fmt.Errorf("no folders")
+	}
+	for _, pattern := range r.Folders {
+		_, err := filepath.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid folder pattern: %s", pattern)
+		}
+	}
+	if r.Days <= 0 {
+		return fmt.Errorf("days must be positive")
+	}
+	switch r.Basis {
+	case RetentionDelivered, RetentionDate, RetentionMtime:
+	default:
+		return fmt.Errorf("unknown age basis: %s", r.Basis)
+	}
+	switch r.Action {
+	case RetentionDelete:
+	case RetentionMove:
+		if r.Target == "" {
+			return fmt.Errorf("move requires a target folder")
+		}
+	case RetentionExport:
+		if r.Export == "" {
+			return fmt.Errorf("export requires an export directory")
+		}
+	default:
+		return fmt.Errorf("unknown action: %s", r.Action)
+	}
+	return nil
+}
+
+// Matches returns true if a folder matches one of the rule's patterns
+func (r *RetentionRule) Matches(folder string) bool {
+	for _, pattern := range r.Folders {
+		if MatchFolder(pattern, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RetentionRule) String() string {
+	return fmt.Sprintf("%s %dd %s %s", strings.Join(r.Folders, ","), r.Days, r.Basis, r.Action)
+}
+
+// Age returns the time a message is aged from under the rule
+func (r *RetentionRule) Age(msg *Message) (time.Time, error) {
+	return MessageAge(msg, r.Basis)
+}
+
+// MessageAge returns the delivery time, Date header time or file mod time of
+// a message for the delivered, date and mtime age bases
+func MessageAge(msg *Message, basis string) (time.Time, error) {
+	switch basis {
+	case RetentionDate:
+		return msg.Date()
+	case RetentionMtime:
+		stat, err := msg.Stat()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return stat.ModTime(), nil
+	}
+	return msg.Received()
+}
+
+// retentionRule returns the first rule matching a folder
+func retentionRule(rules []RetentionRule, folder string) (*RetentionRule, bool) {
+	for i := range rules {
+		if rules[i].Matches(folder) {
+			return &rules[i], true
+		}
+	}
+	return nil, false
+}
+
+// retention applies rules to the messages of a root maildir
+type retention struct {
+	root   string
+	mover  *MessageMover
+	audit  *os.File
+	dryRun bool
+	count  int
+}
+
+// auditf appends a timestamped line to the audit log, if any
+func (r *retention) auditf(format string, args ...any) error {
+	if r.audit == nil {
+		return nil
+	}
+	line := time.Now().Format(time.RFC3339) + " " + fmt.Sprintf(format, args...) + "\n"
+	_, err := r.audit.WriteString(line)
+	if err != nil {
+		return fmt.Errorf("failed writing audit log: %v", err)
+	}
+	return nil
+}
+
+// export appends expired messages to the FOLDER.mbox file of the export directory
+func (r *retention) export(rule *RetentionRule, folder string, messages []*Message) error {
+	pathName := filepath.Join(rule.Export, filepath.FromSlash(folder)+".mbox")
+	err := os.MkdirAll(filepath.Dir(pathName), 0700)
+	if err != nil {
+		return fmt.Errorf("failed creating export directory: %v", err)
+	}
+	file, err := os.OpenFile(pathName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed opening mbox: %v", err)
+	}
+	writer := NewMboxWriter(file)
+	for _, msg := range messages {
+		data, _, err := ReadMessage(msg.File.Path)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		received, err := msg.Received()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		err = writer.WriteMessage(data, received, msg.File.Flags)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("failed closing mbox: %v", err)
+	}
+	fmt.Printf("exported %d messages to %s\n", len(messages), pathName)
+	return nil
+}
+
+// apply expires the messages of a folder which are older than the rule allows
+func (r *retention) apply(rule *RetentionRule, folder Folder, now time.Time) error {
+	cutoff := now.Add(-time.Duration(rule.Days) * 24 * time.Hour)
+	uidlist, err := ReadUidlist(folder.Path)
+	if err != nil {
+		return err
+	}
+	// unread mail in new is expired too, as in a Junk folder nobody opens
+	files, err := ListMessageFiles(folder.Path)
+	if err != nil {
+		return err
+	}
+	expired := []*Message{}
+	ages := map[*Message]time.Time{}
+	for _, pathName := range files {
+		msg, err := NewMessage(folder.Path, folder.Name, uidlist, pathName)
+		if err != nil {
+			return err
+		}
+		age, err := rule.Age(msg)
+		if err != nil {
+			log.Printf("skipped %s: %v\n", pathName, err)
+			continue
+		}
+		if age.Before(cutoff) {
+			expired = append(expired, msg)
+			ages[msg] = age
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	fmt.Printf("%s: %d messages expired by rule %s\n", folder.Name, len(expired), rule)
+	r.count += len(expired)
+	if r.dryRun {
+		for _, msg := range expired {
+			fmt.Printf("  would %s %s\n", rule.Action, msg.File.Path)
+		}
+		return nil
+	}
+	if rule.Action == RetentionExport {
+		err = r.export(rule, folder.Name, expired)
+		if err != nil {
+			return err
+		}
+	}
+	var target string
+	if rule.Action == RetentionMove {
+		target = FolderPath(r.root, rule.Target)
+		if target == folder.Path {
+			return fmt.Errorf("retention target is the expired folder: %s", rule.Target)
+		}
+		err = MakeMaildir(r.root, target)
+		if err != nil {
+			return err
+		}
+	}
+	for _, msg := range expired {
+		detail := msg.File.Path
+		if rule.Action == RetentionMove {
+			detail, err = r.mover.Move(msg, target)
+			detail = msg.File.Path + " -> " + detail
+		} else {
+			err = r.mover.Remove(msg)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %s %s\n", rule.Action, detail)
+		err = r.auditf("%s folder=%s uid=%d age=%s rule=[%s] %s", rule.Action, folder.Name, msg.Uid, ages[msg].Format(time.RFC3339), rule, detail)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ApplyRetention(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	rules, err := ReadRetentionRules()
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("no retention rules configured")
+	}
+	root := MaildirRoot(args)
+	folders, err := ListFolders(root)
+	if err != nil {
+		return err
+	}
+	viper.Set("all", true)
+	r := retention{root: root, mover: NewMessageMover(root), dryRun: dryRun}
+	auditLog := viper.GetString("retention.audit-log")
+	if auditLog != "" && !dryRun {
+		r.audit, err = os.OpenFile(auditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed opening audit log: %v", err)
+		}
+		defer r.audit.Close()
+	}
+	now := time.Now()
+	for _, folder := range folders {
+		rule, ok := retentionRule(rules, folder.Name)
+		if !ok {
+			continue
+		}
+		err = r.apply(rule, folder, now)
+		if err != nil {
+			r.mover.Close()
+			return err
+		}
+	}
+	if dryRun {
+		fmt.Printf("%d messages would expire\n", r.count)
+		return nil
+	}
+	err = r.mover.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d messages expired\n", r.count)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(retentionCmd)
+	retentionCmd.AddCommand(retentionApplyCmd)
+	retentionApplyCmd.Flags().String("audit-log", "", "append retention actions to this file")
+	viper.BindPFlag("retention.audit-log", retentionApplyCmd.Flags().Lookup("audit-log"))
+}
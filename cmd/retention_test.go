@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRetentionPolicy = `
+retention:
+  rules:
+    - folders: [INBOX]
+      days: 30
+      action: move
+      target: Expired
+    - folders: [Archive]
+      days: 30
+      basis: date
+      action: export
+      export: %s
+`
+
+func TestApplyRetention(t *testing.T) {
+	root := makeTestMaildir(t)
+	recent := fmt.Sprintf("%d.M5P1.host", time.Now().Unix())
+	writeTestMessage(t, root, recent, "", testMessage("carol@example.com", "today", time.Now().Format(time.RFC1123Z), "fresh"), "")
+	unread := filepath.Join(root, "new", "1700000000.M6P1.host")
+	require.Nil(t, os.WriteFile(unread, testMessage("dave@example.com", "unread", "Tue, 14 Nov 2023 22:13:20 +0000", "never opened"), 0600))
+	exportDir := filepath.Join(t.TempDir(), "export")
+	viper.SetConfigType("yaml")
+	require.Nil(t, viper.ReadConfig(bytes.NewBufferString(fmt.Sprintf(testRetentionPolicy, exportDir))))
+	rules, err := ReadRetentionRules()
+	require.Nil(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, RetentionDelivered, rules[0].Basis)
+	require.True(t, rules[1].Matches("Archive"))
+
+	viper.Set("dry-run", true)
+	require.Nil(t, ApplyRetention([]string{root}))
+	findTestMessage(t, root, "1709251200.M1P1.host")
+
+	viper.Set("dry-run", false)
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	viper.Set("retention.audit-log", auditLog)
+	require.Nil(t, ApplyRetention([]string{root}))
+	files, err := filepath.Glob(filepath.Join(root, "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	require.Contains(t, files[0], recent)
+	require.NoFileExists(t, unread)
+	files, err = filepath.Glob(filepath.Join(root, ".Expired", "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 3)
+	files, err = filepath.Glob(filepath.Join(root, ".Archive", "cur", "*"))
+	require.Nil(t, err)
+	require.Empty(t, files)
+	mbox, err := os.ReadFile(filepath.Join(exportDir, "Archive.mbox"))
+	require.Nil(t, err)
+	require.Contains(t, string(mbox), "Subject: new year")
+	audit, err := os.ReadFile(auditLog)
+	require.Nil(t, err)
+	require.Equal(t, 4, strings.Count(string(audit), "\n"))
+	require.Contains(t, string(audit), "export folder=Archive")
+
+	viper.Set("retention.rules", []map[string]any{{"folders": []string{"Junk"}, "days": 14, "action": "move"}})
+	_, err = ReadRetentionRules()
+	require.NotNil(t, err)
+}
@@ -87,6 +87,30 @@ func init() {
 	rootCmd.PersistentFlags().BoolP("uncompressed", "u", false, "list uncompresed files")
 	viper.BindPFlag("uncompressed", rootCmd.PersistentFlags().Lookup("uncompressed"))
 
+	rootCmd.PersistentFlags().BoolP("dry-run", "n", false, "report changes without modifying files")
+	viper.BindPFlag("dry-run", rootCmd.PersistentFlags().Lookup("dry-run"))
+
+	rootCmd.PersistentFlags().Bool("skip-quota", false, "do not update maildirsize when messages change")
+	viper.BindPFlag("skip-quota", rootCmd.PersistentFlags().Lookup("skip-quota"))
+
+	rootCmd.PersistentFlags().StringP("folder", "f", "", "select folder by name instead of the root maildir")
+	viper.BindPFlag("folder", rootCmd.PersistentFlags().Lookup("folder"))
+
+	rootCmd.PersistentFlags().String("layout", "maildir++", "maildir folder layout: maildir++ or fs")
+	viper.BindPFlag("layout", rootCmd.PersistentFlags().Lookup("layout"))
+
+	rootCmd.PersistentFlags().Bool("utf8", false, "folder names are stored as UTF-8, not modified UTF-7")
+	viper.BindPFlag("utf8", rootCmd.PersistentFlags().Lookup("utf8"))
+
+	rootCmd.PersistentFlags().String("reset-index", "", "remove dovecot index files of changed maildirs: cache or all")
+	viper.BindPFlag("reset-index", rootCmd.PersistentFlags().Lookup("reset-index"))
+
+	rootCmd.PersistentFlags().Bool("cache", false, "skip rescanning unchanged message files using a per-maildir scan cache")
+	viper.BindPFlag("cache", rootCmd.PersistentFlags().Lookup("cache"))
+
+	rootCmd.PersistentFlags().Bool("rescan", false, "rebuild the scan cache by examining every message file")
+	viper.BindPFlag("rescan", rootCmd.PersistentFlags().Lookup("rescan"))
+
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -111,4 +135,6 @@ func initConfig() {
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
+
+	cobra.CheckErr(ValidateIndexReset(viper.GetString("reset-index")))
 }
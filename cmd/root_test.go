@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -19,3 +26,54 @@ func TestInit(t *testing.T) {
 	run(t, "rm", "-rf", "testdata/Maildir")
 	run(t, "cp", "-rp", "testdata/src", "testdata/Maildir")
 }
+
+// testMessage returns a minimal rfc822 message
+func testMessage(from, subject, date, body string) []byte {
+	return []byte(fmt.Sprintf("From: %s\r\nTo: user@example.com\r\nSubject: %s\r\nDate: %s\r\nMessage-ID: <%s@example.com>\r\n\r\n%s\r\n", from, subject, date, strings.ReplaceAll(subject, " ", "."), body))
+}
+
+// writeTestMessage writes a message into the cur subdirectory of a maildir,
+// compressing it with codec unless codec is empty, and returns the pathname
+func writeTestMessage(t *testing.T, dir, base, flags string, data []byte, codec string) string {
+	lines := int64(bytes.Count(data, []byte("\n")))
+	name := fmt.Sprintf("%s,S=%d,W=%d:2,%s", base, len(data), int64(len(data))+lines, flags)
+	pathName := filepath.Join(dir, "cur", name)
+	var buf bytes.Buffer
+	switch codec {
+	case "":
+		buf.Write(data)
+	case "gzip":
+		writer := gzip.NewWriter(&buf)
+		_, err := writer.Write(data)
+		require.Nil(t, err)
+		require.Nil(t, writer.Close())
+	case "zstd":
+		writer, err := zstd.NewWriter(&buf)
+		require.Nil(t, err)
+		_, err = writer.Write(data)
+		require.Nil(t, err)
+		require.Nil(t, writer.Close())
+	default:
+		t.Fatalf("unsupported codec: %s", codec)
+	}
+	require.Nil(t, os.WriteFile(pathName, buf.Bytes(), 0600))
+	return pathName
+}
+
+// makeTestMaildir creates a maildir with a subfolder in a temporary directory
+func makeTestMaildir(t *testing.T) string {
+	root := filepath.Join(t.TempDir(), "Maildir")
+	for _, dir := range []string{root, filepath.Join(root, ".Archive")} {
+		for _, sub := range []string{"cur", "new", "tmp"} {
+			require.Nil(t, os.MkdirAll(filepath.Join(dir, sub), 0700))
+		}
+	}
+	writeTestMessage(t, root, "1709251200.M1P1.host", "S", testMessage("alice@example.com", "march report", "Fri, 01 Mar 2024 10:00:00 +0000", "quarterly figures"), "zstd")
+	writeTestMessage(t, root, "1711929600.M2P1.host", "", testMessage("bob@example.com", "april plans", "Mon, 01 Apr 2024 10:00:00 +0000", "holiday schedule"), "")
+	writeTestMessage(t, filepath.Join(root, ".Archive"), "1704067200.M3P1.host", "FS", testMessage("alice@example.com", "new year", "Mon, 01 Jan 2024 10:00:00 +0000", "happy new year"), "gzip")
+	uidlist := "3 V1700000000 N3 Gabcdef\n1 :1709251200.M1P1.host\n2 :1711929600.M2P1.host\n"
+	require.Nil(t, os.WriteFile(filepath.Join(root, UidlistFile), []byte(uidlist), 0600))
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	return root
+}
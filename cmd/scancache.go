@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const ScanCacheFile = "dovecot-maildir-cache"
+
+const scanCacheHeader = "dovecot-maildir-cache 1"
+
+// ScanCacheEntry records what was found in a message file of a given file
+// size and mod time: its compression and its uncompressed S= and W= sizes
+type ScanCacheEntry struct {
+	Mtime       int64
+	FileSize    int64
+	Compression string
+	Size        int64
+	SizeW       int64
+}
+
+// Verified returns true if the S= and W= values of the filename, where
+// present, match the sizes measured when the file was scanned
+func (e *ScanCacheEntry) Verified(file *MessageFile) bool {
+	if file.Size > 0 && file.Size != e.Size {
+		return false
+	}
+	if file.SizeW > 0 && file.SizeW != e.SizeW {
+		return false
+	}
+	return true
+}
+
+// ScanCache holds the scan results for the cur messages of a maildir, keyed
+// by filename.  Maildir filenames are never reused, so an entry whose file
+// size and mod time still match can be trusted without opening the file.
+type ScanCache struct {
+	Entries map[string]ScanCacheEntry
+	changed bool
+}
+
+// ReadScanCache reads the scan cache file of a maildir, returning an empty
+// cache if there is none
+func ReadScanCache(dir string) (*ScanCache, error) {
+	cache := ScanCache{Entries: map[string]ScanCacheEntry{}}
+	pathName := filepath.Join(dir, ScanCacheFile)
+	file, err := os.Open(pathName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cache, nil
+		}
+		return nil, fmt.Errorf("failed reading scan cache: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() || scanner.Text() != scanCacheHeader {
+		// an unknown or truncated cache is rebuilt
+		cache.changed = true
+		return &cache, nil
+	}
+	for scanner.Scan() {
+		entry, filename, ok := parseScanCacheLine(scanner.Text())
+		if !ok {
+			// a corrupt cache is rebuilt like an unknown one
+			return &ScanCache{Entries: map[string]ScanCacheEntry{}, changed: true}, nil
+		}
+		cache.Entries[filename] = entry
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading scan cache: %v", err)
+	}
+	return &cache, nil
+}
+
+// parseScanCacheLine parses a cache line of the file mod time and size, the
+// compression, the S= and W= sizes and the filename
+func parseScanCacheLine(line string) (ScanCacheEntry, string, bool) {
+	fields := strings.SplitN(line, " ", 6)
+	if len(fields) != 6 || fields[5] == "" {
+		return ScanCacheEntry{}, "", false
+	}
+	values := make([]int64, 4)
+	for i, field := range []string{fields[0], fields[1], fields[3], fields[4]} {
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return ScanCacheEntry{}, "", false
+		}
+		values[i] = value
+	}
+	entry := ScanCacheEntry{Mtime: values[0], FileSize: values[1], Size: values[2], SizeW: values[3]}
+	if fields[2] != "-" {
+		entry.Compression = fields[2]
+	}
+	return entry, fields[5], true
+}
+
+// Lookup returns the entry for a filename if its file size and mod time
+// match info
+func (c *ScanCache) Lookup(filename string, info fs.FileInfo) (*ScanCacheEntry, bool) {
+	entry, ok := c.Entries[filename]
+	if !ok || entry.Mtime != info.ModTime().UnixNano() || entry.FileSize != info.Size() {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// Scan reads a message file to detect its compression and measure its
+// uncompressed sizes, storing the result in the cache
+func (c *ScanCache) Scan(pathName string, info fs.FileInfo) (*ScanCacheEntry, error) {
+	reader, cmpType, err := OpenMessage(pathName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	size, sizeW, err := measureMessage(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading message %s: %v", pathName, err)
+	}
+	entry := ScanCacheEntry{
+		Mtime:       info.ModTime().UnixNano(),
+		FileSize:    info.Size(),
+		Compression: cmpType,
+		Size:        size,
+		SizeW:       sizeW,
+	}
+	c.Entries[filepath.Base(pathName)] = entry
+	c.changed = true
+	return &entry, nil
+}
+
+// Prune removes the entries for filenames not in present
+func (c *ScanCache) Prune(present map[string]bool) {
+	for filename := range c.Entries {
+		if !present[filename] {
+			delete(c.Entries, filename)
+			c.changed = true
+		}
+	}
+}
+
+// Write replaces the scan cache file of a maildir if the cache has changed
+func (c *ScanCache) Write(dir string) error {
+	if !c.changed {
+		return nil
+	}
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	pathName := filepath.Join(dir, ScanCacheFile)
+	tmpPath := fmt.Sprintf("%s.%d", pathName, os.Getpid())
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing scan cache: %v", err)
+	}
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "%s\n", scanCacheHeader)
+	for filename, entry := range c.Entries {
+		cmpType := entry.Compression
+		if cmpType == "" {
+			cmpType = "-"
+		}
+		fmt.Fprintf(writer, "%d %d %s %d %d %s\n", entry.Mtime, entry.FileSize, cmpType, entry.Size, entry.SizeW, filename)
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed writing scan cache: %v", err)
+	}
+	err = SetOwner(tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, pathName)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed replacing scan cache: %v", err)
+	}
+	c.changed = false
+	return nil
+}
+
+// measureMessage returns the size and the CRLF size of message data the way
+// MessageSizes does, without holding the whole message in memory
+func measureMessage(reader io.Reader) (int64, int64, error) {
+	var size, lines int64
+	var last byte
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			size += int64(n)
+			lines += int64(bytes.Count(buf[:n], []byte("\n")))
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if size > 0 && last != '\n' {
+		lines += 1
+	}
+	return size, size + lines, nil
+}
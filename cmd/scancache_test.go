@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanCache(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("cache", true)
+	compressed := findTestMessage(t, root, "1709251200.M1P1.host")
+	plain := findTestMessage(t, root, "1711929600.M2P1.host")
+
+	files, err := ListMaildirFiles(root)
+	require.Nil(t, err)
+	require.Equal(t, []string{compressed}, *files)
+	cache, err := ReadScanCache(root)
+	require.Nil(t, err)
+	require.Len(t, cache.Entries, 2)
+	entry := cache.Entries[filepath.Base(compressed)]
+	require.Equal(t, "zstd", entry.Compression)
+	file, err := ParseMessageFile(compressed)
+	require.Nil(t, err)
+	require.True(t, entry.Verified(file))
+	require.Equal(t, file.Size, entry.Size)
+	require.Equal(t, file.SizeW, entry.SizeW)
+	require.Empty(t, cache.Entries[filepath.Base(plain)].Compression)
+
+	// unchanged files are taken from the cache without being opened
+	entry = cache.Entries[filepath.Base(plain)]
+	entry.Compression = "gzip"
+	cache.Entries[filepath.Base(plain)] = entry
+	cache.changed = true
+	require.Nil(t, cache.Write(root))
+	files, err = ListMaildirFiles(root)
+	require.Nil(t, err)
+	require.Len(t, *files, 2)
+
+	// --rescan examines every file again
+	viper.Set("rescan", true)
+	files, err = ListMaildirFiles(root)
+	require.Nil(t, err)
+	require.Equal(t, []string{compressed}, *files)
+	viper.Set("rescan", false)
+
+	// changed files are rescanned and removed files are pruned
+	done, err := CompressMessageFile(plain, "gzip")
+	require.Nil(t, err)
+	require.True(t, done)
+	require.Nil(t, os.Remove(compressed))
+	files, err = ListMaildirFiles(root)
+	require.Nil(t, err)
+	require.Equal(t, []string{plain}, *files)
+	cache, err = ReadScanCache(root)
+	require.Nil(t, err)
+	require.Len(t, cache.Entries, 1)
+	require.Equal(t, "gzip", cache.Entries[filepath.Base(plain)].Compression)
+}
+
+func TestScanCacheInvalidLine(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("cache", true)
+	compressed := findTestMessage(t, root, "1709251200.M1P1.host")
+
+	cacheFile := filepath.Join(root, ScanCacheFile)
+	require.Nil(t, os.WriteFile(cacheFile, []byte(scanCacheHeader+"\nnot a cache line\n"), 0600))
+	cache, err := ReadScanCache(root)
+	require.Nil(t, err)
+	require.Empty(t, cache.Entries)
+
+	// the corrupt cache is rebuilt instead of failing the listing
+	files, err := ListMaildirFiles(root)
+	require.Nil(t, err)
+	require.Equal(t, []string{compressed}, *files)
+	cache, err = ReadScanCache(root)
+	require.Nil(t, err)
+	require.Len(t, cache.Entries, 2)
+}
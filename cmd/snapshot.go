@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// dovecot and Maildir++ metadata files saved with each maildir; the index
+// files are omitted because dovecot rebuilds them
+var metadataFiles []string = []string{UidlistFile, "dovecot-keywords", "subscriptions", "maildirfolder", "maildirsize"}
+
+// Snapshot is the manifest of a backup run
+type Snapshot struct {
+	Created  time.Time         `json:"created"`
+	Root     string            `json:"root"`
+	Maildirs []SnapshotMaildir `json:"maildirs"`
+	Renames  []SnapshotRename  `json:"renames"`
+	index    map[string]*SnapshotFile
+}
+
+// SnapshotMaildir records a maildir directory and its files
+type SnapshotMaildir struct {
+	Path  string         `json:"path"`
+	Mode  uint32         `json:"mode"`
+	Uid   uint32         `json:"uid"`
+	Gid   uint32         `json:"gid"`
+	Files []SnapshotFile `json:"files"`
+}
+
+// SnapshotFile records a file, its ownership and the hash of its content
+type SnapshotFile struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	Mode    uint32    `json:"mode"`
+	Uid     uint32    `json:"uid"`
+	Gid     uint32    `json:"gid"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// SnapshotRename records a message whose flags changed since the previous snapshot
+type SnapshotRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func newSnapshotFile(name string, stat fs.FileInfo) SnapshotFile {
+	sys := stat.Sys().(*syscall.Stat_t)
+	return SnapshotFile{
+		Name:    name,
+		Size:    stat.Size(),
+		Mode:    uint32(stat.Mode()),
+		Uid:     sys.Uid,
+		Gid:     sys.Gid,
+		ModTime: stat.ModTime(),
+	}
+}
+
+// FileInfo returns the recorded attributes in a form accepted by SetStat
+func (f *SnapshotFile) FileInfo() fs.FileInfo {
+	return &snapshotFileInfo{name: filepath.Base(f.Name), size: f.Size, mode: fs.FileMode(f.Mode), modTime: f.ModTime, uid: f.Uid, gid: f.Gid}
+}
+
+type snapshotFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	uid     uint32
+	gid     uint32
+}
+
+func (i *snapshotFileInfo) Name() string       { return i.name }
+func (i *snapshotFileInfo) Size() int64        { return i.size }
+func (i *snapshotFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *snapshotFileInfo) ModTime() time.Time { return i.modTime }
+func (i *snapshotFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *snapshotFileInfo) Sys() any           { return &syscall.Stat_t{Uid: i.uid, Gid: i.gid} }
+
+// ReadSnapshot reads a snapshot manifest
+func ReadSnapshot(pathName string) (*Snapshot, error) {
+	data, err := os.ReadFile(pathName)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading snapshot: %v", err)
+	}
+	snapshot := Snapshot{}
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing snapshot %s: %v", pathName, err)
+	}
+	snapshot.buildIndex()
+	return &snapshot, nil
+}
+
+func (s *Snapshot) buildIndex() {
+	s.index = map[string]*SnapshotFile{}
+	for i := range s.Maildirs {
+		maildir := &s.Maildirs[i]
+		for j := range maildir.Files {
+			file := &maildir.Files[j]
+			name := filepath.Join(maildir.Path, file.Name)
+			s.index[name] = file
+			// messages are also indexed by their name without the info suffix
+			base, _, found := strings.Cut(name, ":")
+			if found {
+				s.index[base] = file
+			}
+		}
+	}
+}
+
+// lookup returns the recorded file with a matching pathname and true, or else
+// a message with the same name ignoring its flags and false
+func (s *Snapshot) lookup(name string) (*SnapshotFile, bool) {
+	if s == nil {
+		return nil, false
+	}
+	file, ok := s.index[name]
+	if ok {
+		return file, true
+	}
+	base, _, found := strings.Cut(name, ":")
+	if found {
+		file, ok = s.index[base]
+		if ok {
+			return file, false
+		}
+	}
+	return nil, false
+}
+
+// Write saves the snapshot manifest into the snapshots directory of the backup
+func (s *Snapshot) Write(dest string) (string, error) {
+	dir := filepath.Join(dest, "snapshots")
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed creating snapshot directory: %v", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed formatting snapshot: %v", err)
+	}
+	pathName := filepath.Join(dir, s.Created.UTC().Format("20060102T150405.000Z")+".json")
+	err = os.WriteFile(pathName+".tmp", data, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed writing snapshot: %v", err)
+	}
+	err = os.Rename(pathName+".tmp", pathName)
+	if err != nil {
+		return "", fmt.Errorf("failed writing snapshot: %v", err)
+	}
+	return pathName, nil
+}
+
+// LatestSnapshot returns the pathname of the newest snapshot in a backup, or
+// an empty string if there are none
+func LatestSnapshot(dest string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(dest, "snapshots"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("ReadDir failed: %v", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	return filepath.Join(dest, "snapshots", names[len(names)-1]), nil
+}
+
+// ObjectPath returns the pathname of a content object in a backup
+func ObjectPath(dest, hash string) string {
+	return filepath.Join(dest, "objects", hash[:2], hash)
+}
+
+// StoreObject copies a file into the object store of a backup unless an
+// object with the same hash exists, returning the hash and whether it was copied
+func StoreObject(dest, pathName string) (string, bool, error) {
+	data, err := os.ReadFile(pathName)
+	if err != nil {
+		return "", false, fmt.Errorf("failed reading %s: %v", pathName, err)
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	objectPath := ObjectPath(dest, hash)
+	_, err = os.Stat(objectPath)
+	if err == nil {
+		return hash, false, nil
+	}
+	err = os.MkdirAll(filepath.Dir(objectPath), 0700)
+	if err != nil {
+		return "", false, fmt.Errorf("failed creating object directory: %v", err)
+	}
+	err = os.WriteFile(objectPath+".tmp", data, 0600)
+	if err != nil {
+		return "", false, fmt.Errorf("failed writing object: %v", err)
+	}
+	err = os.Rename(objectPath+".tmp", objectPath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed writing object: %v", err)
+	}
+	return hash, true, nil
+}
+
+// RestoreObject copies a content object through tmpPath to pathName,
+// verifying its hash before renaming it into place
+func RestoreObject(dest, hash, tmpPath, pathName string) error {
+	file, err := os.Open(ObjectPath(dest, hash))
+	if err != nil {
+		return fmt.Errorf("failed opening object: %v", err)
+	}
+	defer file.Close()
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed creating %s: %v", tmpPath, err)
+	}
+	digest := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, digest), file)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed restoring %s: %v", pathName, err)
+	}
+	if fmt.Sprintf("%x", digest.Sum(nil)) != hash {
+		os.Remove(tmpPath)
+		return fmt.Errorf("object hash mismatch: %s", hash)
+	}
+	err = os.Rename(tmpPath, pathName)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed restoring %s: %v", pathName, err)
+	}
+	return nil
+}
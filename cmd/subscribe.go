@@ -0,0 +1,218 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// subscriptionsCmd represents the subscriptions command
+var subscriptionsCmd = &cobra.Command{
+	Use:   "subscriptions",
+	Short: "manage the dovecot subscriptions file",
+	Long: `
+Manage the folder subscriptions of the root maildir DIR.  The default DIR is
+~/Maildir.  Both the version 2 tab-separated subscriptions file and the
+legacy one-name-per-line file are read, and the file is written back in the
+version it was read in.
+`,
+}
+
+// subscriptionsListCmd represents the subscriptions list command
+var subscriptionsListCmd = &cobra.Command{
+	Use:   "list [DIR]",
+	Short: "list subscribed folders",
+	Args:  cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ListSubscriptions(args))
+	},
+}
+
+// subscriptionsAddCmd represents the subscriptions add command
+var subscriptionsAddCmd = &cobra.Command{
+	Use:   "add NAME [DIR]",
+	Short: "subscribe to a folder",
+	Long: `
+Subscribe to the existing folder NAME of the root maildir DIR.
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(AddSubscription(args))
+	},
+}
+
+// subscriptionsRemoveCmd represents the subscriptions remove command
+var subscriptionsRemoveCmd = &cobra.Command{
+	Use:   "remove NAME [DIR]",
+	Short: "unsubscribe from a folder",
+	Long: `
+Unsubscribe from the folder NAME of the root maildir DIR.  The folder need
+not exist.  Use --recurse to also unsubscribe from its child folders.
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(RemoveSubscription(args))
+	},
+}
+
+// subscriptionsCheckCmd represents the subscriptions check command
+var subscriptionsCheckCmd = &cobra.Command{
+	Use:   "check [DIR]",
+	Short: "report broken subscriptions and unsubscribed folders",
+	Long: `
+Report subscriptions naming folders which do not exist in the root maildir
+DIR, and folders which exist but are not subscribed.
+
+Flags:
+    --fix	remove broken subscriptions and subscribe to unsubscribed folders
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(CheckSubscriptions(args))
+	},
+}
+
+func readRootSubscriptions(root string) (*Subscriptions, string, error) {
+	layout, err := openRootMaildir(root)
+	if err != nil {
+		return nil, "", err
+	}
+	subscriptions, err := ReadSubscriptions(root, layout)
+	if err != nil {
+		return nil, "", err
+	}
+	return subscriptions, layout, nil
+}
+
+func ListSubscriptions(args []string) error {
+	subscriptions, _, err := readRootSubscriptions(MaildirRoot(args))
+	if err != nil {
+		return err
+	}
+	for _, name := range subscriptions.Names {
+		fmt.Println(DecodeFolderName(name))
+	}
+	return nil
+}
+
+func AddSubscription(args []string) error {
+	root := MaildirRoot(args[1:])
+	subscriptions, layout, err := readRootSubscriptions(root)
+	if err != nil {
+		return err
+	}
+	maildir, err := IsMaildir(FolderPath(root, args[0]))
+	if err != nil || !maildir {
+		return fmt.Errorf("folder not found: %s", args[0])
+	}
+	if !subscriptions.Add(EncodeFolderName(args[0])) {
+		return nil
+	}
+	return subscriptions.Write(root, layout)
+}
+
+func RemoveSubscription(args []string) error {
+	root := MaildirRoot(args[1:])
+	subscriptions, layout, err := readRootSubscriptions(root)
+	if err != nil {
+		return err
+	}
+	if subscriptions.Remove(EncodeFolderName(args[0]), viper.GetBool("recurse")) == 0 {
+		return fmt.Errorf("not subscribed: %s", args[0])
+	}
+	return subscriptions.Write(root, layout)
+}
+
+// SubscriptionProblems returns the subscribed names with no folder and the
+// folders which are not subscribed, both as encoded names
+func SubscriptionProblems(root string, subscriptions *Subscriptions) ([]string, []string, error) {
+	folders, err := ListFolders(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	exists := map[string]bool{}
+	unsubscribed := []string{}
+	for _, folder := range folders {
+		name := EncodeFolderName(folder.Name)
+		exists[name] = true
+		if !subscriptions.Contains(name) {
+			unsubscribed = append(unsubscribed, name)
+		}
+	}
+	broken := []string{}
+	for _, name := range subscriptions.Names {
+		if !exists[name] {
+			broken = append(broken, name)
+		}
+	}
+	return broken, unsubscribed, nil
+}
+
+func CheckSubscriptions(args []string) error {
+	fix := viper.GetBool("subscriptions.fix")
+	root := MaildirRoot(args)
+	subscriptions, layout, err := readRootSubscriptions(root)
+	if err != nil {
+		return err
+	}
+	broken, unsubscribed, err := SubscriptionProblems(root, subscriptions)
+	if err != nil {
+		return err
+	}
+	for _, name := range broken {
+		fmt.Printf("broken subscription: %s\n", DecodeFolderName(name))
+		if fix {
+			subscriptions.Remove(name, false)
+		}
+	}
+	for _, name := range unsubscribed {
+		fmt.Printf("unsubscribed folder: %s\n", DecodeFolderName(name))
+		if fix {
+			subscriptions.Add(name)
+		}
+	}
+	if fix && len(broken)+len(unsubscribed) > 0 {
+		return subscriptions.Write(root, layout)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(subscriptionsCmd)
+	subscriptionsCmd.AddCommand(subscriptionsListCmd)
+	subscriptionsCmd.AddCommand(subscriptionsAddCmd)
+	subscriptionsCmd.AddCommand(subscriptionsRemoveCmd)
+	subscriptionsCmd.AddCommand(subscriptionsCheckCmd)
+	subscriptionsCheckCmd.Flags().Bool("fix", false, "repair broken and missing subscriptions")
+	viper.BindPFlag("subscriptions.fix", subscriptionsCheckCmd.Flags().Lookup("fix"))
+}
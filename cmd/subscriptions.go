@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const SubscriptionsFile = "subscriptions"
+
+// Subscriptions holds the folder names of a dovecot subscriptions file with
+// '/' separating hierarchy levels; names are not decoded from modified UTF-7
+type Subscriptions struct {
+	Version int
+	Names   []string
+}
+
+// ReadSubscriptions reads the subscriptions file of a root maildir; legacy
+// version 1 files separate hierarchy levels with the layout separator and
+// version 2 files with tabs.  A missing file yields an empty version 2 list.
+func ReadSubscriptions(root, layout string) (*Subscriptions, error) {
+	subscriptions := Subscriptions{Version: 2, Names: []string{}}
+	file, err := os.Open(filepath.Join(root, SubscriptionsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &subscriptions, nil
+		}
+		return nil, fmt.Errorf("failed opening subscriptions: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	subscriptions.Version = 1
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber += 1
+		if lineNumber == 1 && strings.HasPrefix(line, "V\t") {
+			if strings.TrimPrefix(line, "V\t") != "2" {
+				return nil, fmt.Errorf("unsupported subscriptions version: %s", line)
+			}
+			subscriptions.Version = 2
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		var components []string
+		if subscriptions.Version == 2 {
+			components = strings.Split(line, "\t")
+			for i, component := range components {
+				components[i] = tabUnescape(component)
+			}
+		} else {
+			components = strings.Split(line, LayoutSeparator(layout))
+		}
+		subscriptions.Names = append(subscriptions.Names, strings.Join(components, "/"))
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading subscriptions: %v", err)
+	}
+	return &subscriptions, nil
+}
+
+// Write replaces the subscriptions file of a root maildir in the version it was read in
+func (s *Subscriptions) Write(root, layout string) error {
+	pathName := filepath.Join(root, SubscriptionsFile)
+	stat, err := os.Stat(pathName)
+	if os.IsNotExist(err) {
+		stat, err = os.Stat(root)
+	}
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	var content strings.Builder
+	if s.Version == 2 {
+		content.WriteString("V\t2\n\n")
+	}
+	for _, name := range s.Names {
+		components := strings.Split(name, "/")
+		if s.Version == 2 {
+			for i, component := range components {
+				components[i] = tabEscape(component)
+			}
+			content.WriteString(strings.Join(components, "\t") + "\n")
+		} else {
+			content.WriteString(strings.Join(components, LayoutSeparator(layout)) + "\n")
+		}
+	}
+	tmpPath := fmt.Sprintf("%s.%d", pathName, os.Getpid())
+	err = os.WriteFile(tmpPath, []byte(content.String()), 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing subscriptions: %v", err)
+	}
+	err = SetOwner(tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, pathName)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed replacing subscriptions: %v", err)
+	}
+	return nil
+}
+
+// dovecot escapes tabs and line breaks with \001 in tab-separated files
+var tabEscapes = [][2]string{{"\x01", "\x011"}, {"\t", "\x01t"}, {"\r", "\x01r"}, {"\n", "\x01n"}}
+
+func tabEscape(value string) string {
+	for _, escape := range tabEscapes {
+		value = strings.ReplaceAll(value, escape[0], escape[1])
+	}
+	return value
+}
+
+func tabUnescape(value string) string {
+	if !strings.Contains(value, "\x01") {
+		return value
+	}
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\x01' || i+1 == len(value) {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		i += 1
+		switch value[i] {
+		case '1':
+			unescaped.WriteByte('\x01')
+		case 't':
+			unescaped.WriteByte('\t')
+		case 'r':
+			unescaped.WriteByte('\r')
+		case 'n':
+			unescaped.WriteByte('\n')
+		default:
+			unescaped.WriteByte(value[i])
+		}
+	}
+	return unescaped.String()
+}
+
+// Contains returns true if the folder name is subscribed
+func (s *Subscriptions) Contains(name string) bool {
+	for _, subscribed := range s.Names {
+		if subscribed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Add subscribes a folder name, returning false if it was already subscribed
+func (s *Subscriptions) Add(name string) bool {
+	if s.Contains(name) {
+		return false
+	}
+	s.Names = append(s.Names, name)
+	return true
+}
+
+// Remove unsubscribes a folder name and, if children is set, its children,
+// returning the number of names removed
+func (s *Subscriptions) Remove(name string, children bool) int {
+	names := []string{}
+	for _, subscribed := range s.Names {
+		if subscribed == name || (children && strings.HasPrefix(subscribed, name+"/")) {
+			continue
+		}
+		names = append(names, subscribed)
+	}
+	count := len(s.Names) - len(names)
+	s.Names = names
+	return count
+}
+
+// Rename changes a subscribed folder name and the names of its children,
+// returning the number of names changed
+func (s *Subscriptions) Rename(oldName, newName string) int {
+	count := 0
+	for i, subscribed := range s.Names {
+		if subscribed == oldName {
+			s.Names[i] = newName
+			count += 1
+		} else if strings.HasPrefix(subscribed, oldName+"/") {
+			s.Names[i] = newName + strings.TrimPrefix(subscribed, oldName)
+			count += 1
+		}
+	}
+	return count
+}
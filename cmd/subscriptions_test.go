@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubscriptionsFormats(t *testing.T) {
+	root := makeTestMaildir(t)
+	pathName := filepath.Join(root, SubscriptionsFile)
+	require.Nil(t, os.WriteFile(pathName, []byte("V\t2\n\nINBOX\nArchive\t2024\nTab\x01tName\n"), 0600))
+	subscriptions, err := ReadSubscriptions(root, LayoutMaildirPlusPlus)
+	require.Nil(t, err)
+	require.Equal(t, 2, subscriptions.Version)
+	require.Equal(t, []string{"INBOX", "Archive/2024", "Tab\tName"}, subscriptions.Names)
+	require.Nil(t, subscriptions.Write(root, LayoutMaildirPlusPlus))
+	data, err := os.ReadFile(pathName)
+	require.Nil(t, err)
+	require.Equal(t, "V\t2\n\nINBOX\nArchive\t2024\nTab\x01tName\n", string(data))
+
+	require.Nil(t, os.WriteFile(pathName, []byte("INBOX\nArchive.2024\n"), 0600))
+	subscriptions, err = ReadSubscriptions(root, LayoutMaildirPlusPlus)
+	require.Nil(t, err)
+	require.Equal(t, 1, subscriptions.Version)
+	require.Equal(t, []string{"INBOX", "Archive/2024"}, subscriptions.Names)
+}
+
+func TestCheckSubscriptions(t *testing.T) {
+	root := makeTestMaildir(t)
+	pathName := filepath.Join(root, SubscriptionsFile)
+	require.Nil(t, os.WriteFile(pathName, []byte("INBOX\nGone\n"), 0600))
+	subscriptions, err := ReadSubscriptions(root, LayoutMaildirPlusPlus)
+	require.Nil(t, err)
+	broken, unsubscribed, err := SubscriptionProblems(root, subscriptions)
+	require.Nil(t, err)
+	require.Equal(t, []string{"Gone"}, broken)
+	require.Equal(t, []string{"Archive"}, unsubscribed)
+
+	viper.Set("subscriptions.fix", true)
+	require.Nil(t, CheckSubscriptions([]string{root}))
+	data, err := os.ReadFile(pathName)
+	require.Nil(t, err)
+	require.Equal(t, "INBOX\nArchive\n", string(data))
+
+	require.NotNil(t, AddSubscription([]string{"Missing", root}))
+	require.Nil(t, RemoveSubscription([]string{"Archive", root}))
+	require.NotNil(t, RemoveSubscription([]string{"Archive", root}))
+}
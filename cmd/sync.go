@@ -0,0 +1,558 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const SyncStateFile = "dovecot-maildir-sync"
+
+// conflict rules of the sync command
+const (
+	SyncPreferA = "a"
+	SyncPreferB = "b"
+	SyncUnion   = "union"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync A B",
+	Short: "synchronize two root maildirs",
+	Long: `
+Reconcile the folders and messages of the root maildirs A and B, which should
+not be in use while they are synced.  Messages are matched by their unique
+base name, or by the hash of their decompressed content, so compressed and
+uncompressed copies of a message are the same message.
+
+Folders and messages found on one side only are copied to the other.  This
+includes unseen messages in new, whose copies are delivered to cur.  Flag and
+keyword changes and expunges made on either side since the last sync are
+applied to the other side.  Folder deletions are not propagated.  The state
+of each sync is stored in A/dovecot-maildir-sync; without it nothing is
+expunged.
+
+A conflict is a message whose flags changed on both sides, or which was
+expunged on one side and changed on the other.  The --conflict rule resolves
+it: 'a' or 'b' keeps that side's version, and 'union' merges the flags and
+keeps a changed message rather than expunging it.
+
+Flags:
+    --conflict RULE	    resolve conflicts with a, b or union (default union)
+    --state PATH	    read and write the sync state at PATH
+    --dry-run		    output the changes without making them
+    --reset-index MODE	    remove the cache or all index files of changed maildirs
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(SyncMaildirs(args))
+	},
+}
+
+// SyncState records the messages present on both sides after each sync, by
+// folder name, for each peer of the A root
+type SyncState struct {
+	Peers map[string]map[string][]SyncStateEntry `json:"peers"`
+}
+
+// SyncStateEntry is a message pair and the flags it had when last synced
+type SyncStateEntry struct {
+	A     string   `json:"a"`
+	B     string   `json:"b"`
+	Flags []string `json:"flags"`
+}
+
+// ReadSyncState reads a sync state file; a missing file yields an empty state
+func ReadSyncState(pathName string) (*SyncState, error) {
+	state := SyncState{Peers: map[string]map[string][]SyncStateEntry{}}
+	data, err := os.ReadFile(pathName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &state, nil
+		}
+		return nil, fmt.Errorf("failed reading sync state: %v", err)
+	}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pathName, err)
+	}
+	if state.Peers == nil {
+		state.Peers = map[string]map[string][]SyncStateEntry{}
+	}
+	return &state, nil
+}
+
+// Write replaces a sync state file
+func (s *SyncState) Write(pathName string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed formatting sync state: %v", err)
+	}
+	tmpPath := fmt.Sprintf("%s.%d", pathName, os.Getpid())
+	err = os.WriteFile(tmpPath, append(data, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing sync state: %v", err)
+	}
+	err = os.Rename(tmpPath, pathName)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed replacing sync state: %v", err)
+	}
+	return nil
+}
+
+// SyncFlags returns the sorted standard flags, as \name, and keywords of a message
+func SyncFlags(msg *Message) ([]string, error) {
+	names := []string{}
+	for _, letter := range msg.File.Flags {
+		name, ok := flagNames[letter]
+		if ok {
+			names = append(names, "\\"+name)
+		}
+	}
+	keywords, err := msg.KeywordNames()
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, keywords...)
+	sort.Strings(names)
+	return names, nil
+}
+
+func sameFlags(a, b []string) bool {
+	return strings.Join(a, " ") == strings.Join(b, " ")
+}
+
+func unionFlags(a, b []string) []string {
+	set := map[string]bool{}
+	names := []string{}
+	for _, name := range append(append([]string{}, a...), b...) {
+		if !set[name] {
+			set[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// syncContentHash returns the hash of the normalized decompressed content of a message
+func syncContentHash(msg *Message) (string, error) {
+	data, _, err := ReadMessage(msg.File.Path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(NormalizeMessage(data))), nil
+}
+
+// syncer holds the message movers and counters of a sync
+type syncer struct {
+	moverA    *MessageMover
+	moverB    *MessageMover
+	rule      string
+	dryRun    bool
+	copied    int
+	changed   int
+	expunged  int
+	conflicts int
+}
+
+// syncMessages returns the messages of a folder by base name; a missing
+// maildir has none
+func syncMessages(dir, folder string) (map[string]*Message, error) {
+	messages := map[string]*Message{}
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return messages, nil
+	}
+	uidlist, err := ReadUidlist(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ListMessageFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, pathName := range files {
+		msg, err := NewMessage(dir, folder, uidlist, pathName)
+		if err != nil {
+			return nil, err
+		}
+		messages[msg.File.Base] = msg
+	}
+	return messages, nil
+}
+
+func sortedBases(messages map[string]*Message) []string {
+	bases := []string{}
+	for base := range messages {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	return bases
+}
+
+// copy copies a message to the maildir of the other side and returns the
+// state entry of the pair
+func (s *syncer) copy(msg *Message, dir string, toB bool) (*SyncStateEntry, error) {
+	s.copied += 1
+	if s.dryRun {
+		fmt.Printf("would copy %s -> %s\n", msg.File.Path, dir)
+		return nil, nil
+	}
+	mover := s.moverA
+	if toB {
+		mover = s.moverB
+	}
+	target, err := mover.Copy(msg, dir)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("copied %s -> %s\n", msg.File.Path, target)
+	flags, err := SyncFlags(msg)
+	if err != nil {
+		return nil, err
+	}
+	copied, err := ParseMessageFile(target)
+	if err != nil {
+		return nil, err
+	}
+	if toB {
+		return &SyncStateEntry{A: msg.File.Base, B: copied.Base, Flags: flags}, nil
+	}
+	return &SyncStateEntry{A: copied.Base, B: msg.File.Base, Flags: flags}, nil
+}
+
+// expunge removes a message expunged on the other side
+func (s *syncer) expunge(msg *Message, mover *MessageMover) error {
+	s.expunged += 1
+	if s.dryRun {
+		fmt.Printf("would expunge %s\n", msg.File.Path)
+		return nil
+	}
+	err := mover.Remove(msg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("expunged %s\n", msg.File.Path)
+	return nil
+}
+
+// setFlags renames a message whose flags differ from names
+func (s *syncer) setFlags(msg *Message, mover *MessageMover, names []string) error {
+	flags, err := SyncFlags(msg)
+	if err != nil {
+		return err
+	}
+	if sameFlags(flags, names) {
+		return nil
+	}
+	s.changed += 1
+	if s.dryRun {
+		fmt.Printf("would set flags of %s to [%s]\n", msg.File.Path, strings.Join(names, " "))
+		return nil
+	}
+	target, err := mover.SetFlags(msg, names)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("renamed %s -> %s\n", msg.File.Path, target)
+	return nil
+}
+
+// resolve brings the flags of a message pair into agreement, using the flags
+// of the last sync if known, and returns the state entry of the pair
+func (s *syncer) resolve(a, b *Message, last []string) (*SyncStateEntry, error) {
+	flagsA, err := SyncFlags(a)
+	if err != nil {
+		return nil, err
+	}
+	flagsB, err := SyncFlags(b)
+	if err != nil {
+		return nil, err
+	}
+	flags := flagsA
+	switch {
+	case sameFlags(flagsA, flagsB):
+	case last != nil && sameFlags(flagsA, last):
+		flags = flagsB
+	case last != nil && sameFlags(flagsB, last):
+		flags = flagsA
+	default:
+		s.conflicts += 1
+		fmt.Printf("conflict %s [%s] %s [%s]\n", a.File.Path, strings.Join(flagsA, " "), b.File.Path, strings.Join(flagsB, " "))
+		switch s.rule {
+		case SyncPreferB:
+			flags = flagsB
+		case SyncUnion:
+			flags = unionFlags(flagsA, flagsB)
+		}
+	}
+	err = s.setFlags(a, s.moverA, flags)
+	if err != nil {
+		return nil, err
+	}
+	err = s.setFlags(b, s.moverB, flags)
+	if err != nil {
+		return nil, err
+	}
+	return &SyncStateEntry{A: a.File.Base, B: b.File.Base, Flags: flags}, nil
+}
+
+// syncFolder reconciles the messages of a folder and returns its new state
+func (s *syncer) syncFolder(folder, dirA, dirB string, last []SyncStateEntry) ([]SyncStateEntry, error) {
+	messagesA, err := syncMessages(dirA, folder)
+	if err != nil {
+		return nil, err
+	}
+	messagesB, err := syncMessages(dirB, folder)
+	if err != nil {
+		return nil, err
+	}
+	entries := []SyncStateEntry{}
+	add := func(entry *SyncStateEntry) {
+		if entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+
+	// pairs known from the last sync
+	for _, pair := range last {
+		a, b := messagesA[pair.A], messagesB[pair.B]
+		delete(messagesA, pair.A)
+		delete(messagesB, pair.B)
+		var entry *SyncStateEntry
+		switch {
+		case a != nil && b != nil:
+			entry, err = s.resolve(a, b, pair.Flags)
+		case a != nil:
+			entry, err = s.unpaired(a, dirB, pair.Flags, true)
+		case b != nil:
+			entry, err = s.unpaired(b, dirA, pair.Flags, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		add(entry)
+	}
+
+	// new pairs matched by base name
+	for _, base := range sortedBases(messagesA) {
+		b, ok := messagesB[base]
+		if !ok {
+			continue
+		}
+		entry, err := s.resolve(messagesA[base], b, nil)
+		if err != nil {
+			return nil, err
+		}
+		add(entry)
+		delete(messagesA, base)
+		delete(messagesB, base)
+	}
+
+	// new pairs matched by content
+	hashes := map[string][]*Message{}
+	for _, base := range sortedBases(messagesB) {
+		hash, err := syncContentHash(messagesB[base])
+		if err != nil {
+			return nil, err
+		}
+		hashes[hash] = append(hashes[hash], messagesB[base])
+	}
+	for _, base := range sortedBases(messagesA) {
+		hash, err := syncContentHash(messagesA[base])
+		if err != nil {
+			return nil, err
+		}
+		matches := hashes[hash]
+		if len(matches) == 0 {
+			continue
+		}
+		b := matches[0]
+		hashes[hash] = matches[1:]
+		entry, err := s.resolve(messagesA[base], b, nil)
+		if err != nil {
+			return nil, err
+		}
+		add(entry)
+		delete(messagesA, base)
+		delete(messagesB, b.File.Base)
+	}
+
+	// messages on one side only
+	for _, base := range sortedBases(messagesA) {
+		entry, err := s.copy(messagesA[base], dirB, true)
+		if err != nil {
+			return nil, err
+		}
+		add(entry)
+	}
+	for _, base := range sortedBases(messagesB) {
+		entry, err := s.copy(messagesB[base], dirA, false)
+		if err != nil {
+			return nil, err
+		}
+		add(entry)
+	}
+	return entries, nil
+}
+
+// unpaired handles a synced message which is missing on the other side; it
+// is expunged unless its flags changed and the conflict rule keeps it, in
+// which case it is copied back
+func (s *syncer) unpaired(msg *Message, otherDir string, last []string, onA bool) (*SyncStateEntry, error) {
+	flags, err := SyncFlags(msg)
+	if err != nil {
+		return nil, err
+	}
+	mover := s.moverB
+	if onA {
+		mover = s.moverA
+	}
+	if sameFlags(flags, last) {
+		return nil, s.expunge(msg, mover)
+	}
+	s.conflicts += 1
+	fmt.Printf("conflict %s changed and expunged\n", msg.File.Path)
+	keep := s.rule == SyncUnion || (onA && s.rule == SyncPreferA) || (!onA && s.rule == SyncPreferB)
+	if !keep {
+		return nil, s.expunge(msg, mover)
+	}
+	return s.copy(msg, otherDir, onA)
+}
+
+// syncFolderDir returns the maildir of a folder, creating it unless dryRun is set
+func syncFolderDir(root, folder string, dryRun bool) (string, error) {
+	dir := FolderPath(root, folder)
+	_, err := os.Stat(dir)
+	if err == nil || !os.IsNotExist(err) || dryRun {
+		return dir, nil
+	}
+	err = MakeMaildir(root, dir)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("created folder %s\n", dir)
+	return dir, nil
+}
+
+func SyncMaildirs(args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	rule := viper.GetString("sync.conflict")
+	if rule != SyncPreferA && rule != SyncPreferB && rule != SyncUnion {
+		return fmt.Errorf("unknown conflict rule: %s", rule)
+	}
+	a, b := args[0], args[1]
+	for _, root := range []string{a, b} {
+		maildir, err := IsMaildir(root)
+		if err != nil || !maildir {
+			return fmt.Errorf("not a maildir: %s", root)
+		}
+	}
+	peer, err := filepath.Abs(b)
+	if err != nil {
+		return fmt.Errorf("failed resolving %s: %v", b, err)
+	}
+	statePath := viper.GetString("sync.state")
+	if statePath == "" {
+		statePath = filepath.Join(a, SyncStateFile)
+	}
+	state, err := ReadSyncState(statePath)
+	if err != nil {
+		return err
+	}
+	last := state.Peers[peer]
+	viper.Set("all", true)
+
+	folders := []string{}
+	seen := map[string]bool{}
+	for _, root := range []string{a, b} {
+		list, err := ListFolders(root)
+		if err != nil {
+			return err
+		}
+		for _, folder := range list {
+			if !seen[folder.Name] {
+				seen[folder.Name] = true
+				folders = append(folders, folder.Name)
+			}
+		}
+	}
+
+	s := syncer{moverA: NewMessageMover(a), moverB: NewMessageMover(b), rule: rule, dryRun: dryRun}
+	synced := map[string][]SyncStateEntry{}
+	for _, folder := range folders {
+		dirA, err := syncFolderDir(a, folder, dryRun)
+		if err != nil {
+			return err
+		}
+		dirB, err := syncFolderDir(b, folder, dryRun)
+		if err != nil {
+			return err
+		}
+		entries, err := s.syncFolder(folder, dirA, dirB, last[folder])
+		if err != nil {
+			return err
+		}
+		synced[folder] = entries
+	}
+	fmt.Printf("%d copied, %d flag changes, %d expunged, %d conflicts\n", s.copied, s.changed, s.expunged, s.conflicts)
+	if dryRun {
+		return nil
+	}
+	err = s.moverA.Close()
+	if err != nil {
+		return err
+	}
+	err = s.moverB.Close()
+	if err != nil {
+		return err
+	}
+	state.Peers[peer] = synced
+	return state.Write(statePath)
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().String("conflict", SyncUnion, "resolve conflicts with a, b or union")
+	viper.BindPFlag("sync.conflict", syncCmd.Flags().Lookup("conflict"))
+	syncCmd.Flags().String("state", "", "sync state file (default A/dovecot-maildir-sync)")
+	viper.BindPFlag("sync.state", syncCmd.Flags().Lookup("state"))
+}
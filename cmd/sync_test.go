@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// findTestMessage returns the pathname of the single message with a base name
+func findTestMessage(t *testing.T, dir, base string) string {
+	files, err := filepath.Glob(filepath.Join(dir, "cur", base+"*"))
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	return files[0]
+}
+
+func renameTestMessage(t *testing.T, dir, base, flags string) {
+	pathName := findTestMessage(t, dir, base)
+	msg, err := ParseMessageFile(pathName)
+	require.Nil(t, err)
+	require.Nil(t, os.Rename(pathName, filepath.Join(dir, "cur", msg.Filename(flags))))
+}
+
+func TestSyncMaildirs(t *testing.T) {
+	b := makeTestMaildir(t)
+	a := makeTestMaildir(t)
+	// B holds a gzip copy of the plain message under another name
+	plain := findTestMessage(t, b, "1711929600.M2P1.host")
+	require.Nil(t, os.Remove(plain))
+	writeTestMessage(t, b, "1711929600.M9P9.host", "", testMessage("bob@example.com", "april plans", "Mon, 01 Apr 2024 10:00:00 +0000", "holiday schedule"), "gzip")
+	viper.Set("sync.conflict", SyncUnion)
+
+	require.Nil(t, SyncMaildirs([]string{a, b}))
+	state, err := ReadSyncState(filepath.Join(a, SyncStateFile))
+	require.Nil(t, err)
+	peer, err := filepath.Abs(b)
+	require.Nil(t, err)
+	require.Len(t, state.Peers[peer]["INBOX"], 2)
+	require.Len(t, state.Peers[peer]["Archive"], 1)
+	files, err := filepath.Glob(filepath.Join(a, "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 2)
+
+	// a flag change on A, an expunge and a new message on B and a conflict
+	renameTestMessage(t, a, "1711929600.M2P1.host", "F")
+	renameTestMessage(t, a, "1709251200.M1P1.host", "RS")
+	renameTestMessage(t, b, "1709251200.M1P1.host", "FS")
+	require.Nil(t, os.Remove(findTestMessage(t, filepath.Join(b, ".Archive"), "1704067200.M3P1.host")))
+	writeTestMessage(t, b, "1714521600.M4P1.host", "S", testMessage("carol@example.com", "may notes", "Wed, 01 May 2024 10:00:00 +0000", "garden"), "")
+
+	viper.Set("dry-run", true)
+	require.Nil(t, SyncMaildirs([]string{a, b}))
+	findTestMessage(t, filepath.Join(a, ".Archive"), "1704067200.M3P1.host")
+
+	viper.Set("dry-run", false)
+	require.Nil(t, SyncMaildirs([]string{a, b}))
+	require.Contains(t, findTestMessage(t, b, "1711929600.M9P9.host"), ":2,F")
+	require.Contains(t, findTestMessage(t, a, "1709251200.M1P1.host"), ":2,FRS")
+	require.Contains(t, findTestMessage(t, b, "1709251200.M1P1.host"), ":2,FRS")
+	files, err = filepath.Glob(filepath.Join(a, ".Archive", "cur", "*"))
+	require.Nil(t, err)
+	require.Empty(t, files)
+	files, err = filepath.Glob(filepath.Join(a, "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 3)
+	uidlist, err := ReadUidlist(a)
+	require.Nil(t, err)
+	require.Len(t, uidlist.Entries, 3)
+
+	// a further sync has nothing to do
+	state, err = ReadSyncState(filepath.Join(a, SyncStateFile))
+	require.Nil(t, err)
+	require.Len(t, state.Peers[peer]["INBOX"], 3)
+	require.Nil(t, SyncMaildirs([]string{a, b}))
+	files, err = filepath.Glob(filepath.Join(b, "cur", "*"))
+	require.Nil(t, err)
+	require.Len(t, files, 3)
+}
+
+func TestSyncNewMessages(t *testing.T) {
+	a := makeTestMaildir(t)
+	b := makeTestMaildir(t)
+	unseen := filepath.Join(b, "new", "1714521600.M4P1.host")
+	require.Nil(t, os.WriteFile(unseen, testMessage("carol@example.com", "may notes", "Wed, 01 May 2024 10:00:00 +0000", "garden"), 0600))
+	viper.Set("sync.conflict", SyncUnion)
+	viper.Set("dry-run", false)
+
+	// a message in new on B is copied to cur on A
+	require.Nil(t, SyncMaildirs([]string{a, b}))
+	state, err := ReadSyncState(filepath.Join(a, SyncStateFile))
+	require.Nil(t, err)
+	peer, err := filepath.Abs(b)
+	require.Nil(t, err)
+	copied := ""
+	for _, entry := range state.Peers[peer]["INBOX"] {
+		if entry.B == "1714521600.M4P1.host" {
+			copied = entry.A
+		}
+	}
+	require.NotEmpty(t, copied)
+
+	// flagging the copy moves the message on B from new to cur
+	renameTestMessage(t, a, copied, "F")
+	require.Nil(t, SyncMaildirs([]string{a, b}))
+	require.NoFileExists(t, unseen)
+	require.Contains(t, findTestMessage(t, b, "1714521600.M4P1.host"), ":2,F")
+}
@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const UidlistFile = "dovecot-uidlist"
+
+// Uidlist holds the contents of a dovecot-uidlist file
+type Uidlist struct {
+	Version     int
+	UidValidity uint32
+	NextUid     uint32
+	Header      []string
+	Entries     []UidlistEntry
+	index       map[string]int
+	baseIndex   map[string]int
+}
+
+// UidlistEntry is a single message line of a dovecot-uidlist file
+type UidlistEntry struct {
+	Uid    uint32
+	Fields []string
+	Name   string
+}
+
+// ReadUidlist reads the dovecot-uidlist file of a maildir; a missing file
+// yields an empty list
+func ReadUidlist(dir string) (*Uidlist, error) {
+	uidlist := Uidlist{Version: 3, NextUid: 1, index: map[string]int{}, baseIndex: map[string]int{}}
+	file, err := os.Open(filepath.Join(dir, UidlistFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &uidlist, nil
+		}
+		return nil, fmt.Errorf("failed opening uidlist: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber += 1
+		if lineNumber == 1 {
+			err := uidlist.parseHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file.Name(), err)
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseUidlistEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", file.Name(), lineNumber, err)
+		}
+		uidlist.add(*entry)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading uidlist: %v", err)
+	}
+	return &uidlist, nil
+}
+
+func (u *Uidlist) parseHeader(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty uidlist header")
+	}
+	version, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("invalid uidlist version: %s", fields[0])
+	}
+	u.Version = version
+	if version == 1 {
+		if len(fields) < 3 {
+			return fmt.Errorf("invalid uidlist header: %s", line)
+		}
+		validity, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid uidvalidity: %s", fields[1])
+		}
+		next, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid next uid: %s", fields[2])
+		}
+		u.UidValidity = uint32(validity)
+		u.NextUid = uint32(next)
+		return nil
+	}
+	for _, field := range fields[1:] {
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case 'V':
+			validity, err := strconv.ParseUint(field[1:], 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid uidvalidity: %s", field)
+			}
+			u.UidValidity = uint32(validity)
+		case 'N':
+			next, err := strconv.ParseUint(field[1:], 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid next uid: %s", field)
+			}
+			u.NextUid = uint32(next)
+		default:
+			u.Header = append(u.Header, field)
+		}
+	}
+	return nil
+}
+
+func parseUidlistEntry(line string) (*UidlistEntry, error) {
+	uidStr, rest, _ := strings.Cut(line, " ")
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid: %s", uidStr)
+	}
+	entry := UidlistEntry{Uid: uint32(uid)}
+	fields, name, found := strings.Cut(rest, ":")
+	if !found {
+		// version 1 lines have no extension fields
+		entry.Name = strings.TrimSpace(rest)
+	} else {
+		entry.Fields = strings.Fields(fields)
+		entry.Name = name
+	}
+	if entry.Name == "" {
+		return nil, fmt.Errorf("missing filename")
+	}
+	return &entry, nil
+}
+
+func (u *Uidlist) add(entry UidlistEntry) {
+	u.index[entry.Name] = len(u.Entries)
+	base, _, _ := strings.Cut(entry.Name, ",")
+	u.baseIndex[base] = len(u.Entries)
+	u.Entries = append(u.Entries, entry)
+}
+
+// Lookup returns the uid assigned to a message filename, ignoring the info
+// suffix and falling back to the unique base name
+func (u *Uidlist) Lookup(filename string) (uint32, bool) {
+	entry, ok := u.Entry(filename)
+	if !ok {
+		return 0, false
+	}
+	return entry.Uid, true
+}
+
+// Entry returns the uidlist entry of a message filename, matched as Lookup does
+func (u *Uidlist) Entry(filename string) (*UidlistEntry, bool) {
+	name, _, _ := strings.Cut(filepath.Base(filename), ":")
+	i, ok := u.index[name]
+	if !ok {
+		base, _, _ := strings.Cut(name, ",")
+		i, ok = u.baseIndex[base]
+		if !ok {
+			return nil, false
+		}
+	}
+	return &u.Entries[i], true
+}
+
+// Field returns the value of the extension field with the given key letter
+func (e *UidlistEntry) Field(key byte) (string, bool) {
+	for _, field := range e.Fields {
+		if len(field) > 0 && field[0] == key {
+			return field[1:], true
+		}
+	}
+	return "", false
+}
+
+// Append assigns the next uid to a message filename and returns it
+func (u *Uidlist) Append(filename string) uint32 {
+	name, _, _ := strings.Cut(filepath.Base(filename), ":")
+	uid := u.NextUid
+	if uid == 0 {
+		uid = 1
+	}
+	u.add(UidlistEntry{Uid: uid, Name: name})
+	u.NextUid = uid + 1
+	return uid
+}
+
+// AppendUid adds a message filename with a known uid and extension fields,
+// keeping NextUid above it; uids must be added in ascending order
+func (u *Uidlist) AppendUid(filename string, uid uint32, fields ...string) error {
+	if len(u.Entries) > 0 && uid <= u.Entries[len(u.Entries)-1].Uid {
+		return fmt.Errorf("uid %d is not above the last uid of the uidlist", uid)
+	}
+	name, _, _ := strings.Cut(filepath.Base(filename), ":")
+	u.add(UidlistEntry{Uid: uid, Fields: fields, Name: name})
+	if uid >= u.NextUid {
+		u.NextUid = uid + 1
+	}
+	return nil
+}
+
+// Write replaces the dovecot-uidlist file of a maildir, creating it through
+// the dovecot-uidlist.lock file which dovecot uses as its lock
+func (u *Uidlist) Write(dir string) error {
+	if u.UidValidity == 0 {
+		u.UidValidity = uint32(time.Now().Unix())
+	}
+	pathName := filepath.Join(dir, UidlistFile)
+	lockPath := pathName + ".lock"
+	stat, err := os.Stat(pathName)
+	if os.IsNotExist(err) {
+		stat, err = os.Stat(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("Stat failed: %v", err)
+	}
+	file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("uidlist is locked: %s", lockPath)
+		}
+		return fmt.Errorf("failed creating uidlist lock: %v", err)
+	}
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "3 V%d N%d", u.UidValidity, u.NextUid)
+	for _, field := range u.Header {
+		fmt.Fprintf(writer, " %s", field)
+	}
+	fmt.Fprintf(writer, "\n")
+	for _, entry := range u.Entries {
+		fmt.Fprintf(writer, "%d", entry.Uid)
+		for _, field := range entry.Fields {
+			fmt.Fprintf(writer, " %s", field)
+		}
+		fmt.Fprintf(writer, " :%s\n", entry.Name)
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("failed writing uidlist: %v", err)
+	}
+	if !stat.IsDir() {
+		err = os.Chmod(lockPath, stat.Mode())
+		if err != nil {
+			os.Remove(lockPath)
+			return fmt.Errorf("mode change failed on '%s': %v", lockPath, err)
+		}
+	}
+	err = SetOwner(lockPath, stat)
+	if err != nil {
+		os.Remove(lockPath)
+		return err
+	}
+	err = os.Rename(lockPath, pathName)
+	if err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("failed replacing uidlist: %v", err)
+	}
+	return nil
+}
+
+// Remove deletes the entry for a message filename, returning false if it is not listed
+func (u *Uidlist) Remove(filename string) bool {
+	name, _, _ := strings.Cut(filepath.Base(filename), ":")
+	i, ok := u.index[name]
+	if !ok {
+		base, _, _ := strings.Cut(name, ",")
+		i, ok = u.baseIndex[base]
+		if !ok {
+			return false
+		}
+	}
+	entries := append(u.Entries[:i:i], u.Entries[i+1:]...)
+	u.Entries = []UidlistEntry{}
+	u.index = map[string]int{}
+	u.baseIndex = map[string]int{}
+	for _, entry := range entries {
+		u.add(entry)
+	}
+	return true
+}
+
+// dovecot overrides a uidlist lock left unmodified for this long
+const uidlistLockStaleTimeout = 2 * time.Minute
+
+// LockUidlist creates the dovecot-uidlist.lock file of a maildir the way
+// dovecot does, returning false if dovecot or another process holds the lock.
+// A stale lock is replaced.
+func LockUidlist(dir string) (bool, error) {
+	lockPath := filepath.Join(dir, UidlistFile+".lock")
+	file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		var locked bool
+		locked, err = uidlistLocked(dir)
+		if err != nil || locked {
+			return false, err
+		}
+		err = os.Remove(lockPath)
+		if err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed removing stale uidlist lock: %v", err)
+		}
+		file, err = os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed creating uidlist lock: %v", err)
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(lockPath)
+		return false, fmt.Errorf("failed creating uidlist lock: %v", err)
+	}
+	return true, nil
+}
+
+// uidlistLocked returns true if a maildir has a uidlist lock that is not stale
+func uidlistLocked(dir string) (bool, error) {
+	stat, err := os.Stat(filepath.Join(dir, UidlistFile+".lock"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("Stat failed: %v", err)
+	}
+	return time.Since(stat.ModTime()) < uidlistLockStaleTimeout, nil
+}
+
+// UnlockUidlist removes a lock taken with LockUidlist
+func UnlockUidlist(dir string) error {
+	err := os.Remove(filepath.Join(dir, UidlistFile+".lock"))
+	if err != nil {
+		return fmt.Errorf("failed removing uidlist lock: %v", err)
+	}
+	return nil
+}
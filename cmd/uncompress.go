@@ -44,6 +44,9 @@ var uncompressCmd = &cobra.Command{
 Uncompress Zstandard-compressed files in cur subdirectory of specified maildir
 Default DIR is ~/Maildir
 Use --recurse to uncompress files in all maildirs rooted at DIR
+Use --folder to uncompress files in the named folder of DIR
+The maildirsize file of DIR is rebuilt after changes unless --skip-quota is given
+Use --reset-index to remove stale dovecot index files of changed maildirs
 `,
 	Args: cobra.RangeArgs(0, 1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -58,23 +61,37 @@ func init() {
 func UncompressMaildirFiles(args []string) error {
 	viper.Set("uncompressed", false)
 	viper.Set("all", false)
-	dirs, err := ListMaildirs(MaildirRoot(args))
+	root := MaildirRoot(args)
+	dirs, err := SelectMaildirs(root)
 	if err != nil {
 		return err
 	}
-	for _, dir := range *dirs {
+	changed := []string{}
+	for _, dir := range dirs {
 		files, err := ListMaildirFiles(dir)
 		if err != nil {
 			return err
 		}
+		if len(*files) > 0 {
+			changed = append(changed, dir)
+		}
 		for _, file := range *files {
 			fmt.Printf("uncompressing %s\n", file)
 			err = UncompressFile(file)
 			if err != nil {
+				// files uncompressed so far must not keep their stale cache
+				ResetIndexes(changed)
 				return err
 			}
 		}
 	}
-	return nil
+	err = ResetIndexes(changed)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return UpdateQuota(root)
 
 }
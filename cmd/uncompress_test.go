@@ -3,6 +3,8 @@ package cmd
 import (
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -12,3 +14,40 @@ func TestUncompressFiles(t *testing.T) {
 	err := UncompressMaildirFiles([]string{"testdata/Maildir"})
 	require.Nil(t, err)
 }
+
+func TestUncompressResetsIndexOnError(t *testing.T) {
+	root := makeTestMaildir(t)
+	// a compressed message whose S= does not match its content fails
+	data := testMessage("carol@example.com", "bad size", "Wed, 01 May 2024 10:00:00 +0000", "mismatch")
+	pathName := writeTestMessage(t, root, "1714521600.M4P1.host", "", data, "gzip")
+	require.Nil(t, os.Rename(pathName, filepath.Join(root, "cur", "1714521600.M4P1.host,S=1,W=2:2,")))
+	cache := filepath.Join(root, IndexCacheFile)
+	require.Nil(t, os.WriteFile(cache, []byte("stale"), 0600))
+	viper.Set("reset-index", "cache")
+
+	require.NotNil(t, UncompressMaildirFiles([]string{root}))
+	_, cmpType, err := ReadMessage(findTestMessage(t, root, "1709251200.M1P1.host"))
+	require.Nil(t, err)
+	require.Empty(t, cmpType)
+	require.NoFileExists(t, cache)
+}
+
+func TestUncompressKeepsQuotaWhenUnchanged(t *testing.T) {
+	root := makeTestMaildir(t)
+	maildirsize := filepath.Join(root, MaildirsizeFile)
+	content := []byte("1000000S\n100 2\n5 1\n")
+	require.Nil(t, os.WriteFile(maildirsize, content, 0600))
+
+	// the compressed Archive message is uncompressed by the first run only
+	viper.Set("folder", "Archive")
+	require.Nil(t, UncompressMaildirFiles([]string{root}))
+	rebuilt, err := os.ReadFile(maildirsize)
+	require.Nil(t, err)
+	require.NotEqual(t, content, rebuilt)
+
+	require.Nil(t, os.WriteFile(maildirsize, content, 0600))
+	require.Nil(t, UncompressMaildirFiles([]string{root}))
+	unchanged, err := os.ReadFile(maildirsize)
+	require.Nil(t, err)
+	require.Equal(t, content, unchanged)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// IMAP modified base64 uses ',' in place of '/' and omits padding
+var modifiedBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+// DecodeModifiedUTF7 decodes an IMAP modified UTF-7 mailbox name (RFC 3501 5.1.3)
+func DecodeModifiedUTF7(name string) (string, error) {
+	var decoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 0x20 || c > 0x7e {
+			return "", fmt.Errorf("invalid character in modified UTF-7: %q", name)
+		}
+		if c != '&' {
+			decoded.WriteByte(c)
+			continue
+		}
+		end := strings.IndexByte(name[i+1:], '-')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated modified UTF-7 sequence: %q", name)
+		}
+		encoded := name[i+1 : i+1+end]
+		i += end + 1
+		if encoded == "" {
+			decoded.WriteByte('&')
+			continue
+		}
+		data, err := modifiedBase64.DecodeString(encoded)
+		if err != nil || len(data)%2 != 0 {
+			return "", fmt.Errorf("invalid modified UTF-7 sequence: %q", name)
+		}
+		units := make([]uint16, len(data)/2)
+		for j := range units {
+			units[j] = uint16(data[2*j])<<8 | uint16(data[2*j+1])
+		}
+		decoded.WriteString(string(utf16.Decode(units)))
+	}
+	return decoded.String(), nil
+}
+
+// EncodeModifiedUTF7 encodes a mailbox name as IMAP modified UTF-7
+func EncodeModifiedUTF7(name string) string {
+	var encoded strings.Builder
+	pending := []rune{}
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		units := utf16.Encode(pending)
+		data := make([]byte, len(units)*2)
+		for i, unit := range units {
+			data[2*i] = byte(unit >> 8)
+			data[2*i+1] = byte(unit)
+		}
+		encoded.WriteString("&" + modifiedBase64.EncodeToString(data) + "-")
+		pending = pending[:0]
+	}
+	for _, r := range name {
+		if r == utf8.RuneError || r < 0x20 || r > 0x7e {
+			pending = append(pending, r)
+			continue
+		}
+		flush()
+		if r == '&' {
+			encoded.WriteString("&-")
+		} else {
+			encoded.WriteRune(r)
+		}
+	}
+	flush()
+	return encoded.String()
+}
@@ -0,0 +1,373 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch [DIR]",
+	Short: "compress messages as they arrive in cur",
+	Long: `
+Watch the cur subdirectory of the specified maildir and compress each message
+that appears there once it has been left alone for the settle delay.  The
+default DIR is ~/Maildir.  With --recurse every maildir below DIR is watched,
+including folders created while watch is running.  This provides compression
+for mail delivered by an LDA which cannot compress messages itself.
+
+Messages are compressed in place the way dovecot documents for maildir: the
+compressed copy is written to tmp with the original mod time and ownership,
+then the dovecot-uidlist.lock file is taken just long enough to check that
+the message is unchanged and rename the copy over it.  The filename keeps the
+uncompressed S= and W= sizes.  While dovecot holds the lock the message is
+retried later without being compressed; a lock older than two minutes is
+stale and replaced, as dovecot does.  Messages already compressed are left alone, as are messages
+without an S= size in their filename, since dovecot would otherwise take the
+compressed file size as the message size.
+
+watch runs until it receives SIGTERM or SIGINT.  Messages which have not
+settled by then are not compressed; --scan queues every message in cur at
+startup so they are picked up by the next run.  A systemd unit might contain:
+
+    [Service]
+    Type=simple
+    User=vmail
+    ExecStart=/usr/local/bin/dovecot-maildir watch --recurse --scan /var/vmail/user/Maildir
+    Restart=on-failure
+
+Flags:
+    --settle DURATION	    time a message must be unchanged (default 1m)
+    --compress CODEC	    compression codec: zstd or gzip (default zstd)
+    --scan		    also compress messages already in cur at startup
+    --recurse		    watch each maildir rooted at DIR
+`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(WatchMaildirs(args))
+	},
+}
+
+// CompressMessageFile compresses a message file in place.  The compressed
+// copy is written to tmp first, and the dovecot-uidlist lock of the maildir is
+// held only while checking the message is unchanged and renaming the copy
+// over it.  It returns false without reading the message if the lock is held
+// elsewhere so the caller can retry; messages which are already compressed, have no S= size in their
+// filename, or have been renamed or expunged are done.
+func CompressMessageFile(pathName, codec string) (bool, error) {
+	dir := filepath.Dir(filepath.Dir(pathName))
+	stat, err := os.Lstat(pathName)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Stat failed: %v", err)
+	}
+	if !stat.Mode().IsRegular() {
+		return true, nil
+	}
+	// avoid compressing a copy that could not be renamed into place
+	locked, err := uidlistLocked(dir)
+	if err != nil || locked {
+		return false, err
+	}
+	file, err := ParseMessageFile(pathName)
+	if err != nil {
+		return false, err
+	}
+	data, cmpType, err := ReadMessage(pathName)
+	if err != nil {
+		return false, err
+	}
+	// without a matching S= dovecot takes the size from the file itself
+	if cmpType != "" || file.Size != int64(len(data)) {
+		return true, nil
+	}
+	content, err := CompressData(data, codec)
+	if err != nil {
+		return false, err
+	}
+	tmpPath := filepath.Join(dir, "tmp", filepath.Base(pathName))
+	err = os.WriteFile(tmpPath, content, 0600)
+	if err != nil {
+		return false, fmt.Errorf("failed writing compressed message: %v", err)
+	}
+	err = SetStat(tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	locked, err = LockUidlist(dir)
+	if err != nil || !locked {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	err = replaceUnchanged(pathName, tmpPath, stat)
+	if err != nil {
+		os.Remove(tmpPath)
+		UnlockUidlist(dir)
+		return false, err
+	}
+	return true, UnlockUidlist(dir)
+}
+
+// replaceUnchanged renames tmpPath over pathName unless the message has been
+// renamed, expunged or modified since stat was taken
+func replaceUnchanged(pathName, tmpPath string, stat fs.FileInfo) error {
+	current, err := os.Lstat(pathName)
+	if err != nil || current.Size() != stat.Size() || !current.ModTime().Equal(stat.ModTime()) {
+		os.Remove(tmpPath)
+		return nil
+	}
+	err = os.Rename(tmpPath, pathName)
+	if err != nil {
+		return fmt.Errorf("failed replacing message: %v", err)
+	}
+	return nil
+}
+
+// maildirWatcher queues messages appearing in watched cur directories and
+// compresses them once they have settled
+type maildirWatcher struct {
+	notify  *fsnotify.Watcher
+	codec   string
+	settle  time.Duration
+	recurse bool
+	verbose bool
+	pending map[string]time.Time
+}
+
+// addTree watches dir and the directories below it, stopping at cur
+// directories and skipping tmp and new
+func (w *maildirWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case "tmp", "new":
+			return filepath.SkipDir
+		case "cur":
+			err = w.add(path)
+			if err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		return w.add(path)
+	})
+}
+
+func (w *maildirWatcher) add(dir string) error {
+	err := w.notify.Add(dir)
+	if err != nil {
+		return fmt.Errorf("failed watching %s: %v", dir, err)
+	}
+	if w.verbose {
+		log.Printf("watching %s\n", dir)
+	}
+	return nil
+}
+
+// scan queues the messages already present in a cur directory
+func (w *maildirWatcher) scan(cur string, due time.Time) error {
+	entries, err := os.ReadDir(cur)
+	if err != nil {
+		return fmt.Errorf("ReadDir failed: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			w.pending[filepath.Join(cur, entry.Name())] = due
+		}
+	}
+	return nil
+}
+
+func (w *maildirWatcher) handle(event fsnotify.Event, now time.Time) {
+	if event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+		delete(w.pending, event.Name)
+		return
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	stat, err := os.Lstat(event.Name)
+	if err != nil {
+		return
+	}
+	if stat.IsDir() {
+		if w.recurse && event.Has(fsnotify.Create) {
+			err = w.addTree(event.Name)
+			if err != nil {
+				log.Printf("%v\n", err)
+			}
+		}
+		return
+	}
+	if stat.Mode().IsRegular() && filepath.Base(filepath.Dir(event.Name)) == "cur" {
+		w.pending[event.Name] = now.Add(w.settle)
+	}
+}
+
+// process compresses the pending messages which have settled, requeueing
+// those whose maildir is locked
+func (w *maildirWatcher) process(now time.Time, retry time.Duration) {
+	for pathName, due := range w.pending {
+		if due.After(now) {
+			continue
+		}
+		done, err := CompressMessageFile(pathName, w.codec)
+		if err != nil {
+			log.Printf("%v\n", err)
+			delete(w.pending, pathName)
+			continue
+		}
+		if !done {
+			w.pending[pathName] = now.Add(retry)
+			continue
+		}
+		delete(w.pending, pathName)
+		if w.verbose {
+			log.Printf("processed %s\n", pathName)
+		}
+	}
+}
+
+// Watch compresses messages arriving in the cur directories of the selected
+// maildirs until ctx is done
+func Watch(ctx context.Context, root string) error {
+	codec := viper.GetString("watch.compress")
+	if codec == "" {
+		return fmt.Errorf("a compression codec is required")
+	}
+	err := ValidateCodec(codec)
+	if err != nil {
+		return err
+	}
+	settle := viper.GetDuration("watch.settle")
+	if settle < 0 {
+		return fmt.Errorf("settle delay must not be negative")
+	}
+	dirs, err := SelectMaildirs(root)
+	if err != nil {
+		return err
+	}
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed creating watcher: %v", err)
+	}
+	defer notify.Close()
+	w := maildirWatcher{
+		notify:  notify,
+		codec:   codec,
+		settle:  settle,
+		recurse: viper.GetBool("recurse"),
+		verbose: viper.GetBool("verbose"),
+		pending: map[string]time.Time{},
+	}
+	now := time.Now()
+	for _, dir := range dirs {
+		if w.recurse {
+			err = w.addTree(dir)
+		} else {
+			err = w.add(filepath.Join(dir, "cur"))
+		}
+		if err != nil {
+			return err
+		}
+		if viper.GetBool("watch.scan") {
+			err = w.scan(filepath.Join(dir, "cur"), now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	tick := time.Second
+	if settle > 0 && settle < tick {
+		tick = settle
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if w.verbose {
+				log.Printf("shutting down with %d messages pending\n", len(w.pending))
+			}
+			return nil
+		case event, ok := <-notify.Events:
+			if !ok {
+				return nil
+			}
+			w.handle(event, time.Now())
+		case err, ok := <-notify.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("watch error: %v\n", err)
+		case now := <-ticker.C:
+			w.process(now, tick)
+		}
+	}
+}
+
+func WatchMaildirs(args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	viper.Set("all", true)
+	return Watch(ctx, MaildirRoot(args))
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().Duration("settle", time.Minute, "time a message must be unchanged before it is compressed")
+	viper.BindPFlag("watch.settle", watchCmd.Flags().Lookup("settle"))
+	watchCmd.Flags().String("compress", "zstd", "compression codec: zstd or gzip")
+	viper.BindPFlag("watch.compress", watchCmd.Flags().Lookup("compress"))
+	watchCmd.Flags().Bool("scan", false, "also compress messages already in cur at startup")
+	viper.BindPFlag("watch.scan", watchCmd.Flags().Lookup("scan"))
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompressMessageFile(t *testing.T) {
+	root := makeTestMaildir(t)
+	pathName := findTestMessage(t, root, "1711929600.M2P1.host")
+	stat, err := os.Stat(pathName)
+	require.Nil(t, err)
+
+	// a held uidlist lock defers compression
+	lockPath := filepath.Join(root, UidlistFile+".lock")
+	require.Nil(t, os.WriteFile(lockPath, nil, 0600))
+	done, err := CompressMessageFile(pathName, "zstd")
+	require.Nil(t, err)
+	require.False(t, done)
+	_, cmpType, err := ReadMessage(pathName)
+	require.Nil(t, err)
+	require.Empty(t, cmpType)
+	tmpFiles, err := os.ReadDir(filepath.Join(root, "tmp"))
+	require.Nil(t, err)
+	require.Empty(t, tmpFiles)
+
+	// a lock older than dovecot's stale timeout is replaced
+	old := time.Now().Add(-3 * time.Minute)
+	require.Nil(t, os.Chtimes(lockPath, old, old))
+	done, err = CompressMessageFile(pathName, "zstd")
+	require.Nil(t, err)
+	require.True(t, done)
+	require.NoFileExists(t, lockPath)
+	data, cmpType, err := ReadMessage(pathName)
+	require.Nil(t, err)
+	require.Equal(t, "zstd", cmpType)
+	require.Contains(t, string(data), "holiday schedule")
+	compressed, err := os.Stat(pathName)
+	require.Nil(t, err)
+	require.Equal(t, stat.ModTime(), compressed.ModTime())
+
+	// compressed and missing messages are done
+	done, err = CompressMessageFile(pathName, "gzip")
+	require.Nil(t, err)
+	require.True(t, done)
+	_, cmpType, err = ReadMessage(pathName)
+	require.Nil(t, err)
+	require.Equal(t, "zstd", cmpType)
+	done, err = CompressMessageFile(filepath.Join(root, "cur", "missing:2,"), "zstd")
+	require.Nil(t, err)
+	require.True(t, done)
+
+	// dovecot sizes messages without S= from the file, so they are left alone
+	unsized := filepath.Join(root, "cur", "1714521600.M4P1.host:2,")
+	require.Nil(t, os.WriteFile(unsized, testMessage("carol@example.com", "no size", "Wed, 01 May 2024 10:00:00 +0000", "unsized"), 0600))
+	done, err = CompressMessageFile(unsized, "zstd")
+	require.Nil(t, err)
+	require.True(t, done)
+	_, cmpType, err = ReadMessage(unsized)
+	require.Nil(t, err)
+	require.Empty(t, cmpType)
+	entries, err := os.ReadDir(filepath.Join(root, "tmp"))
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
+
+func TestWatch(t *testing.T) {
+	root := makeTestMaildir(t)
+	viper.Set("watch.compress", "gzip")
+	viper.Set("watch.settle", 50*time.Millisecond)
+	viper.Set("recurse", true)
+	viper.Set("all", true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- Watch(ctx, root)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// delivery into a folder created while watching
+	dir := filepath.Join(root, ".Lists")
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		require.Nil(t, os.MkdirAll(filepath.Join(dir, sub), 0700))
+	}
+	time.Sleep(200 * time.Millisecond)
+	pathName := writeTestMessage(t, dir, "1714521600.M4P1.host", "", testMessage("carol@example.com", "list post", "Wed, 01 May 2024 10:00:00 +0000", "hello list"), "")
+	require.Eventually(t, func() bool {
+		_, cmpType, err := ReadMessage(pathName)
+		return err == nil && cmpType == "gzip"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// messages present at startup are left alone without --scan
+	_, cmpType, err := ReadMessage(findTestMessage(t, root, "1711929600.M2P1.host"))
+	require.Nil(t, err)
+	require.Empty(t, cmpType)
+
+	cancel()
+	require.Nil(t, <-result)
+}